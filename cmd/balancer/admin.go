@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// registerAdminHandlers adds the API that changes the backend pool at
+// runtime. Requests must carry "Authorization: Bearer <token>". Backends
+// added through it are health-checked under healthCtx and take traffic once
+// their first probe succeeds.
+func registerAdminHandlers(mux *http.ServeMux, token string, healthCtx context.Context) {
+	mux.Handle("GET /admin/backends", requireToken(token, http.HandlerFunc(listBackendsHandler)))
+	mux.Handle("POST /admin/backends", requireToken(token, addBackendHandler(healthCtx)))
+	mux.Handle("DELETE /admin/backends/{addr}", requireToken(token, http.HandlerFunc(removeBackendHandler)))
+}
+
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		got, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			http.Error(rw, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(rw, req)
+	})
+}
+
+func listBackendsHandler(rw http.ResponseWriter, _ *http.Request) {
+	pool := currentPool()
+	backends := make([]string, 0, len(pool))
+	for _, server := range pool {
+		backends = append(backends, server.Address)
+	}
+	rw.Header().Set("content-type", "application/json")
+	_ = json.NewEncoder(rw).Encode(map[string][]string{"backends": backends})
+}
+
+func addBackendHandler(healthCtx context.Context) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		var body struct {
+			Address string `json:"address"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			http.Error(rw, "invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if err := validateBackend(body.Address); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		poolMu.Lock()
+		defer poolMu.Unlock()
+		if slices.ContainsFunc(serversPool, func(s *BackendServer) bool { return s.Address == body.Address }) {
+			http.Error(rw, "backend already exists", http.StatusConflict)
+			return
+		}
+		server := &BackendServer{Address: body.Address}
+		serversPool = append(slices.Clip(serversPool), server)
+		startHealthCheck(healthCtx, server)
+		slog.Info("Added backend", "backend", server.Address)
+		rw.WriteHeader(http.StatusCreated)
+	}
+}
+
+func removeBackendHandler(rw http.ResponseWriter, req *http.Request) {
+	addr := req.PathValue("addr")
+
+	poolMu.Lock()
+	defer poolMu.Unlock()
+	i := slices.IndexFunc(serversPool, func(s *BackendServer) bool { return s.Address == addr })
+	if i < 0 {
+		http.Error(rw, "backend not found", http.StatusNotFound)
+		return
+	}
+	server := serversPool[i]
+	serversPool = slices.Delete(slices.Clone(serversPool), i, i+1)
+	if server.stopHealthCheck != nil {
+		server.stopHealthCheck()
+	}
+	backendHealthy.DeleteLabelValues(addr)
+	backendConnections.DeleteLabelValues(addr)
+	slog.Info("Removed backend", "backend", addr)
+	rw.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func adminRequest(t *testing.T, mux *http.ServeMux, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	return rr
+}
+
+func newAdminMux(t *testing.T, pool []*BackendServer) *http.ServeMux {
+	t.Helper()
+	origPool, origStrategy := serversPool, strategy
+	t.Cleanup(func() { serversPool, strategy = origPool, origStrategy })
+	serversPool, strategy = pool, leastConnections{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	mux := http.NewServeMux()
+	registerAdminHandlers(mux, "secret", ctx)
+	return mux
+}
+
+func TestAdmin_RequiresToken(t *testing.T) {
+	mux := newAdminMux(t, nil)
+	for _, auth := range []string{"", "Bearer wrong", "secret"} {
+		req := httptest.NewRequest("GET", "/admin/backends", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code, "Authorization %q", auth)
+	}
+}
+
+func TestAdmin_AddBackend(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	addr := strings.TrimPrefix(backend.URL, "http://")
+	mux := newAdminMux(t, nil)
+
+	rr := adminRequest(t, mux, "POST", "/admin/backends", `{"address": "`+addr+`"}`)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	pool := currentPool()
+	require.Len(t, pool, 1)
+	assert.Equal(t, addr, pool[0].Address)
+	assert.Eventually(t, pool[0].Healthy, time.Second, 10*time.Millisecond,
+		"the new backend should become healthy after its first probe")
+
+	assert.Equal(t, http.StatusConflict, adminRequest(t, mux, "POST", "/admin/backends", `{"address": "`+addr+`"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, adminRequest(t, mux, "POST", "/admin/backends", `{"address": "nope"}`).Code)
+
+	rr = adminRequest(t, mux, "GET", "/admin/backends", "")
+	assert.JSONEq(t, `{"backends": ["`+addr+`"]}`, rr.Body.String())
+}
+
+func TestAdmin_RemoveBackend(t *testing.T) {
+	var hitsA, hitsB atomic.Int32
+	a := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { hitsA.Add(1) }))
+	defer a.Close()
+	b := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { hitsB.Add(1) }))
+	defer b.Close()
+	addrA, addrB := strings.TrimPrefix(a.URL, "http://"), strings.TrimPrefix(b.URL, "http://")
+
+	stopped := false
+	removed := newServer(addrA, 0, true)
+	removed.stopHealthCheck = func() { stopped = true }
+	mux := newAdminMux(t, []*BackendServer{removed, newServer(addrB, 0, true)})
+
+	assert.Equal(t, http.StatusNoContent, adminRequest(t, mux, "DELETE", "/admin/backends/"+addrA, "").Code)
+	assert.True(t, stopped, "removing a backend should stop its health checks")
+	assert.Equal(t, http.StatusNotFound, adminRequest(t, mux, "DELETE", "/admin/backends/"+addrA, "").Code)
+
+	for i := 0; i < 5; i++ {
+		rr := httptest.NewRecorder()
+		dispatch(rr, httptest.NewRequest("GET", "/", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+	assert.Zero(t, hitsA.Load(), "a removed backend should not receive traffic")
+	assert.EqualValues(t, 5, hitsB.Load())
+}
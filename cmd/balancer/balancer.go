@@ -1,41 +1,165 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"log"
-	"math"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/maxnetyaga/architecture-practice-5/httptools"
+	"github.com/maxnetyaga/architecture-practice-5/logging"
 	"github.com/maxnetyaga/architecture-practice-5/signal"
+	"github.com/maxnetyaga/architecture-practice-5/tracing"
 )
 
 var (
 	port       = flag.Int("port", 8090, "load balancer port")
 	timeoutSec = flag.Int("timeout-sec", 3, "request timeout time in seconds")
 	https      = flag.Bool("https", false, "whether backends support HTTPs")
+	// preserveHost only changes the Host header: the connection, and with
+	// -https the TLS server name the certificate is verified against, still
+	// use the backend address, so TLS backends must hold a certificate for
+	// it rather than for the client's host.
+	preserveHost = flag.Bool("preserve-host", false,
+		"send the client's Host header to backends instead of the backend address, for virtual-host routing")
 
 	traceEnabled = flag.Bool("trace", false, "whether to include tracing information into responses")
+	traceAuthor  = flag.String("trace-author", "", "lb-author sent to backends with -trace (defaults to the host name)")
+	tracePrefix  = flag.String("trace-prefix", "lb-", "prefix of the from and latency response headers added with -trace")
+
+	maxConns     = flag.Int("max-conns", 0, "maximum number of simultaneous client connections (0 means unlimited)")
+	readTimeout  = flag.Duration("read-timeout", 10*time.Second, "client connection read timeout")
+	writeTimeout = flag.Duration("write-timeout", 10*time.Second, "client connection write timeout")
+	idleTimeout  = flag.Duration("idle-timeout", 60*time.Second, "client keep-alive connection idle timeout")
+
+	maxConnsPerBackend = flag.Int("max-conns-per-backend", 0,
+		"maximum number of requests in flight to one backend; requests are refused with 503 once every backend is full (0 means unlimited)")
+
+	tieBreak = flag.String("tie-break", tieBreakRoundRobin,
+		"how to choose among healthy servers tied for the fewest connections: 'first', 'random' or 'round-robin'")
+
+	strategyName = flag.String("strategy", strategyLeastConnections,
+		"how to pick a backend: 'least-connections', 'round-robin', 'two-choices', 'consistent-hash' or 'least-latency'")
+	hashBy = flag.String("hash-by", "query:key",
+		"request attribute the consistent-hash strategy routes by: 'query:<param>' or 'header:<name>'")
+
+	maxRetries = flag.Int("max-retries", 1,
+		"how many other backends to try when a backend can't be reached; the request body is buffered to replay it")
+
+	healthInterval = flag.Duration("health-interval", 3*time.Second, "how often backends are health-checked")
+	healthPath     = flag.String("health-path", "/health", "backend path probed by health checks")
+
+	drainTimeout = flag.Duration("drain-timeout", 10*time.Second,
+		"how long shutdown waits for in-flight requests before exiting with an error")
+
+	breakerFailures = flag.Int("breaker-failures", 5,
+		"consecutive forward failures that take a backend out of rotation; 0 disables the circuit breaker")
+	breakerCooldown = flag.Duration("breaker-cooldown", 30*time.Second,
+		"how long a tripped backend stays out of rotation before a request probes it")
+
+	rateLimit = flag.Float64("rate", 0,
+		"requests per second allowed from each client IP; 0 disables rate limiting")
+	rateBurst = flag.Int("burst", 10,
+		"requests a client IP may send at once before -rate applies")
+	trustForwardedFor = flag.Bool("trust-forwarded-for", false,
+		"rate-limit by the first X-Forwarded-For address instead of the connection's address")
+
+	bodyMemory = flag.Int64("body-memory", 64<<10,
+		"bytes of a request body buffered in memory for retries; larger bodies spill to a temporary file")
+	maxRetryBody = flag.Int64("max-retry-body", 10<<20,
+		"largest request body buffered for retries; larger bodies are forwarded once without retries")
+
+	adminToken = flag.String("admin-token", "",
+		"bearer token of the /admin/backends API; the API is disabled without one")
+
+	logFormat = flag.String("log-format", logging.FormatText, "log output format: 'text' or 'json'")
+	logLevel  = flag.String("log-level", "info", "lowest level logged: 'debug', 'info', 'warn' or 'error'")
+
+	backends = flag.String("backends", "",
+		"comma-separated host:port list of backend servers; overrides $"+envBackends+", defaults to "+defaultBackends)
+)
+
+const (
+	envBackends     = "BACKENDS"
+	defaultBackends = "server1:8080,server2:8080,server3:8080"
 )
 
+const (
+	tieBreakFirst      = "first"
+	tieBreakRandom     = "random"
+	tieBreakRoundRobin = "round-robin"
+)
+
+// requestTimeout reads -timeout-sec at call time, so it reflects the parsed
+// flag rather than its default.
+func requestTimeout() time.Duration {
+	return time.Duration(*timeoutSec) * time.Second
+}
+
 type BackendServer struct {
 	Address     string
 	ConnCounter int32
-	IsHealthy   bool
+
+	healthy atomic.Bool
+	breaker circuitBreaker
+	latency latencyEWMA
+	// stopHealthCheck ends the health checks started by startHealthCheck.
+	stopHealthCheck context.CancelFunc
 }
 
-var (
-	timeout     = time.Duration(*timeoutSec) * time.Second
-	serversPool = []*BackendServer{
-		{Address: "server1:8080"},
-		{Address: "server2:8080"},
-		{Address: "server3:8080"},
+// Healthy reports the result of the last health check of s.
+func (s *BackendServer) Healthy() bool {
+	return s.healthy.Load()
+}
+
+// SetHealthy records the result of a health check of s.
+func (s *BackendServer) SetHealthy(healthy bool) {
+	s.healthy.Store(healthy)
+}
+
+// errBackendFull is returned by forwardWithCounter when the backend reached
+// -max-conns-per-backend after it was picked.
+var errBackendFull = errors.New("backend is at its connection limit")
+
+// full reports whether s has -max-conns-per-backend requests in flight.
+func (s *BackendServer) full() bool {
+	return *maxConnsPerBackend > 0 && atomic.LoadInt32(&s.ConnCounter) >= int32(*maxConnsPerBackend)
+}
+
+// acquire counts a request in flight to s unless s is full. The check and
+// the increment are a single compare-and-swap, so concurrent requests can't
+// push s past its limit.
+func (s *BackendServer) acquire() (int32, bool) {
+	for {
+		n := atomic.LoadInt32(&s.ConnCounter)
+		if *maxConnsPerBackend > 0 && n >= int32(*maxConnsPerBackend) {
+			return n, false
+		}
+		if atomic.CompareAndSwapInt32(&s.ConnCounter, n, n+1) {
+			return n + 1, true
+		}
 	}
+}
+
+var (
+	// serversPool is replaced rather than modified, so a slice read with
+	// poolMu held stays valid after it is released.
+	poolMu      sync.RWMutex
+	serversPool []*BackendServer
+	strategy    Strategy = leastConnections{}
+	tieCounter  atomic.Uint64
 )
 
 func scheme() string {
@@ -46,101 +170,361 @@ func scheme() string {
 }
 
 func health(dst string) bool {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout())
 	defer cancel()
 	req, _ := http.NewRequestWithContext(ctx, "GET",
-		fmt.Sprintf("%s://%s/health", scheme(), dst), nil)
+		fmt.Sprintf("%s://%s%s", scheme(), dst, *healthPath), nil)
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return false
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		return false
 	}
 	return true
 }
+
+// hopHeaders are the hop-by-hop headers from RFC 7230 section 6.1 that a
+// proxy must not pass on.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+func removeHopHeaders(h http.Header) {
+	for _, value := range h.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+// setForwardedHeaders tells the backend who sent req, appending the client
+// to any X-Forwarded-For set by proxies in front of the balancer.
+func setForwardedHeaders(h http.Header, req *http.Request) {
+	if ip, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		if prior := h.Values("X-Forwarded-For"); len(prior) > 0 {
+			ip = strings.Join(prior, ", ") + ", " + ip
+		}
+		h.Set("X-Forwarded-For", ip)
+	}
+	h.Set("X-Forwarded-Proto", scheme())
+	h.Set("X-Forwarded-Host", req.Host)
+}
+
+// With -trace, every forwarded request carries lb-author, naming the
+// balancer, and lb-req-cnt, a counter the balancer increments per forwarded
+// request, so backends can report which requests of which balancer they
+// served. Responses carry lb-from, the address of the backend that answered,
+// and lb-latency, the time until its response headers arrived. -trace-prefix
+// renames the response headers; the request headers are read by the servers
+// under their fixed names.
+var traceRequests atomic.Int64
+
 func forward(dst string, writer http.ResponseWriter, req *http.Request) error {
-	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	err := tryForward(dst, writer, req)
+	if err != nil {
+		writer.WriteHeader(http.StatusServiceUnavailable)
+	}
+	return err
+}
+
+// tryForward is forward that leaves the response untouched on a transport
+// error, so the request can be sent to another backend.
+func tryForward(dst string, writer http.ResponseWriter, req *http.Request) error {
+	ctx, cancel := context.WithTimeout(tracing.Extract(req), requestTimeout())
 	defer cancel()
 	fwdRequest := req.Clone(ctx)
 	fwdRequest.RequestURI = ""
 	fwdRequest.URL.Host = dst
 	fwdRequest.URL.Scheme = scheme()
-	fwdRequest.Host = dst
+	if !*preserveHost {
+		fwdRequest.Host = dst
+	}
+	setForwardedHeaders(fwdRequest.Header, req)
+	if *traceEnabled {
+		fwdRequest.Header.Set("lb-author", *traceAuthor)
+		fwdRequest.Header.Set("lb-req-cnt", strconv.FormatInt(traceRequests.Add(1), 10))
+	}
+	spanCtx, span := tracing.StartClient(ctx, "forward", dst)
+	defer span.End()
+	tracing.Inject(spanCtx, fwdRequest.Header)
 
+	start := time.Now()
 	resp, err := http.DefaultClient.Do(fwdRequest)
 	if err == nil {
+		tracing.SetStatus(span, resp.StatusCode)
+		removeHopHeaders(resp.Header)
 		for k, values := range resp.Header {
 			for _, value := range values {
 				writer.Header().Add(k, value)
 			}
 		}
 		if *traceEnabled {
-			writer.Header().Set("lb-from", dst)
+			writer.Header().Set(*tracePrefix+"from", dst)
+			writer.Header().Set(*tracePrefix+"latency", time.Since(start).String())
 		}
-		log.Println("fwd", resp.StatusCode, resp.Request.URL)
+		slog.Info("Forwarded request", "backend", dst, "url", resp.Request.URL.String(),
+			"status", resp.StatusCode, "latency", time.Since(start))
 		writer.WriteHeader(resp.StatusCode)
 		defer resp.Body.Close()
 		_, err := io.Copy(writer, resp.Body)
 		if err != nil {
-			log.Printf("Failed to write response: %s", err)
+			slog.Warn("Failed to write response", "backend", dst, "error", err)
 		}
 		return nil
 	} else {
-		log.Printf("Failed to get response from %s: %s", dst, err)
-		writer.WriteHeader(http.StatusServiceUnavailable)
+		tracing.Fail(span, err)
+		slog.Error("Failed to get response", "backend", dst, "latency", time.Since(start), "error", err)
 		return err
 	}
 }
 
-func getLeastConnectedServer() *BackendServer {
-	var selected *BackendServer
-	var minConns int32 = math.MaxInt32
+// currentPool returns the backends requests are balanced between.
+func currentPool() []*BackendServer {
+	poolMu.RLock()
+	defer poolMu.RUnlock()
+	return serversPool
+}
 
-	for _, server := range serversPool {
-		if !server.IsHealthy {
+func healthyServers() []string {
+	pool := currentPool()
+	healthy := make([]string, 0, len(pool))
+	for _, server := range pool {
+		if server.Healthy() {
+			healthy = append(healthy, server.Address)
+		}
+	}
+	return healthy
+}
+
+func readyHandler(rw http.ResponseWriter, _ *http.Request) {
+	healthy := healthyServers()
+	rw.Header().Set("content-type", "application/json")
+	if len(healthy) == 0 {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		rw.WriteHeader(http.StatusOK)
+	}
+	_ = json.NewEncoder(rw).Encode(map[string][]string{"healthy": healthy})
+}
+
+func forwardWithCounter(server *BackendServer, w http.ResponseWriter, r *http.Request) error {
+	n, ok := server.acquire()
+	if !ok {
+		return errBackendFull
+	}
+	conns := backendConnections.WithLabelValues(server.Address)
+	conns.Set(float64(n))
+	defer func() { conns.Set(float64(atomic.AddInt32(&server.ConnCounter, -1))) }()
+
+	start := timeNow()
+	server.breaker.begin()
+	err := tryForward(server.Address, w, r)
+	server.breaker.record(err)
+	observeForward(server, start, err)
+	if err == nil {
+		// Failed forwards are left to the breaker and health checks, as a
+		// refused connection would make a down backend look fast.
+		server.latency.observe(timeNow().Sub(start))
+	}
+	return err
+}
+
+// dispatch forwards req to a backend picked by the strategy. A backend that
+// can't be reached is marked unhealthy until its next health check, and the
+// request is sent to another one, up to -max-retries times. Responses from
+// a backend, including 5xx, are passed on as they are. Backends at
+// -max-conns-per-backend are skipped, so the request fails with 503 once all
+// of them are full. Requests with a body over -max-retry-body aren't retried.
+func dispatch(writer http.ResponseWriter, req *http.Request) {
+	retries := *maxRetries
+	if retries > 0 {
+		retryable, cleanup, err := replayBody(req)
+		defer cleanup()
+		if err != nil {
+			slog.Warn("Failed to buffer request body", "error", err)
+			http.Error(writer, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+		if !retryable {
+			retries = 0
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		server := strategy.Pick(currentPool(), req)
+		if server == nil {
+			http.Error(writer, "No available backend server", http.StatusServiceUnavailable)
+			return
+		}
+		err := forwardWithCounter(server, writer, req)
+		if err == nil {
+			return
+		}
+		if errors.Is(err, errBackendFull) {
+			// Other requests filled the backend after it was picked; the
+			// next pick skips it, so this doesn't count as a retry.
+			attempt--
 			continue
 		}
+		if attempt >= retries || req.Context().Err() != nil {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		server.SetHealthy(false)
+		slog.Warn("Marked backend unhealthy, retrying on another backend", "backend", server.Address)
+		if req.GetBody != nil {
+			req.Body, _ = req.GetBody()
+		}
+	}
+}
 
-		current := atomic.LoadInt32(&server.ConnCounter)
-		if current < minConns {
-			minConns = current
-			selected = server
+// parseBackends turns a comma-separated host:port list into a server pool.
+func parseBackends(spec string) ([]*BackendServer, error) {
+	var pool []*BackendServer
+	for _, addr := range strings.Split(spec, ",") {
+		addr = strings.TrimSpace(addr)
+		if err := validateBackend(addr); err != nil {
+			return nil, err
 		}
+		pool = append(pool, &BackendServer{Address: addr})
 	}
-	return selected
+	return pool, nil
+}
+
+func validateBackend(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid backend %q: %w", addr, err)
+	}
+	if n, err := strconv.Atoi(port); host == "" || err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid backend %q: expected host:port", addr)
+	}
+	return nil
+}
+
+// checkHealth probes server and records the result.
+func checkHealth(server *BackendServer) {
+	healthy := health(server.Address)
+	server.SetHealthy(healthy)
+	observeHealth(server, healthy)
+	slog.Info("Checked backend health", "backend", server.Address, "healthy", healthy)
 }
 
-func forwardWithCounter(server *BackendServer, w http.ResponseWriter, r *http.Request) {
-	atomic.AddInt32(&server.ConnCounter, 1)
-	defer atomic.AddInt32(&server.ConnCounter, -1)
+// startHealthCheck probes server at once and then each -health-interval
+// until ctx is done or server.stopHealthCheck is called.
+func startHealthCheck(ctx context.Context, server *BackendServer) {
+	ctx, server.stopHealthCheck = context.WithCancel(ctx)
+	go func() {
+		ticker := time.NewTicker(*healthInterval)
+		defer ticker.Stop()
+		for {
+			checkHealth(server)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
 
-	forward(server.Address, w, r)
+// drain stops health checks and the frontend, then waits up to
+// -drain-timeout for in-flight requests to finish.
+func drain(frontend interface{ Shutdown(context.Context) error }, stopHealthChecks func()) error {
+	stopHealthChecks()
+	ctx, cancel := context.WithTimeout(context.Background(), *drainTimeout)
+	defer cancel()
+	return frontend.Shutdown(ctx)
 }
 
 func main() {
 	flag.Parse()
+	if err := logging.Setup(os.Stderr, *logFormat, *logLevel); err != nil {
+		logging.Fatal("Invalid logging flags", "error", err)
+	}
+
+	switch *tieBreak {
+	case tieBreakFirst, tieBreakRandom, tieBreakRoundRobin:
+	default:
+		logging.Fatal(fmt.Sprintf("Invalid -tie-break %q, expected %q, %q or %q", *tieBreak, tieBreakFirst, tieBreakRandom, tieBreakRoundRobin))
+	}
+
+	if *healthInterval <= 0 {
+		logging.Fatal(fmt.Sprintf("Invalid -health-interval %s, expected a positive duration", *healthInterval))
+	}
+	if !strings.HasPrefix(*healthPath, "/") {
+		logging.Fatal(fmt.Sprintf("Invalid -health-path %q, expected a path starting with /", *healthPath))
+	}
+
+	if *traceAuthor == "" {
+		*traceAuthor, _ = os.Hostname()
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), "balancer")
+	if err != nil {
+		logging.Fatal("Tracing setup failed", "error", err)
+	}
+	defer shutdownTracing(context.Background())
 
+	if strategy, err = newStrategy(*strategyName); err != nil {
+		logging.Fatal("Invalid -strategy", "error", err)
+	}
+
+	spec := cmp.Or(*backends, os.Getenv(envBackends), defaultBackends)
+	pool, err := parseBackends(spec)
+	if err != nil {
+		logging.Fatal("Invalid backend pool", "error", err)
+	}
+	serversPool = pool
+	slog.Info("Backend pool", "backends", spec)
+
+	healthCtx, stopHealthChecks := context.WithCancel(context.Background())
 	for _, server := range serversPool {
-		go func() {
-			for range time.Tick(10 * time.Second) {
-				server.IsHealthy = true
-				log.Println(server, "healthy:", health(server.Address))
-			}
-		}()
+		startHealthCheck(healthCtx, server)
 	}
 
-	frontend := httptools.CreateServer(*port, http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
-		selectedServer := getLeastConnectedServer()
-		if selectedServer == nil {
-			http.Error(writer, "No available backend server", http.StatusServiceUnavailable)
-			return
-		}
-		forwardWithCounter(selectedServer, writer, req)
-	}))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ready", readyHandler)
+	mux.HandleFunc("/breakers", breakersHandler)
+	mux.Handle("/metrics", metricsHandler())
+	if *adminToken != "" {
+		registerAdminHandlers(mux, *adminToken, healthCtx)
+	}
+	if *rateLimit > 0 {
+		mux.Handle("/", newRateLimiter(*rateLimit, *rateBurst).middleware(http.HandlerFunc(dispatch)))
+	} else {
+		mux.HandleFunc("/", dispatch)
+	}
 
-	log.Println("Starting load balancer...")
-	log.Printf("Tracing support enabled: %t", *traceEnabled)
+	frontend := httptools.CreateServerWithConfig(*port, mux, httptools.Config{
+		ReadTimeout:  *readTimeout,
+		WriteTimeout: *writeTimeout,
+		IdleTimeout:  *idleTimeout,
+		MaxConns:     *maxConns,
+	})
+
+	slog.Info("Starting load balancer", "port", *port, "trace", *traceEnabled)
 	frontend.Start()
 	signal.WaitForTerminationSignal()
+
+	if err := drain(frontend, stopHealthChecks); err != nil {
+		slog.Error("Failed to drain in-flight requests", "error", err)
+		os.Exit(1)
+	}
+	slog.Info("Drained in-flight requests")
 }
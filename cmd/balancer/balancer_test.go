@@ -2,16 +2,38 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
 	"io"
+	"log"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/maxnetyaga/architecture-practice-5/logging"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 )
 
+func newServer(addr string, conns int32, healthy bool) *BackendServer {
+	s := &BackendServer{Address: addr, ConnCounter: conns}
+	s.SetHealthy(healthy)
+	return s
+}
+
 func TestScheme(t *testing.T) {
 	orig := *https
 	defer func() { *https = orig }()
@@ -23,6 +45,15 @@ func TestScheme(t *testing.T) {
 	assert.Equal(t, "https", scheme(), "scheme() should return 'https' when https=true")
 }
 
+func TestRequestTimeoutFollowsFlag(t *testing.T) {
+	orig := flag.Lookup("timeout-sec").Value.String()
+	t.Cleanup(func() { _ = flag.Set("timeout-sec", orig) })
+
+	assert.Equal(t, 3*time.Second, requestTimeout())
+	require.NoError(t, flag.Set("timeout-sec", "7"))
+	assert.Equal(t, 7*time.Second, requestTimeout())
+}
+
 func TestHealth_OK(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path == "/health" {
@@ -37,6 +68,27 @@ func TestHealth_OK(t *testing.T) {
 	assert.True(t, health(host), "health() should return true for 200 OK")
 }
 
+func TestHealth_ConfiguredPath(t *testing.T) {
+	var probed string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probed = r.URL.Path
+		if r.URL.Path == "/status" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	orig := *healthPath
+	defer func() { *healthPath = orig }()
+	*healthPath = "/status"
+
+	host := strings.TrimPrefix(ts.URL, "http://")
+	assert.True(t, health(host), "health() should probe the configured path")
+	assert.Equal(t, "/status", probed)
+}
+
 func TestHealth_NotOK(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -51,15 +103,76 @@ func TestHealth_Error(t *testing.T) {
 	assert.False(t, health("localhost:0"), "health() should return false on connection error")
 }
 
+func TestParseBackends(t *testing.T) {
+	pool, err := parseBackends("a:1, b.example:8080,10.0.0.1:80")
+	require.NoError(t, err)
+	var addrs []string
+	for _, server := range pool {
+		addrs = append(addrs, server.Address)
+		assert.False(t, server.Healthy())
+	}
+	assert.Equal(t, []string{"a:1", "b.example:8080", "10.0.0.1:80"}, addrs)
+
+	pool, err = parseBackends(defaultBackends)
+	require.NoError(t, err)
+	assert.Len(t, pool, 3)
+
+	for _, spec := range []string{"", "server1", "server1:http", ":8080", "a:1,,b:2", "a:70000"} {
+		_, err := parseBackends(spec)
+		assert.Error(t, err, "spec %q", spec)
+	}
+}
+
+func TestCheckHealth(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	up := &BackendServer{Address: strings.TrimPrefix(ts.URL, "http://")}
+	down := newServer("localhost:0", 0, true)
+	for i := 0; i < 2; i++ {
+		checkHealth(up)
+		checkHealth(down)
+		assert.True(t, up.Healthy(), "a responding backend should be healthy")
+		assert.False(t, down.Healthy(), "a failing backend should stay unhealthy")
+	}
+}
+
+func TestCheckHealth_ConcurrentWithPick(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	addr := strings.TrimPrefix(backend.URL, "http://")
+	pool := []*BackendServer{newServer(addr, 0, true), newServer("b", 0, true)}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			checkHealth(pool[0])
+			pool[1].SetHealthy(i%2 == 0)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			leastConnections{}.Pick(pool, nil)
+		}
+	}()
+	wg.Wait()
+	assert.True(t, pool[0].Healthy())
+}
+
 func TestGetLeastConnectedServer_NoneHealthy(t *testing.T) {
 	orig := serversPool
 	defer func() { serversPool = orig }()
 
 	serversPool = []*BackendServer{
-		{Address: "a", ConnCounter: 0, IsHealthy: false},
-		{Address: "b", ConnCounter: 0, IsHealthy: false},
+		newServer("a", 0, false),
+		newServer("b", 0, false),
 	}
-	assert.Nil(t, getLeastConnectedServer(), "should return nil when no healthy servers are available")
+	assert.Nil(t, leastConnections{}.Pick(serversPool, nil), "should return nil when no healthy servers are available")
 }
 
 func TestGetLeastConnectedServer_SelectLowest(t *testing.T) {
@@ -67,11 +180,11 @@ func TestGetLeastConnectedServer_SelectLowest(t *testing.T) {
 	defer func() { serversPool = orig }()
 
 	serversPool = []*BackendServer{
-		{Address: "a", ConnCounter: 5, IsHealthy: true},
-		{Address: "b", ConnCounter: 3, IsHealthy: true},
-		{Address: "c", ConnCounter: 10, IsHealthy: true},
+		newServer("a", 5, true),
+		newServer("b", 3, true),
+		newServer("c", 10, true),
 	}
-	srv := getLeastConnectedServer()
+	srv := leastConnections{}.Pick(serversPool, nil)
 	assert.NotNil(t, srv)
 	assert.Equal(t, "b", srv.Address, "should select the server with the fewest connections")
 }
@@ -81,14 +194,69 @@ func TestGetLeastConnectedServer_SkipUnhealthy(t *testing.T) {
 	defer func() { serversPool = orig }()
 
 	serversPool = []*BackendServer{
-		{Address: "a", ConnCounter: 1, IsHealthy: false},
-		{Address: "b", ConnCounter: 0, IsHealthy: true},
+		newServer("a", 1, false),
+		newServer("b", 0, true),
 	}
-	srv := getLeastConnectedServer()
+	srv := leastConnections{}.Pick(serversPool, nil)
 	assert.NotNil(t, srv)
 	assert.Equal(t, "b", srv.Address, "should skip unhealthy servers")
 }
 
+func TestGetLeastConnectedServer_TieBreak(t *testing.T) {
+	origPool, origTieBreak := serversPool, *tieBreak
+	defer func() { serversPool, *tieBreak = origPool, origTieBreak }()
+
+	serversPool = []*BackendServer{
+		newServer("a", 1, true),
+		newServer("b", 1, true),
+		newServer("c", 1, true),
+		newServer("d", 2, true),
+	}
+
+	pick := func() map[string]int {
+		counts := map[string]int{}
+		for i := 0; i < 300; i++ {
+			counts[leastConnections{}.Pick(serversPool, nil).Address]++
+		}
+		return counts
+	}
+
+	*tieBreak = tieBreakFirst
+	assert.Equal(t, map[string]int{"a": 300}, pick(), "'first' should always pick the earliest tied server")
+
+	*tieBreak = tieBreakRoundRobin
+	assert.Equal(t, map[string]int{"a": 100, "b": 100, "c": 100}, pick(),
+		"'round-robin' should cycle through the tied servers")
+
+	*tieBreak = tieBreakRandom
+	counts := pick()
+	assert.Len(t, counts, 3, "'random' should spread requests across all tied servers")
+	assert.NotContains(t, counts, "d", "servers with more connections should not be picked")
+}
+
+func TestGetLeastConnectedServer_EqualCountersSpreadEvenly(t *testing.T) {
+	origTieBreak := *tieBreak
+	defer func() { *tieBreak = origTieBreak }()
+
+	pool := []*BackendServer{
+		newServer("a", 0, true),
+		newServer("b", 0, true),
+		newServer("c", 0, true),
+	}
+	const picks = 3000
+	for _, mode := range []string{tieBreakRoundRobin, tieBreakRandom} {
+		*tieBreak = mode
+		counts := map[string]int{}
+		for i := 0; i < picks; i++ {
+			counts[leastConnections{}.Pick(pool, nil).Address]++
+		}
+		for _, server := range pool {
+			assert.InDelta(t, picks/len(pool), counts[server.Address], float64(picks/len(pool))*0.2,
+				"%s: with equal counters every backend should get about a third of the picks, got %v", mode, counts)
+		}
+	}
+}
+
 func TestForward_SuccessAndTrace(t *testing.T) {
 	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("X-Test", "ok")
@@ -114,6 +282,112 @@ func TestForward_SuccessAndTrace(t *testing.T) {
 	err = forward(host, rr, req)
 	assert.NoError(t, err)
 	assert.Equal(t, host, rr.Header().Get("lb-from"), "should set lb-from header when traceEnabled is true")
+	latency, err := time.ParseDuration(rr.Header().Get("lb-latency"))
+	require.NoError(t, err, "lb-latency should be a duration")
+	assert.Positive(t, latency)
+}
+
+func TestForward_TracePrefix(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	host := strings.TrimPrefix(backend.URL, "http://")
+
+	origEnabled, origPrefix := *traceEnabled, *tracePrefix
+	defer func() { *traceEnabled, *tracePrefix = origEnabled, origPrefix }()
+	*traceEnabled, *tracePrefix = true, "X-Balancer-"
+
+	rr := httptest.NewRecorder()
+	require.NoError(t, forward(host, rr, httptest.NewRequest("GET", "/", nil)))
+	assert.Equal(t, host, rr.Header().Get("X-Balancer-From"))
+	_, err := time.ParseDuration(rr.Header().Get("X-Balancer-Latency"))
+	assert.NoError(t, err)
+	assert.Empty(t, rr.Header().Get("lb-from"), "the default header should not be set with a custom prefix")
+	assert.Empty(t, rr.Header().Get("lb-latency"))
+}
+
+func TestForward_SendsTraceHeaders(t *testing.T) {
+	var authors, counters []string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authors = append(authors, r.Header.Get("lb-author"))
+		counters = append(counters, r.Header.Get("lb-req-cnt"))
+	}))
+	defer backend.Close()
+	host := strings.TrimPrefix(backend.URL, "http://")
+
+	origEnabled, origAuthor := *traceEnabled, *traceAuthor
+	defer func() { *traceEnabled, *traceAuthor = origEnabled, origAuthor }()
+	*traceEnabled, *traceAuthor = false, "lb1"
+	require.NoError(t, forward(host, httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil)))
+
+	*traceEnabled = true
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("lb-author", "spoofed")
+		require.NoError(t, forward(host, httptest.NewRecorder(), req))
+	}
+
+	assert.Equal(t, []string{"", "lb1", "lb1"}, authors, "lb-author should only be sent with -trace")
+	require.Len(t, counters, 3)
+	assert.Empty(t, counters[0])
+	first, err := strconv.Atoi(counters[1])
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(first+1), counters[2], "lb-req-cnt should increase with every request")
+}
+
+func TestForward_PropagatesTraceparent(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	origProvider, origPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer func() {
+		otel.SetTracerProvider(origProvider)
+		otel.SetTextMapPropagator(origPropagator)
+	}()
+
+	var received string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get("traceparent")
+	}))
+	defer backend.Close()
+	host := strings.TrimPrefix(backend.URL, "http://")
+
+	const traceID, parentID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-"+parentID+"-01")
+	require.NoError(t, forward(host, httptest.NewRecorder(), req))
+
+	require.Len(t, spans.Ended(), 1)
+	span := spans.Ended()[0]
+	assert.Equal(t, "forward", span.Name())
+	assert.Equal(t, traceID, span.Parent().TraceID().String())
+	assert.Equal(t, parentID, span.Parent().SpanID().String(), "the forward span should continue the client's trace")
+	assert.Contains(t, span.Attributes(), semconv.ServerAddress(host))
+	assert.Equal(t, "00-"+traceID+"-"+span.SpanContext().SpanID().String()+"-01", received,
+		"the backend should see the forward span as its parent")
+}
+
+func TestForward_LogsJSON(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer backend.Close()
+	host := strings.TrimPrefix(backend.URL, "http://")
+
+	orig, origOutput, origFlags := slog.Default(), log.Writer(), log.Flags()
+	defer func() {
+		slog.SetDefault(orig)
+		log.SetOutput(origOutput)
+		log.SetFlags(origFlags)
+	}()
+	var buf bytes.Buffer
+	require.NoError(t, logging.Setup(&buf, logging.FormatJSON, "info"))
+	require.NoError(t, forward(host, httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/some-data", nil)))
+
+	var record map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record), "the forward log should be a JSON object: %s", buf.String())
+	assert.Equal(t, host, record["backend"])
+	assert.EqualValues(t, http.StatusTeapot, record["status"])
+	assert.Contains(t, record, "latency")
 }
 
 func TestForward_Error(t *testing.T) {
@@ -132,7 +406,7 @@ func TestForwardWithCounter(t *testing.T) {
 	defer mock.Close()
 
 	addr := strings.TrimPrefix(mock.URL, "http://")
-	server := &BackendServer{Address: addr, IsHealthy: true}
+	server := newServer(addr, 0, true)
 
 	before := atomic.LoadInt32(&server.ConnCounter)
 	rr := httptest.NewRecorder()
@@ -141,4 +415,257 @@ func TestForwardWithCounter(t *testing.T) {
 	forwardWithCounter(server, rr, req)
 	after := atomic.LoadInt32(&server.ConnCounter)
 	assert.Equal(t, before, after, "ConnCounter should return to its initial value after forwarding")
-}
\ No newline at end of file
+}
+func TestReadyHandler(t *testing.T) {
+	orig := serversPool
+	defer func() { serversPool = orig }()
+
+	serversPool = []*BackendServer{
+		newServer("a", 0, false),
+		newServer("b", 0, false),
+	}
+	rr := httptest.NewRecorder()
+	readyHandler(rr, httptest.NewRequest("GET", "/ready", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code, "should not be ready without healthy backends")
+
+	serversPool[1].SetHealthy(true)
+	rr = httptest.NewRecorder()
+	readyHandler(rr, httptest.NewRequest("GET", "/ready", nil))
+	assert.Equal(t, http.StatusOK, rr.Code, "should be ready with at least one healthy backend")
+
+	var body struct {
+		Healthy []string `json:"healthy"`
+	}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+	assert.Equal(t, []string{"b"}, body.Healthy)
+}
+
+func TestForward_StripsHopByHopHeaders(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Connection", "X-Custom-Hop")
+		w.Header().Set("X-Custom-Hop", "1")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.Header().Set("Upgrade", "websocket")
+		w.Header().Set("X-End-To-End", "kept")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	rr := httptest.NewRecorder()
+	err := forward(strings.TrimPrefix(backend.URL, "http://"), rr, httptest.NewRequest("GET", "/", nil))
+	assert.NoError(t, err)
+
+	for _, name := range []string{"Connection", "X-Custom-Hop", "Keep-Alive", "Upgrade", "Transfer-Encoding"} {
+		assert.Empty(t, rr.Header().Get(name), "hop-by-hop header %s should not be forwarded", name)
+	}
+	assert.Equal(t, "kept", rr.Header().Get("X-End-To-End"))
+}
+
+func TestForward_SetsForwardedHeaders(t *testing.T) {
+	var got http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer backend.Close()
+	dst := strings.TrimPrefix(backend.URL, "http://")
+
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	req.RemoteAddr = "203.0.113.7:5555"
+	require.NoError(t, forward(dst, httptest.NewRecorder(), req))
+	assert.Equal(t, "203.0.113.7", got.Get("X-Forwarded-For"))
+	assert.Equal(t, "http", got.Get("X-Forwarded-Proto"))
+	assert.Equal(t, "example.com", got.Get("X-Forwarded-Host"))
+
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	require.NoError(t, forward(dst, httptest.NewRecorder(), req))
+	assert.Equal(t, "198.51.100.1, 203.0.113.7", got.Get("X-Forwarded-For"),
+		"the client should be appended to an existing X-Forwarded-For")
+}
+
+func TestForward_PreserveHost(t *testing.T) {
+	var got string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Host
+	}))
+	defer backend.Close()
+	dst := strings.TrimPrefix(backend.URL, "http://")
+
+	orig := *preserveHost
+	defer func() { *preserveHost = orig }()
+
+	*preserveHost = false
+	require.NoError(t, forward(dst, httptest.NewRecorder(), httptest.NewRequest("GET", "http://example.com/", nil)))
+	assert.Equal(t, dst, got, "the Host should be rewritten to the backend address by default")
+
+	*preserveHost = true
+	require.NoError(t, forward(dst, httptest.NewRecorder(), httptest.NewRequest("GET", "http://example.com/", nil)))
+	assert.Equal(t, "example.com", got, "the client's Host should be kept with -preserve-host")
+}
+
+func TestDispatch_RetriesOnAnotherBackend(t *testing.T) {
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer live.Close()
+
+	origPool, origStrategy, origTieBreak, origRetries := serversPool, strategy, *tieBreak, *maxRetries
+	defer func() {
+		serversPool, strategy, *tieBreak, *maxRetries = origPool, origStrategy, origTieBreak, origRetries
+	}()
+	strategy, *tieBreak = leastConnections{}, tieBreakFirst
+
+	newPool := func() {
+		serversPool = []*BackendServer{
+			newServer("localhost:0", 0, true),
+			newServer(strings.TrimPrefix(live.URL, "http://"), 0, true),
+		}
+	}
+
+	newPool()
+	*maxRetries = 1
+	rr := httptest.NewRecorder()
+	dispatch(rr, httptest.NewRequest("POST", "/", strings.NewReader("payload")))
+	assert.Equal(t, http.StatusOK, rr.Code, "the request should succeed on the live backend")
+	assert.Equal(t, "payload", rr.Body.String(), "the body should be replayed to the live backend")
+	assert.False(t, serversPool[0].Healthy(), "the dead backend should be marked unhealthy")
+
+	newPool()
+	*maxRetries = 0
+	rr = httptest.NewRecorder()
+	dispatch(rr, httptest.NewRequest("POST", "/", strings.NewReader("payload")))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code, "without retries the dead backend fails the request")
+}
+
+func TestDrain_WaitsForInFlightRequest(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	}))
+	defer backend.Close()
+
+	origPool, origStrategy := serversPool, strategy
+	defer func() { serversPool, strategy = origPool, origStrategy }()
+	serversPool = []*BackendServer{newServer(strings.TrimPrefix(backend.URL, "http://"), 0, true)}
+	strategy = leastConnections{}
+
+	frontend := httptest.NewServer(http.HandlerFunc(dispatch))
+	defer frontend.Close()
+
+	type result struct {
+		body string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(frontend.URL)
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		done <- result{string(body), err}
+	}()
+	<-started
+
+	healthStopped := false
+	drained := make(chan error, 1)
+	go func() { drained <- drain(frontend.Config, func() { healthStopped = true }) }()
+
+	select {
+	case <-drained:
+		t.Fatal("drain should wait for the in-flight request")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+
+	res := <-done
+	require.NoError(t, res.err)
+	assert.Equal(t, "done", res.body, "the in-flight request should complete")
+	assert.NoError(t, <-drained)
+	assert.True(t, healthStopped, "drain should stop the health checks")
+}
+
+func TestDrain_Timeout(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	frontend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	defer frontend.Close()
+	defer close(release)
+
+	origDrainTimeout := *drainTimeout
+	defer func() { *drainTimeout = origDrainTimeout }()
+	*drainTimeout = 50 * time.Millisecond
+
+	go http.Get(frontend.URL)
+	<-started
+	assert.ErrorIs(t, drain(frontend.Config, func() {}), context.DeadlineExceeded)
+}
+
+func TestAcquire_RespectsCap(t *testing.T) {
+	origCap := *maxConnsPerBackend
+	defer func() { *maxConnsPerBackend = origCap }()
+	*maxConnsPerBackend = 2
+
+	server := newServer("a", 0, true)
+	for i := 0; i < 2; i++ {
+		_, ok := server.acquire()
+		assert.True(t, ok)
+	}
+	n, ok := server.acquire()
+	assert.False(t, ok, "a full backend should refuse another connection")
+	assert.EqualValues(t, 2, n)
+	assert.False(t, server.available(), "a full backend should not be picked")
+}
+
+func TestDispatch_MaxConnsPerBackend(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		<-release
+	})
+	a := httptest.NewServer(handler)
+	defer a.Close()
+	b := httptest.NewServer(handler)
+	defer b.Close()
+
+	origPool, origStrategy, origCap := serversPool, strategy, *maxConnsPerBackend
+	defer func() { serversPool, strategy, *maxConnsPerBackend = origPool, origStrategy, origCap }()
+	serversPool = []*BackendServer{
+		newServer(strings.TrimPrefix(a.URL, "http://"), 0, true),
+		newServer(strings.TrimPrefix(b.URL, "http://"), 0, true),
+	}
+	strategy, *maxConnsPerBackend = leastConnections{}, 1
+
+	const requests = 6
+	codes := make(chan int, requests)
+	for i := 0; i < requests; i++ {
+		go func() {
+			rr := httptest.NewRecorder()
+			dispatch(rr, httptest.NewRequest("GET", "/", nil))
+			codes <- rr.Code
+		}()
+	}
+
+	for i := 0; i < requests-2; i++ {
+		assert.Equal(t, http.StatusServiceUnavailable, <-codes, "requests beyond the total capacity should be refused")
+	}
+	close(release)
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusOK, <-codes)
+	}
+	assert.EqualValues(t, 1, peak.Load(), "no backend should exceed its connection limit")
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+)
+
+// replayBody buffers req's body so that it can be sent again to another
+// backend: the first -body-memory bytes in memory, the rest up to
+// -max-retry-body in a temporary file. It sets req.GetBody and reports
+// whether the request may be retried. A body over -max-retry-body is
+// forwarded as it streams in, without retries. The returned cleanup removes
+// the temporary file and must be called once the request is done.
+func replayBody(req *http.Request) (retryable bool, cleanup func(), err error) {
+	cleanup = func() {}
+	if req.Body == nil || req.Body == http.NoBody {
+		return true, cleanup, nil
+	}
+	if req.ContentLength > *maxRetryBody {
+		return false, cleanup, nil
+	}
+
+	head, err := io.ReadAll(io.LimitReader(req.Body, *bodyMemory+1))
+	if err != nil {
+		req.Body.Close()
+		return false, cleanup, err
+	}
+	if int64(len(head)) <= *bodyMemory {
+		req.Body.Close()
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(head)), nil
+		}
+		req.Body, _ = req.GetBody()
+		return true, cleanup, nil
+	}
+
+	f, err := os.CreateTemp("", "balancer-body-*")
+	if err != nil {
+		req.Body.Close()
+		return false, cleanup, err
+	}
+	cleanup = func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	n, err := io.Copy(f, io.MultiReader(bytes.NewReader(head), io.LimitReader(req.Body, *maxRetryBody-int64(len(head))+1)))
+	if err != nil {
+		req.Body.Close()
+		return false, cleanup, err
+	}
+	if n > *maxRetryBody {
+		// The client sent more than can be buffered without announcing it;
+		// pass on what was read followed by the rest of the stream.
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(io.NewSectionReader(f, 0, n), req.Body), req.Body}
+		return false, cleanup, nil
+	}
+	req.Body.Close()
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(io.NewSectionReader(f, 0, n)), nil
+	}
+	req.Body, _ = req.GetBody()
+	return true, cleanup, nil
+}
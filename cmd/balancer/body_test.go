@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDispatch_RetriesBufferedBody(t *testing.T) {
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer live.Close()
+
+	origPool, origStrategy, origTieBreak, origRetries := serversPool, strategy, *tieBreak, *maxRetries
+	origMemory, origMax := *bodyMemory, *maxRetryBody
+	defer func() {
+		serversPool, strategy, *tieBreak, *maxRetries = origPool, origStrategy, origTieBreak, origRetries
+		*bodyMemory, *maxRetryBody = origMemory, origMax
+	}()
+	strategy, *tieBreak, *maxRetries = leastConnections{}, tieBreakFirst, 1
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	newPool := func() {
+		serversPool = []*BackendServer{
+			newServer("localhost:0", 0, true),
+			newServer(strings.TrimPrefix(live.URL, "http://"), 0, true),
+		}
+	}
+	payload := strings.Repeat("0123456789", 100)
+
+	for name, memory := range map[string]int64{"memory": 4096, "temp file": 16} {
+		t.Run(name, func(t *testing.T) {
+			newPool()
+			*bodyMemory, *maxRetryBody = memory, 4096
+			rr := httptest.NewRecorder()
+			dispatch(rr, httptest.NewRequest("POST", "/", strings.NewReader(payload)))
+			assert.Equal(t, http.StatusOK, rr.Code, "the request should succeed on the live backend")
+			assert.Equal(t, payload, rr.Body.String(), "the body should be replayed intact")
+
+			left, err := os.ReadDir(tmp)
+			require.NoError(t, err)
+			assert.Empty(t, left, "temporary body files should be removed")
+		})
+	}
+
+	t.Run("over the cap", func(t *testing.T) {
+		*bodyMemory, *maxRetryBody = 16, 64
+
+		newPool()
+		rr := httptest.NewRecorder()
+		dispatch(rr, httptest.NewRequest("POST", "/", strings.NewReader(payload)))
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code, "a body over the cap should not be retried")
+
+		serversPool = serversPool[1:]
+		req := httptest.NewRequest("POST", "/", strings.NewReader(payload))
+		req.ContentLength = -1
+		rr = httptest.NewRecorder()
+		dispatch(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, payload, rr.Body.String(), "a body over the cap should still be forwarded whole")
+
+		left, err := filepath.Glob(filepath.Join(tmp, "*"))
+		require.NoError(t, err)
+		assert.Empty(t, left)
+	})
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// timeNow is replaced in tests to move breakers through their cooldown.
+var timeNow = time.Now
+
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// circuitBreaker takes a backend out of selection after -breaker-failures
+// consecutive forward failures. Once -breaker-cooldown has passed, a single
+// request probes the backend: success closes the breaker, failure opens it
+// for another cooldown.
+type circuitBreaker struct {
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	trips    uint64
+}
+
+// BreakerStats reports the state of a backend's circuit breaker.
+type BreakerStats struct {
+	Address             string `json:"address"`
+	State               string `json:"state"`
+	ConsecutiveFailures int    `json:"consecutiveFailures"`
+	Trips               uint64 `json:"trips"`
+}
+
+// available reports whether the breaker lets a request through, without
+// claiming the half-open probe.
+func (b *circuitBreaker) available() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		return timeNow().Sub(b.openedAt) >= *breakerCooldown
+	case breakerHalfOpen:
+		return false
+	}
+	return true
+}
+
+// begin is called before a request is forwarded. A request let through an
+// open breaker after its cooldown becomes the half-open probe.
+func (b *circuitBreaker) begin() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerOpen && timeNow().Sub(b.openedAt) >= *breakerCooldown {
+		b.state = breakerHalfOpen
+	}
+}
+
+// record notes the outcome of a forwarded request.
+func (b *circuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		b.state, b.failures = breakerClosed, 0
+		return
+	}
+	b.failures++
+	if *breakerFailures <= 0 {
+		return
+	}
+	if b.state == breakerHalfOpen || (b.state != breakerOpen && b.failures >= *breakerFailures) {
+		b.state, b.openedAt = breakerOpen, timeNow()
+		b.trips++
+	}
+}
+
+func (b *circuitBreaker) stats() BreakerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state := b.state
+	if state == "" {
+		state = breakerClosed
+	}
+	return BreakerStats{State: state, ConsecutiveFailures: b.failures, Trips: b.trips}
+}
+
+// available reports whether server can be picked for a request.
+func (s *BackendServer) available() bool {
+	return s.Healthy() && !s.full() && s.breaker.available()
+}
+
+func breakersHandler(rw http.ResponseWriter, _ *http.Request) {
+	pool := currentPool()
+	stats := make([]BreakerStats, 0, len(pool))
+	for _, server := range pool {
+		st := server.breaker.stats()
+		st.Address = server.Address
+		stats = append(stats, st)
+	}
+	rw.Header().Set("content-type", "application/json")
+	_ = json.NewEncoder(rw).Encode(stats)
+}
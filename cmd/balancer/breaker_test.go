@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer live.Close()
+
+	now := time.Now()
+	origNow, origFailures, origCooldown, origPool := timeNow, *breakerFailures, *breakerCooldown, serversPool
+	defer func() {
+		timeNow, *breakerFailures, *breakerCooldown, serversPool = origNow, origFailures, origCooldown, origPool
+	}()
+	timeNow = func() time.Time { return now }
+	*breakerFailures, *breakerCooldown = 2, time.Minute
+
+	server := newServer("localhost:0", 0, true)
+	serversPool = []*BackendServer{server}
+	pool := serversPool
+	forward := func() error {
+		return forwardWithCounter(server, httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+
+	assert.Error(t, forward())
+	assert.Same(t, server, leastConnections{}.Pick(pool, nil), "one failure should not trip the breaker")
+	assert.Error(t, forward())
+	assert.Nil(t, leastConnections{}.Pick(pool, nil), "the backend should be skipped while the breaker is open")
+	assert.Equal(t, BreakerStats{State: breakerOpen, ConsecutiveFailures: 2, Trips: 1}, server.breaker.stats())
+
+	now = now.Add(time.Minute)
+	assert.Same(t, server, leastConnections{}.Pick(pool, nil), "the cooldown should let a probe through")
+	assert.Error(t, forward())
+	assert.Nil(t, leastConnections{}.Pick(pool, nil), "a failed probe should reopen the breaker")
+	assert.Equal(t, uint64(2), server.breaker.stats().Trips)
+
+	now = now.Add(time.Minute)
+	server.Address = strings.TrimPrefix(live.URL, "http://")
+	assert.NoError(t, forward())
+	assert.Same(t, server, leastConnections{}.Pick(pool, nil), "a successful probe should close the breaker")
+
+	rr := httptest.NewRecorder()
+	breakersHandler(rr, httptest.NewRequest("GET", "/breakers", nil))
+	var stats []BreakerStats
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &stats))
+	assert.Equal(t, []BreakerStats{{Address: server.Address, State: breakerClosed, Trips: 2}}, stats)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ringReplicas is the number of points each backend takes on the ring, which
+// evens out the share of keys each one gets.
+const ringReplicas = 100
+
+// hashRing routes requests with the same key to the same backend. The ring
+// holds the healthy backends only and is rebuilt whenever that set changes,
+// so keys of a backend that goes down move on to the next point clockwise
+// while every other key stays put. Requests without a key fall back to
+// least-connections.
+type hashRing struct {
+	key func(*http.Request) string
+
+	mu      sync.Mutex
+	members []*BackendServer
+	points  []ringPoint
+}
+
+type ringPoint struct {
+	hash   uint32
+	server *BackendServer
+}
+
+// newHashRing reads the request key as given by hashBy, either
+// "query:<param>" or "header:<name>".
+func newHashRing(hashBy string) (*hashRing, error) {
+	source, name, ok := strings.Cut(hashBy, ":")
+	if !ok || name == "" {
+		return nil, fmt.Errorf("invalid hash key %q, expected query:<param> or header:<name>", hashBy)
+	}
+	r := &hashRing{}
+	switch source {
+	case "query":
+		r.key = func(req *http.Request) string { return req.URL.Query().Get(name) }
+	case "header":
+		r.key = func(req *http.Request) string { return req.Header.Get(name) }
+	default:
+		return nil, fmt.Errorf("invalid hash key %q, expected query:<param> or header:<name>", hashBy)
+	}
+	return r, nil
+}
+
+// ringHash is FNV-1a followed by the murmur3 finalizer, which spreads the
+// hashes of similar strings like "a:80#1" and "a:80#2" over the whole ring.
+func ringHash(s string) uint32 {
+	f := fnv.New32a()
+	f.Write([]byte(s))
+	h := f.Sum32()
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+func (r *hashRing) Pick(pool []*BackendServer, req *http.Request) *BackendServer {
+	var key string
+	if req != nil {
+		key = r.key(req)
+	}
+	if key == "" {
+		return leastConnections{}.Pick(pool, req)
+	}
+
+	points := r.ring(availablePool(pool))
+	if len(points) == 0 {
+		return nil
+	}
+	h := ringHash(key)
+	i, _ := slices.BinarySearchFunc(points, h, func(p ringPoint, h uint32) int {
+		return cmp.Compare(p.hash, h)
+	})
+	return points[i%len(points)].server
+}
+
+// ring returns the points of healthy, rebuilding them if the set of healthy
+// backends has changed since the last call.
+func (r *hashRing) ring(healthy []*BackendServer) []ringPoint {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if slices.Equal(healthy, r.members) && r.points != nil {
+		return r.points
+	}
+
+	points := make([]ringPoint, 0, len(healthy)*ringReplicas)
+	for _, server := range healthy {
+		for i := 0; i < ringReplicas; i++ {
+			points = append(points, ringPoint{hash: ringHash(server.Address + "#" + strconv.Itoa(i)), server: server})
+		}
+	}
+	slices.SortFunc(points, func(a, b ringPoint) int {
+		return cmp.Compare(a.hash, b.hash)
+	})
+	r.members, r.points = healthy, points
+	return points
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashRing_SameKeySameBackend(t *testing.T) {
+	ring, err := newHashRing("query:key")
+	require.NoError(t, err)
+	pool := []*BackendServer{
+		newServer("a:80", 0, true),
+		newServer("b:80", 0, true),
+		newServer("c:80", 0, true),
+	}
+
+	used := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/some-data?key=k%d", i), nil)
+		first := ring.Pick(pool, req)
+		require.NotNil(t, first)
+		for j := 0; j < 3; j++ {
+			assert.Same(t, first, ring.Pick(pool, req), "the same key should map to the same backend")
+		}
+		used[first.Address] = true
+	}
+	assert.Len(t, used, 3, "keys should spread over all backends")
+}
+
+func TestHashRing_RemovingNodeOnlyRemapsItsShare(t *testing.T) {
+	ring, err := newHashRing("header:X-Key")
+	require.NoError(t, err)
+	pool := []*BackendServer{
+		newServer("a:80", 0, true),
+		newServer("b:80", 0, true),
+		newServer("c:80", 0, true),
+	}
+
+	pick := func() map[string]string {
+		picks := map[string]string{}
+		for i := 0; i < 300; i++ {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("X-Key", fmt.Sprintf("k%d", i))
+			picks[fmt.Sprintf("k%d", i)] = ring.Pick(pool, req).Address
+		}
+		return picks
+	}
+
+	before := pick()
+	pool[1].SetHealthy(false)
+	after := pick()
+	for key, addr := range before {
+		if addr == "b:80" {
+			assert.NotEqual(t, "b:80", after[key], "keys of the unhealthy node should move")
+		} else {
+			assert.Equal(t, addr, after[key], "key %s should stay on its healthy node", key)
+		}
+	}
+
+	pool[1].SetHealthy(true)
+	assert.Equal(t, before, pick(), "keys should return once the node recovers")
+}
+
+func TestHashRing_NoKeyOrNoneHealthy(t *testing.T) {
+	ring, err := newHashRing("query:key")
+	require.NoError(t, err)
+
+	pool := []*BackendServer{
+		newServer("a:80", 5, true),
+		newServer("b:80", 1, true),
+	}
+	assert.Equal(t, "b:80", ring.Pick(pool, httptest.NewRequest("GET", "/", nil)).Address,
+		"requests without a key should fall back to least-connections")
+
+	pool[0].SetHealthy(false)
+	pool[1].SetHealthy(false)
+	assert.Nil(t, ring.Pick(pool, httptest.NewRequest("GET", "/?key=k", nil)))
+}
+
+func TestNewHashRing_Invalid(t *testing.T) {
+	for _, hashBy := range []string{"", "key", "query:", "cookie:id"} {
+		_, err := newHashRing(hashBy)
+		assert.Error(t, err, hashBy)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// The balancer exports these metrics on /metrics:
+//
+//	balancer_requests_total{strategy,address,result}
+//	    forwards to a backend, with result "ok" or "error"
+//	balancer_forward_duration_seconds{strategy,address}
+//	    histogram of forward latencies, including failed ones
+//	balancer_backend_connections{address}
+//	    requests in flight to a backend, its ConnCounter
+//	balancer_backend_healthy{address}
+//	    1 if the backend passed its last health check, 0 otherwise
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "balancer_requests_total",
+		Help: "Requests forwarded to a backend by result.",
+	}, []string{"strategy", "address", "result"})
+	forwardDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "balancer_forward_duration_seconds",
+		Help:    "Time taken to forward a request to a backend.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"strategy", "address"})
+	backendConnections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "balancer_backend_connections",
+		Help: "Requests in flight to a backend.",
+	}, []string{"address"})
+	backendHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "balancer_backend_healthy",
+		Help: "Whether a backend passed its last health check.",
+	}, []string{"address"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(requestsTotal, forwardDuration, backendConnections, backendHealthy)
+}
+
+func metricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
+
+// observeForward records a forward to server that started at start.
+func observeForward(server *BackendServer, start time.Time, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	requestsTotal.WithLabelValues(*strategyName, server.Address, result).Inc()
+	forwardDuration.WithLabelValues(*strategyName, server.Address).Observe(timeNow().Sub(start).Seconds())
+}
+
+func observeHealth(server *BackendServer, healthy bool) {
+	v := 0.0
+	if healthy {
+		v = 1
+	}
+	backendHealthy.WithLabelValues(server.Address).Set(v)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics_ForwardAndHealth(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	server := newServer(strings.TrimPrefix(backend.URL, "http://"), 0, false)
+	down := newServer("localhost:0", 0, true)
+	failures := requestsTotal.WithLabelValues(*strategyName, down.Address, "error")
+	before := testutil.ToFloat64(failures)
+
+	checkHealth(server)
+	checkHealth(down)
+	require.NoError(t, forwardWithCounter(server, httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil)))
+	assert.Error(t, forwardWithCounter(down, httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil)))
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(requestsTotal.WithLabelValues(*strategyName, server.Address, "ok")))
+	assert.Equal(t, before+1, testutil.ToFloat64(failures))
+	assert.Equal(t, 0.0, testutil.ToFloat64(backendConnections.WithLabelValues(server.Address)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(backendHealthy.WithLabelValues(server.Address)))
+	assert.Equal(t, 0.0, testutil.ToFloat64(backendHealthy.WithLabelValues(down.Address)))
+
+	rr := httptest.NewRecorder()
+	metricsHandler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rr.Body)
+	assert.Contains(t, string(body), `balancer_forward_duration_seconds_count{address="`+server.Address+`",strategy="`+*strategyName+`"} 1`)
+}
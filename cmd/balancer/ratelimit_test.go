@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter_FastClientGets429(t *testing.T) {
+	now := time.Now()
+	origNow := timeNow
+	defer func() { timeNow = origNow }()
+	timeNow = func() time.Time { return now }
+
+	handler := newRateLimiter(2, 3).middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	get := func(addr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = addr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, get("10.0.0.1:1000").Code, "request %d within the burst", i)
+	}
+	rec := get("10.0.0.1:1001")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, http.StatusOK, get("10.0.0.2:2000").Code, "slow client request %d", i)
+		now = now.Add(time.Second)
+	}
+	assert.Equal(t, http.StatusOK, get("10.0.0.1:1000").Code, "the fast client should have refilled")
+}
+
+func TestRateLimiter_EvictsIdleBuckets(t *testing.T) {
+	now := time.Now()
+	origNow := timeNow
+	defer func() { timeNow = origNow }()
+	timeNow = func() time.Time { return now }
+
+	l := newRateLimiter(10, 5)
+	for _, ip := range []string{"a", "b", "c"} {
+		l.allow(ip)
+	}
+	assert.Equal(t, 3, l.size())
+
+	now = now.Add(time.Second)
+	l.allow("d")
+	assert.Equal(t, 1, l.size(), "buckets idle for burst/rate should be dropped")
+}
+
+func TestClientIP(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", " 192.0.2.7, 10.0.0.9")
+
+	assert.Equal(t, "10.0.0.1", clientIP(req, false))
+	assert.Equal(t, "192.0.2.7", clientIP(req, true))
+
+	req.Header.Del("X-Forwarded-For")
+	assert.Equal(t, "10.0.0.1", clientIP(req, true))
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	strategyLeastConnections = "least-connections"
+	strategyRoundRobin       = "round-robin"
+	strategyTwoChoices       = "two-choices"
+	strategyConsistentHash   = "consistent-hash"
+	strategyLeastLatency     = "least-latency"
+)
+
+// Strategy selects the backend for req. Pick skips servers that are
+// unhealthy or whose circuit breaker is open, and returns nil if none of
+// pool is available.
+type Strategy interface {
+	Pick(pool []*BackendServer, req *http.Request) *BackendServer
+}
+
+func newStrategy(name string) (Strategy, error) {
+	switch name {
+	case strategyLeastConnections:
+		return leastConnections{}, nil
+	case strategyRoundRobin:
+		return &roundRobin{}, nil
+	case strategyTwoChoices:
+		return twoChoices{}, nil
+	case strategyConsistentHash:
+		ring, err := newHashRing(*hashBy)
+		if err != nil {
+			return nil, err
+		}
+		return ring, nil
+	case strategyLeastLatency:
+		return leastLatency{}, nil
+	}
+	return nil, fmt.Errorf("unknown strategy %q, expected %q, %q, %q, %q or %q",
+		name, strategyLeastConnections, strategyRoundRobin, strategyTwoChoices, strategyConsistentHash, strategyLeastLatency)
+}
+
+func availablePool(pool []*BackendServer) []*BackendServer {
+	healthy := make([]*BackendServer, 0, len(pool))
+	for _, server := range pool {
+		if server.available() {
+			healthy = append(healthy, server)
+		}
+	}
+	return healthy
+}
+
+// leastConnections picks the server with the fewest open connections,
+// settling ties by -tie-break.
+type leastConnections struct{}
+
+func (leastConnections) Pick(pool []*BackendServer, _ *http.Request) *BackendServer {
+	var tied []*BackendServer
+	var minConns int32 = math.MaxInt32
+
+	for _, server := range pool {
+		if !server.available() {
+			continue
+		}
+
+		current := atomic.LoadInt32(&server.ConnCounter)
+		if current < minConns {
+			minConns = current
+			tied = append(tied[:0], server)
+		} else if current == minConns {
+			tied = append(tied, server)
+		}
+	}
+	if len(tied) == 0 {
+		return nil
+	}
+
+	switch *tieBreak {
+	case tieBreakRandom:
+		return tied[rand.IntN(len(tied))]
+	case tieBreakRoundRobin:
+		return tied[(tieCounter.Add(1)-1)%uint64(len(tied))]
+	}
+	return tied[0]
+}
+
+// roundRobin cycles through the healthy servers.
+type roundRobin struct {
+	next atomic.Uint64
+}
+
+func (r *roundRobin) Pick(pool []*BackendServer, _ *http.Request) *BackendServer {
+	healthy := availablePool(pool)
+	if len(healthy) == 0 {
+		return nil
+	}
+	return healthy[(r.next.Add(1)-1)%uint64(len(healthy))]
+}
+
+// twoChoices samples two healthy servers at random and picks the one with
+// fewer connections, which spreads load nearly as well as least-connections
+// without scanning for the global minimum.
+type twoChoices struct{}
+
+func (twoChoices) Pick(pool []*BackendServer, _ *http.Request) *BackendServer {
+	healthy := availablePool(pool)
+	switch len(healthy) {
+	case 0:
+		return nil
+	case 1:
+		return healthy[0]
+	}
+	i := rand.IntN(len(healthy))
+	j := rand.IntN(len(healthy) - 1)
+	if j >= i {
+		j++
+	}
+	a, b := healthy[i], healthy[j]
+	if atomic.LoadInt32(&b.ConnCounter) < atomic.LoadInt32(&a.ConnCounter) {
+		return b
+	}
+	return a
+}
+
+// latencyAlpha is the weight of the newest sample in a latency average.
+const latencyAlpha = 0.3
+
+// latencyEWMA is an exponentially weighted moving average of the time a
+// backend takes to answer forwarded requests.
+type latencyEWMA struct {
+	mu      sync.Mutex
+	average time.Duration
+	sampled bool
+}
+
+func (l *latencyEWMA) observe(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.sampled {
+		l.average, l.sampled = d, true
+		return
+	}
+	l.average = time.Duration(latencyAlpha*float64(d) + (1-latencyAlpha)*float64(l.average))
+}
+
+func (l *latencyEWMA) value() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.average
+}
+
+// leastLatency picks the server with the lowest average response time,
+// settling ties by the fewest connections. Servers that haven't answered a
+// request yet count as fastest, so each one gets tried.
+type leastLatency struct{}
+
+func (leastLatency) Pick(pool []*BackendServer, _ *http.Request) *BackendServer {
+	var best *BackendServer
+	var bestLatency time.Duration
+	var bestConns int32
+	for _, server := range pool {
+		if !server.available() {
+			continue
+		}
+		latency, conns := server.latency.value(), atomic.LoadInt32(&server.ConnCounter)
+		if best == nil || latency < bestLatency || (latency == bestLatency && conns < bestConns) {
+			best, bestLatency, bestConns = server, latency, conns
+		}
+	}
+	return best
+}
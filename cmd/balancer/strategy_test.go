@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewStrategy(t *testing.T) {
+	for _, name := range []string{strategyLeastConnections, strategyRoundRobin, strategyTwoChoices, strategyConsistentHash, strategyLeastLatency} {
+		s, err := newStrategy(name)
+		require.NoError(t, err, name)
+		assert.NotNil(t, s, name)
+	}
+	_, err := newStrategy("fastest")
+	assert.Error(t, err)
+}
+
+func TestStrategies_NoneHealthy(t *testing.T) {
+	pool := []*BackendServer{
+		newServer("a", 0, false),
+		newServer("b", 0, false),
+	}
+	for _, name := range []string{strategyLeastConnections, strategyRoundRobin, strategyTwoChoices, strategyConsistentHash, strategyLeastLatency} {
+		s, err := newStrategy(name)
+		require.NoError(t, err)
+		assert.Nil(t, s.Pick(pool, nil), "%s should return nil when no healthy servers are available", name)
+		assert.Nil(t, s.Pick(nil, nil), "%s should return nil for an empty pool", name)
+	}
+}
+
+func TestRoundRobin_Cycles(t *testing.T) {
+	pool := []*BackendServer{
+		newServer("a", 5, true),
+		newServer("b", 0, false),
+		newServer("c", 1, true),
+	}
+	s := &roundRobin{}
+	var picked []string
+	for i := 0; i < 4; i++ {
+		picked = append(picked, s.Pick(pool, nil).Address)
+	}
+	assert.Equal(t, []string{"a", "c", "a", "c"}, picked, "should cycle through healthy servers regardless of load")
+}
+
+func TestTwoChoices_PrefersFewerConnections(t *testing.T) {
+	pool := []*BackendServer{
+		newServer("a", 10, true),
+		newServer("b", 0, true),
+		newServer("c", 0, false),
+	}
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, "b", twoChoices{}.Pick(pool, nil).Address, "should pick the less loaded of the two healthy servers")
+	}
+}
+
+func TestTwoChoices_SkipUnhealthy(t *testing.T) {
+	pool := []*BackendServer{
+		newServer("a", 0, false),
+		newServer("b", 7, true),
+		newServer("c", 0, false),
+	}
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, "b", twoChoices{}.Pick(pool, nil).Address, "should skip unhealthy servers")
+	}
+}
+
+func TestTwoChoices_SpreadsLoad(t *testing.T) {
+	pool := []*BackendServer{
+		newServer("a", 1, true),
+		newServer("b", 1, true),
+		newServer("c", 1, true),
+	}
+	counts := map[string]int{}
+	for i := 0; i < 300; i++ {
+		counts[twoChoices{}.Pick(pool, nil).Address]++
+	}
+	assert.Len(t, counts, 3, "equally loaded servers should all be picked")
+}
+
+func TestLeastLatency_PicksFastestThenFewestConnections(t *testing.T) {
+	pool := []*BackendServer{
+		newServer("a", 0, true),
+		newServer("b", 3, true),
+		newServer("c", 1, true),
+		newServer("d", 0, false),
+	}
+	pool[0].latency.observe(50 * time.Millisecond)
+	pool[1].latency.observe(10 * time.Millisecond)
+	pool[2].latency.observe(10 * time.Millisecond)
+	assert.Equal(t, "c", leastLatency{}.Pick(pool, nil).Address,
+		"ties on latency should go to the server with fewer connections")
+}
+
+func TestLeastLatency_ShiftsTrafficToFasterBackend(t *testing.T) {
+	var slowHits, fastHits atomic.Int32
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slowHits.Add(1)
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fastHits.Add(1)
+	}))
+	defer fast.Close()
+
+	origPool, origStrategy := serversPool, strategy
+	defer func() { serversPool, strategy = origPool, origStrategy }()
+	serversPool = []*BackendServer{
+		newServer(strings.TrimPrefix(slow.URL, "http://"), 0, true),
+		newServer(strings.TrimPrefix(fast.URL, "http://"), 0, true),
+	}
+	strategy = leastLatency{}
+
+	for i := 0; i < 20; i++ {
+		dispatch(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	assert.Greater(t, fastHits.Load(), 3*slowHits.Load(), "traffic should shift to the faster backend")
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/maxnetyaga/architecture-practice-5/datastore"
+	"github.com/maxnetyaga/architecture-practice-5/datastore/datastorepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// grpcServer serves the datastorepb.Datastore API from db.
+type grpcServer struct {
+	datastorepb.UnimplementedDatastoreServer
+	db *datastore.Db
+}
+
+func newGRPCServer(db *datastore.Db) *grpc.Server {
+	s := grpc.NewServer()
+	datastorepb.RegisterDatastoreServer(s, &grpcServer{db: db})
+	return s
+}
+
+// stopGRPC waits up to -shutdown-timeout for in-flight calls and open scans
+// to finish and then cancels the rest.
+func stopGRPC(s *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(*shutdownTimeout):
+		slog.Warn("Failed to drain gRPC calls, cancelling them")
+		s.Stop()
+	}
+}
+
+func (s *grpcServer) Get(ctx context.Context, req *datastorepb.GetRequest) (*datastorepb.GetResponse, error) {
+	value, err := s.db.GetContext(ctx, req.Key)
+	if err == nil && value == "" && *emptyValue == emptyValueAbsent {
+		err = datastore.ErrNotFound
+	}
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &datastorepb.GetResponse{Value: value}, nil
+}
+
+func (s *grpcServer) Put(ctx context.Context, req *datastorepb.PutRequest) (*datastorepb.PutResponse, error) {
+	n, err := s.db.PutNContext(ctx, req.Key, req.Value)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if req.Sync {
+		if err := s.db.Sync(); err != nil {
+			return nil, grpcError(err)
+		}
+	}
+	return &datastorepb.PutResponse{BytesWritten: int64(n)}, nil
+}
+
+func (s *grpcServer) Delete(ctx context.Context, req *datastorepb.DeleteRequest) (*datastorepb.DeleteResponse, error) {
+	if err := s.db.Delete(req.Key); err != nil {
+		return nil, grpcError(err)
+	}
+	return &datastorepb.DeleteResponse{}, nil
+}
+
+// Scan reads each value only once the previous one was handed to the
+// stream, so a slow client holds back the scan instead of it buffering
+// the whole range.
+func (s *grpcServer) Scan(req *datastorepb.ScanRequest, stream grpc.ServerStreamingServer[datastorepb.ScanResponse]) error {
+	it, err := s.db.Scan(req.Prefix)
+	if err != nil {
+		return grpcError(err)
+	}
+	defer it.Close()
+
+	for it.Next() {
+		value, err := it.Value()
+		if err != nil {
+			return grpcError(err)
+		}
+		if err := stream.Send(&datastorepb.ScanResponse{Key: it.Key(), Value: value}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// grpcError maps the store's errors to status codes.
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, datastore.ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, datastore.ErrKeyTooLong), errors.Is(err, datastore.ErrValueTooLong),
+		errors.Is(err, datastore.ErrReservedKey):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, datastore.ErrReadOnly):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, datastore.ErrReadTimeout):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/maxnetyaga/architecture-practice-5/datastore"
+	"github.com/maxnetyaga/architecture-practice-5/datastore/datastorepb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newTestGRPCClient(t *testing.T, options ...datastore.Option) datastorepb.DatastoreClient {
+	db, err := datastore.OpenWithOptions(t.TempDir(), options...)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	lis := bufconn.Listen(1 << 20)
+	s := newGRPCServer(db)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return datastorepb.NewDatastoreClient(conn)
+}
+
+func TestGRPC_PutGetDelete(t *testing.T) {
+	client := newTestGRPCClient(t)
+	ctx := context.Background()
+
+	_, err := client.Get(ctx, &datastorepb.GetRequest{Key: "k"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	put, err := client.Put(ctx, &datastorepb.PutRequest{Key: "k", Value: "v", Sync: true})
+	require.NoError(t, err)
+	assert.EqualValues(t, 18, put.BytesWritten)
+
+	get, err := client.Get(ctx, &datastorepb.GetRequest{Key: "k"})
+	require.NoError(t, err)
+	assert.Equal(t, "v", get.Value)
+
+	_, err = client.Delete(ctx, &datastorepb.DeleteRequest{Key: "k"})
+	require.NoError(t, err)
+	_, err = client.Get(ctx, &datastorepb.GetRequest{Key: "k"})
+	assert.Equal(t, codes.NotFound, status.Code(err), "a deleted key should be gone")
+	_, err = client.Delete(ctx, &datastorepb.DeleteRequest{Key: "k"})
+	assert.Equal(t, codes.NotFound, status.Code(err), "deleting an absent key should fail")
+}
+
+func TestGRPC_PutTooLarge(t *testing.T) {
+	client := newTestGRPCClient(t, datastore.WithMaxValueLen(4))
+
+	_, err := client.Put(context.Background(), &datastorepb.PutRequest{Key: "k", Value: "vvvvv"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGRPC_Scan(t *testing.T) {
+	client := newTestGRPCClient(t)
+	ctx := context.Background()
+
+	for i := 0; i < 100; i++ {
+		_, err := client.Put(ctx, &datastorepb.PutRequest{Key: fmt.Sprintf("user:%03d", i), Value: fmt.Sprint(i)})
+		require.NoError(t, err)
+	}
+	_, err := client.Put(ctx, &datastorepb.PutRequest{Key: "other", Value: "x"})
+	require.NoError(t, err)
+
+	stream, err := client.Scan(ctx, &datastorepb.ScanRequest{Prefix: "user:"})
+	require.NoError(t, err)
+	var i int
+	for ; ; i++ {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("user:%03d", i), resp.Key)
+		assert.Equal(t, fmt.Sprint(i), resp.Value)
+	}
+	assert.Equal(t, 100, i)
+}
@@ -1,42 +1,300 @@
 package main
 
 import (
+	"cmp"
+	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/maxnetyaga/architecture-practice-5/datastore"
+	"github.com/maxnetyaga/architecture-practice-5/logging"
+	"github.com/maxnetyaga/architecture-practice-5/signal"
+	"github.com/maxnetyaga/architecture-practice-5/tracing"
+	"google.golang.org/grpc"
+)
+
+const (
+	envPort        = "DB_PORT"
+	envDataDir     = "DB_DIR"
+	defaultPort    = 8083
+	defaultDataDir = "./data"
+)
+
+const (
+	emptyValueValid  = "valid"
+	emptyValueAbsent = "absent"
+)
+
+var (
+	port        = flag.Int("port", 0, "port to listen on; overrides $"+envPort+", defaults to 8083")
+	dataDir     = flag.String("data-dir", "", "directory of the store; overrides $"+envDataDir+", defaults to "+defaultDataDir)
+	segmentSize = flag.Int64("segment-size", 0,
+		"seal the data file into a segment once it reaches this many bytes; 0 never seals by size")
+	emptyValue = flag.String("empty-value", emptyValueValid,
+		"how GET treats keys stored with an empty value: 'valid' returns 200 with an empty value, 'absent' returns 404")
+	autoMerge = flag.Bool("auto-merge", true,
+		"merge segments in the background on rollover; disable when compacting offline with dbtool")
+	mergeInterval = flag.Duration("merge-interval", 0,
+		"also check this often whether segments need merging, so an idle store still compacts; 0 disables it")
+	syncEvery = flag.Int("sync-every", 0,
+		"fsync the data file after this many writes; 1 makes every write durable, 0 leaves it to the OS")
+	syncInterval = flag.Duration("sync-interval", 0,
+		"fsync unsynced writes in the background this often; 0 disables it")
+	shutdownTimeout = flag.Duration("shutdown-timeout", 10*time.Second,
+		"how long shutdown waits for in-flight requests before closing the store")
+	maxKeyLen   = flag.Int("max-key-len", 0, "reject keys longer than this many bytes with 413; 0 only applies the format limit")
+	maxValueLen = flag.Int("max-value-len", 0, "reject values longer than this many bytes with 413; 0 only applies the format limit")
+	readCache   = flag.Int("read-cache", 0, "cache up to this many bytes of recently read values in memory; 0 disables the cache")
+	grpcPort    = flag.Int("grpc-port", 0, "also serve the gRPC API on this port; 0 disables it")
+	logFormat   = flag.String("log-format", logging.FormatText, "log output format: 'text' or 'json'")
+	logLevel    = flag.String("log-level", "info", "lowest level logged: 'debug', 'info', 'warn' or 'error'")
 )
 
 func main() {
-	if err := os.MkdirAll("./data", 0755); err != nil {
-		log.Fatalf("Failed to create data directory: %v", err)
+	flag.Parse()
+	if err := logging.Setup(os.Stderr, *logFormat, *logLevel); err != nil {
+		logging.Fatal("Invalid logging flags", "error", err)
+	}
+
+	if *emptyValue != emptyValueValid && *emptyValue != emptyValueAbsent {
+		logging.Fatal(fmt.Sprintf("Invalid -empty-value %q, expected %q or %q", *emptyValue, emptyValueValid, emptyValueAbsent))
 	}
 
-	db, err := datastore.Open("./data", 0)
+	addr, err := listenAddr()
 	if err != nil {
-		log.Fatalf("DB init failed: %v", err)
+		logging.Fatal("Invalid port", "error", err)
+	}
+	dir := cmp.Or(*dataDir, os.Getenv(envDataDir), defaultDataDir)
+	if err := checkWritable(dir); err != nil {
+		logging.Fatal("Invalid data directory", "error", err)
 	}
+	slog.Info("Config", "addr", addr, "data-dir", dir, "segment-size", *segmentSize, "auto-merge", *autoMerge,
+		"sync-every", *syncEvery, "sync-interval", *syncInterval)
 
+	shutdownTracing, err := tracing.Setup(context.Background(), "db")
+	if err != nil {
+		logging.Fatal("Tracing setup failed", "error", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	db, err := datastore.OpenWithOptions(dir, datastore.Options{
+		SegmentSize:      *segmentSize,
+		DisableAutoMerge: !*autoMerge,
+		SyncEvery:        *syncEvery,
+		SyncInterval:     *syncInterval,
+		MaxKeyLen:        *maxKeyLen,
+		MaxValueLen:      *maxValueLen,
+		ReadCache:        *readCache,
+		MergeInterval:    *mergeInterval,
+		OnMergeError: func(err error) {
+			slog.Error("Background merge failed", "error", err)
+		},
+	})
+	if err != nil {
+		logging.Fatal("DB init failed", "error", err)
+	}
+
+	server := &http.Server{Addr: addr, Handler: newRouter(db)}
+	go func() {
+		slog.Info("Starting DB server", "addr", addr)
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			logging.Fatal("DB server failed", "error", err)
+		}
+	}()
+	var grpcSrv *grpc.Server
+	if *grpcPort != 0 {
+		lis, err := net.Listen("tcp", fmt.Sprintf(":%d", *grpcPort))
+		if err != nil {
+			logging.Fatal("gRPC listen failed", "error", err)
+		}
+		grpcSrv = newGRPCServer(db)
+		go func() {
+			slog.Info("Starting gRPC server", "addr", lis.Addr().String())
+			if err := grpcSrv.Serve(lis); err != nil {
+				logging.Fatal("gRPC server failed", "error", err)
+			}
+		}()
+	}
+	signal.WaitForTerminationSignal()
+
+	if grpcSrv != nil {
+		stopGRPC(grpcSrv)
+	}
+	if err := shutdown(server, db); err != nil {
+		logging.Fatal("Shutdown failed", "error", err)
+	}
+}
+
+// listenAddr is the address of -port, falling back to $DB_PORT and 8083.
+func listenAddr() (string, error) {
+	port := *port
+	if port == 0 {
+		if env := os.Getenv(envPort); env != "" {
+			var err error
+			if port, err = strconv.Atoi(env); err != nil {
+				return "", fmt.Errorf("$%s %q is not a number", envPort, env)
+			}
+		} else {
+			port = defaultPort
+		}
+	}
+	if port < 1 || port > 65535 {
+		return "", fmt.Errorf("%d is out of range", port)
+	}
+	return fmt.Sprintf(":%d", port), nil
+}
+
+// checkWritable creates dir if needed and makes sure files can be created in
+// it, so a bad mount fails at startup rather than on the first write.
+func checkWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".writable-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// shutdown stops server, waiting up to -shutdown-timeout for in-flight
+// requests, and then syncs and closes db so every acknowledged write is on
+// disk.
+func shutdown(server *http.Server, db datastore.Store) error {
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	serverErr := server.Shutdown(ctx)
+	if serverErr != nil {
+		slog.Warn("Failed to drain requests", "error", serverErr)
+	}
+
+	if err := db.Sync(); err != nil {
+		db.Close()
+		return fmt.Errorf("sync: %w", err)
+	}
+	if err := db.Close(); err != nil {
+		return fmt.Errorf("close: %w", err)
+	}
+	return serverErr
+}
+
+// newRouter serves the key-value API from store. The stats, backup and admin
+// routes need the disk store and are registered only when store is a *datastore.Db.
+func newRouter(store datastore.Store) *mux.Router {
 	r := mux.NewRouter()
+	r.Use(traceRoute)
+	r.HandleFunc("/health", healthHandler(store)).Methods("GET")
+	r.HandleFunc("/version", versionHandler).Methods("GET")
+	r.HandleFunc("/db", keysHandler(store)).Methods("GET")
+	if db, ok := store.(*datastore.Db); ok {
+		r.HandleFunc("/db/stats", statsHandler(db)).Methods("GET")
+		r.HandleFunc("/db/backup", backupHandler(db)).Methods("GET")
+		r.HandleFunc("/admin/compact", compactHandler(db)).Methods("POST")
+		r.HandleFunc("/admin/reindex", reindexHandler(db)).Methods("POST")
+		r.HandleFunc("/admin/operations", operationsHandler(db)).Methods("GET")
+		r.HandleFunc("/admin/operations/{id}", cancelOperationHandler(db)).Methods("DELETE")
+	}
+	r.HandleFunc("/db/batch", batchGetHandler(store)).Methods("GET")
+	r.HandleFunc("/db/batch", batchPutHandler(store)).Methods("POST")
+	r.HandleFunc("/db/{key}", getHandler(store)).Methods("GET")
+	r.HandleFunc("/db/{key}", putHandler(store)).Methods("POST")
+	r.HandleFunc("/db/{key}", deleteHandler(store)).Methods("DELETE")
+	return r
+}
+
+// healthHandler answers 200 while store can take writes and 503 with the
+// reason otherwise.
+func healthHandler(db datastore.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		if err := db.Check(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("OK"))
+	}
+}
+
+// traceRoute runs a request in a span named after its route, tagged with the
+// key it is about.
+func traceRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.Method
+		if route := mux.CurrentRoute(r); route != nil {
+			if tmpl, err := route.GetPathTemplate(); err == nil {
+				name += " " + tmpl
+			}
+		}
+		handler := next
+		if key, ok := mux.Vars(r)["key"]; ok {
+			handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tracing.Key(r.Context(), key)
+				next.ServeHTTP(w, r)
+			})
+		}
+		tracing.Middleware(name, handler).ServeHTTP(w, r)
+	})
+}
 
-	r.HandleFunc("/db/{key}", func(w http.ResponseWriter, r *http.Request) {
+// getHandler tags the value with a weak ETag and answers 304 to a GET whose
+// If-None-Match lists it.
+func getHandler(db datastore.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 		key := mux.Vars(r)["key"]
-		value, err := db.Get(key)
-		if err != nil {
+		value, err := db.GetContext(r.Context(), key)
+		if err != nil || (value == "" && *emptyValue == emptyValueAbsent) {
 			http.Error(w, "not found", http.StatusNotFound)
 			return
 		}
+		etag := valueETag(value)
+		w.Header().Set("ETag", etag)
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]string{
 			"key":   key,
 			"value": value,
 		})
-	}).Methods("GET")
+	}
+}
+
+// valueETag derives a weak ETag from value, so it stays the same across
+// restarts and merges for as long as the value does.
+func valueETag(value string) string {
+	h := fnv.New64a()
+	h.Write([]byte(value))
+	return fmt.Sprintf(`W/"%x"`, h.Sum64())
+}
+
+// etagMatches reports whether the If-None-Match header lists etag, using the
+// weak comparison RFC 9110 prescribes for it.
+func etagMatches(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
 
-	r.HandleFunc("/db/{key}", func(w http.ResponseWriter, r *http.Request) {
+func putHandler(db datastore.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 		key := mux.Vars(r)["key"]
 		var body struct {
 			Value string `json:"value"`
@@ -45,13 +303,191 @@ func main() {
 			http.Error(w, "invalid JSON", http.StatusBadRequest)
 			return
 		}
-		if err := db.Put(key, body.Value); err != nil {
+		n, err := db.PutNContext(r.Context(), key, body.Value)
+		if errors.Is(err, datastore.ErrKeyTooLong) || errors.Is(err, datastore.ErrValueTooLong) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		} else if err != nil {
 			http.Error(w, "failed to store value", http.StatusInternalServerError)
 			return
 		}
+		// ?sync=true makes the write durable before it is acknowledged.
+		if r.URL.Query().Get("sync") == "true" {
+			if err := db.Sync(); err != nil {
+				http.Error(w, "failed to sync value", http.StatusInternalServerError)
+				return
+			}
+		}
+		w.Header().Set("X-Bytes-Written", strconv.Itoa(n))
 		w.WriteHeader(http.StatusNoContent)
-	}).Methods("POST")
+	}
+}
+
+func deleteHandler(db datastore.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := db.Delete(mux.Vars(r)["key"])
+		if errors.Is(err, datastore.ErrNotFound) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "failed to delete value", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// batchGetHandler reads the comma-separated ?keys= list. It answers 200 even
+// if some keys can't be read: "values" holds the keys found, "missing" the
+// absent ones and "errors" the reason for any other key that failed.
+func batchGetHandler(db datastore.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		param := r.URL.Query().Get("keys")
+		if param == "" {
+			http.Error(w, "keys required", http.StatusBadRequest)
+			return
+		}
+
+		values, err := db.GetMulti(strings.Split(param, ","))
+		resp := struct {
+			Values  map[string]string `json:"values"`
+			Missing []string          `json:"missing"`
+			Errors  map[string]string `json:"errors,omitempty"`
+		}{Values: values, Missing: []string{}}
+		var keyErrs datastore.KeyErrors
+		if errors.As(err, &keyErrs) {
+			for key, keyErr := range keyErrs {
+				if errors.Is(keyErr, datastore.ErrNotFound) {
+					resp.Missing = append(resp.Missing, key)
+					continue
+				}
+				if resp.Errors == nil {
+					resp.Errors = make(map[string]string)
+				}
+				resp.Errors[key] = keyErr.Error()
+			}
+		} else if err != nil {
+			http.Error(w, "failed to read values", http.StatusInternalServerError)
+			return
+		}
+		if *emptyValue == emptyValueAbsent {
+			for key, value := range values {
+				if value == "" {
+					delete(values, key)
+					resp.Missing = append(resp.Missing, key)
+				}
+			}
+		}
+		sort.Strings(resp.Missing)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// batchPutHandler stores a JSON object of key-value pairs. The batch isn't
+// atomic: if it fails part way, the 500 response lists the keys in "stored"
+// that were written anyway.
+func batchPutHandler(db datastore.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var pairs map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&pairs); err != nil {
+			http.Error(w, "invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		n, _, err := db.PutBatchN(pairs)
+		w.Header().Set("X-Bytes-Written", strconv.Itoa(n))
+		var batchErr *datastore.BatchError
+		switch {
+		case err == nil:
+			w.WriteHeader(http.StatusNoContent)
+		case errors.Is(err, datastore.ErrReservedKey):
+			http.Error(w, "reserved key", http.StatusBadRequest)
+		case errors.Is(err, datastore.ErrKeyTooLong), errors.Is(err, datastore.ErrValueTooLong):
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		case errors.As(err, &batchErr):
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]any{"stored": batchErr.Stored, "error": batchErr.Err.Error()})
+		default:
+			http.Error(w, "failed to store values", http.StatusInternalServerError)
+		}
+	}
+}
+
+func keysHandler(db datastore.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string][]string{"keys": db.Keys()})
+	}
+}
 
-	log.Println("Starting DB server on :8083")
-	log.Fatal(http.ListenAndServe(":8083", r))
+func statsHandler(db *datastore.Db) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(db.Stats())
+	}
+}
+
+// backupHandler streams a tar snapshot of the store. The status is sent
+// before the archive, so a failure part way can only be logged and leaves
+// the client with a truncated archive.
+func backupHandler(db *datastore.Db) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-tar")
+		w.Header().Set("Content-Disposition", `attachment; filename="backup.tar"`)
+		if err := db.Snapshot(w); err != nil {
+			slog.Error("Backup failed", "error", err)
+		}
+	}
+}
+
+func compactHandler(db *datastore.Db) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		freed, err := db.CompactNow()
+		if err != nil {
+			http.Error(w, "compaction failed", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int64{"freedBytes": freed})
+	}
+}
+
+func reindexHandler(db *datastore.Db) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := db.Reindex(); err != nil {
+			slog.Error("Reindex failed", "error", err)
+			http.Error(w, "reindex failed", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func operationsHandler(db *datastore.Db) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(db.Operations())
+	}
+}
+
+func cancelOperationHandler(db *datastore.Db) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			http.Error(w, "invalid operation id", http.StatusBadRequest)
+			return
+		}
+		if err := db.CancelOperation(id); err != nil {
+			if errors.Is(err, datastore.ErrOperationNotFound) {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "failed to cancel operation", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
 }
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/maxnetyaga/architecture-practice-5/datastore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRouter serves the data routes from an in-memory store.
+func newTestRouter(t *testing.T) *mux.Router {
+	return newRouter(datastore.OpenMemory())
+}
+
+// newDiskRouter serves all routes, including stats, backup and admin, from a
+// store in a temporary directory.
+func newDiskRouter(t *testing.T) *mux.Router {
+	db, err := datastore.Open(t.TempDir(), 0)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	return newRouter(db)
+}
+
+func doRequest(r http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return rr
+}
+
+func TestGetEmptyValue_Valid(t *testing.T) {
+	orig := *emptyValue
+	defer func() { *emptyValue = orig }()
+	*emptyValue = emptyValueValid
+
+	r := newTestRouter(t)
+	assert.Equal(t, http.StatusNoContent, doRequest(r, "POST", "/db/k", `{"value":""}`).Code)
+
+	rr := doRequest(r, "GET", "/db/k", "")
+	assert.Equal(t, http.StatusOK, rr.Code, "empty value should be served as a stored value")
+
+	var body map[string]string
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+	assert.Equal(t, map[string]string{"key": "k", "value": ""}, body)
+}
+
+func TestGetEmptyValue_Absent(t *testing.T) {
+	orig := *emptyValue
+	defer func() { *emptyValue = orig }()
+	*emptyValue = emptyValueAbsent
+
+	r := newTestRouter(t)
+	assert.Equal(t, http.StatusNoContent, doRequest(r, "POST", "/db/k", `{"value":""}`).Code)
+	assert.Equal(t, http.StatusNotFound, doRequest(r, "GET", "/db/k", "").Code,
+		"empty value should be treated as absent")
+
+	assert.Equal(t, http.StatusNoContent, doRequest(r, "POST", "/db/k", `{"value":"v"}`).Code)
+	assert.Equal(t, http.StatusOK, doRequest(r, "GET", "/db/k", "").Code)
+}
+
+func TestCompactHandler(t *testing.T) {
+	r := newDiskRouter(t)
+
+	rr := doRequest(r, "POST", "/admin/compact", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var body map[string]int64
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+	assert.Contains(t, body, "freedBytes")
+}
+
+func TestOperationsHandlers(t *testing.T) {
+	r := newDiskRouter(t)
+
+	rr := doRequest(r, "GET", "/admin/operations", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, "[]", rr.Body.String(), "no operations should be running on an idle store")
+
+	assert.Equal(t, http.StatusNotFound, doRequest(r, "DELETE", "/admin/operations/42", "").Code)
+	assert.Equal(t, http.StatusBadRequest, doRequest(r, "DELETE", "/admin/operations/abc", "").Code)
+}
+
+func TestReindexHandler(t *testing.T) {
+	r := newDiskRouter(t)
+
+	assert.Equal(t, http.StatusNoContent, doRequest(r, "POST", "/db/k", `{"value":"v"}`).Code)
+	assert.Equal(t, http.StatusNoContent, doRequest(r, "POST", "/admin/reindex", "").Code)
+	assert.Equal(t, http.StatusOK, doRequest(r, "GET", "/db/k", "").Code, "data should survive a reindex")
+}
+
+func TestPutHandlerSync(t *testing.T) {
+	r := newTestRouter(t)
+
+	rr := doRequest(r, "POST", "/db/k?sync=true", `{"value":"v"}`)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, "18", rr.Header().Get("X-Bytes-Written"), "a record is its key, value and 16 bytes of framing")
+	assert.Equal(t, http.StatusOK, doRequest(r, "GET", "/db/k", "").Code)
+}
+
+func TestHealthHandler(t *testing.T) {
+	dir := t.TempDir()
+	db, err := datastore.Open(dir, 0)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	r := newRouter(db)
+
+	rr := doRequest(r, "GET", "/health", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "OK", rr.Body.String())
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "current-data")))
+	assert.Equal(t, http.StatusServiceUnavailable, doRequest(r, "GET", "/health", "").Code,
+		"a store that lost its data file should be reported unhealthy")
+}
+
+func TestVersionHandler(t *testing.T) {
+	rr := doRequest(newTestRouter(t), "GET", "/version", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+	var info buildInfo
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &info))
+	assert.Equal(t, version, info.Version)
+	assert.Equal(t, runtime.Version(), info.GoVersion)
+}
+
+func TestGetHandler_ETag(t *testing.T) {
+	r := newTestRouter(t)
+	assert.Equal(t, http.StatusNoContent, doRequest(r, "POST", "/db/k", `{"value":"v1"}`).Code)
+
+	rr := doRequest(r, "GET", "/db/k", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	etag := rr.Header().Get("ETag")
+	require.True(t, strings.HasPrefix(etag, `W/"`), "want a weak ETag, got %q", etag)
+
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/db/k", nil)
+		req.Header.Set("If-None-Match", ifNoneMatch)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+	rr = get(etag)
+	assert.Equal(t, http.StatusNotModified, rr.Code)
+	assert.Empty(t, rr.Body.String())
+	assert.Equal(t, etag, rr.Header().Get("ETag"))
+	assert.Equal(t, http.StatusNotModified, get(`"other", `+strings.TrimPrefix(etag, "W/")).Code,
+		"a strong form of the tag in a list should match weakly")
+	assert.Equal(t, http.StatusNotModified, get("*").Code)
+
+	assert.Equal(t, http.StatusNoContent, doRequest(r, "POST", "/db/k", `{"value":"v2"}`).Code)
+	rr = get(etag)
+	assert.Equal(t, http.StatusOK, rr.Code, "a changed value should be sent again")
+	assert.NotEqual(t, etag, rr.Header().Get("ETag"))
+	assert.JSONEq(t, `{"key":"k","value":"v2"}`, rr.Body.String())
+}
+
+func TestKeysHandler(t *testing.T) {
+	r := newTestRouter(t)
+
+	rr := doRequest(r, "GET", "/db", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"keys":[]}`, rr.Body.String())
+
+	for _, key := range []string{"b", "a"} {
+		assert.Equal(t, http.StatusNoContent, doRequest(r, "POST", "/db/"+key, `{"value":"v"}`).Code)
+	}
+	rr = doRequest(r, "GET", "/db", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"keys":["a","b"]}`, rr.Body.String())
+}
+
+func TestStatsHandler(t *testing.T) {
+	r := newDiskRouter(t)
+	assert.Equal(t, http.StatusNoContent, doRequest(r, "POST", "/db/k", `{"value":"v"}`).Code)
+
+	rr := doRequest(r, "GET", "/db/stats", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var stats datastore.Stats
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &stats))
+	assert.Equal(t, 1, stats.CurrentKeys)
+	assert.Positive(t, stats.DiskSize)
+	assert.Positive(t, stats.CurrentSize)
+	assert.Equal(t, stats.DiskSize, stats.CurrentSize+stats.SegmentsSize)
+}
+
+func TestRouter_MemoryStoreHasNoAdminRoutes(t *testing.T) {
+	r := newTestRouter(t)
+	assert.Equal(t, http.StatusNotFound, doRequest(r, "POST", "/admin/compact", "").Code)
+	assert.Equal(t, http.StatusNotFound, doRequest(r, "GET", "/db/stats", "").Code, "stats should fall through to the key route")
+}
+
+func TestDeleteHandler(t *testing.T) {
+	r := newTestRouter(t)
+	assert.Equal(t, http.StatusNoContent, doRequest(r, "POST", "/db/k", `{"value":"v"}`).Code)
+	assert.Equal(t, http.StatusNoContent, doRequest(r, "DELETE", "/db/k", "").Code)
+	assert.Equal(t, http.StatusNotFound, doRequest(r, "GET", "/db/k", "").Code, "a deleted key should be gone")
+	assert.Equal(t, http.StatusNotFound, doRequest(r, "DELETE", "/db/k", "").Code, "deleting an absent key should fail")
+	assert.Equal(t, http.StatusMethodNotAllowed, doRequest(r, "PUT", "/db/k", "").Code)
+}
+
+func TestBatchHandlers_AllPresent(t *testing.T) {
+	r := newTestRouter(t)
+	rr := doRequest(r, "POST", "/db/batch", `{"a":"1","b":"2","c":"3"}`)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, "54", rr.Header().Get("X-Bytes-Written"))
+
+	rr = doRequest(r, "GET", "/db/batch?keys=a,b,c", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"values":{"a":"1","b":"2","c":"3"},"missing":[]}`, rr.Body.String())
+	assert.Equal(t, http.StatusOK, doRequest(r, "GET", "/db/b", "").Code, "batch keys should be readable one by one")
+}
+
+func TestBatchHandlers_SomeMissing(t *testing.T) {
+	r := newTestRouter(t)
+	assert.Equal(t, http.StatusNoContent, doRequest(r, "POST", "/db/batch", `{"a":"1"}`).Code)
+
+	rr := doRequest(r, "GET", "/db/batch?keys=a,y,x", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"values":{"a":"1"},"missing":["x","y"]}`, rr.Body.String())
+}
+
+func TestBatchHandlers_Malformed(t *testing.T) {
+	r := newTestRouter(t)
+	assert.Equal(t, http.StatusBadRequest, doRequest(r, "POST", "/db/batch", `{"a":1}`).Code)
+	assert.Equal(t, http.StatusBadRequest, doRequest(r, "POST", "/db/batch", `["a"]`).Code)
+	assert.Equal(t, http.StatusBadRequest, doRequest(r, "POST", "/db/batch", `{"a":`).Code)
+	assert.Equal(t, http.StatusBadRequest, doRequest(r, "GET", "/db/batch", "").Code)
+	assert.Equal(t, http.StatusNotFound, doRequest(r, "GET", "/db/a", "").Code, "a rejected batch should store nothing")
+}
+
+func TestShutdown_DrainsRequestAndClosesDb(t *testing.T) {
+	dir := t.TempDir()
+	db, err := datastore.Open(dir, 0)
+	require.NoError(t, err)
+	router, started := newRouter(db), make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		router.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	body, writer := io.Pipe()
+	done := make(chan int, 1)
+	go func() {
+		resp, err := http.Post(ts.URL+"/db/k", "application/json", body)
+		if err != nil {
+			done <- 0
+			return
+		}
+		resp.Body.Close()
+		done <- resp.StatusCode
+	}()
+	_, err = writer.Write([]byte(`{"value":`))
+	require.NoError(t, err)
+	<-started
+
+	shut := make(chan error, 1)
+	go func() { shut <- shutdown(ts.Config, db) }()
+	time.Sleep(50 * time.Millisecond)
+	_, err = writer.Write([]byte(`"v"}`))
+	require.NoError(t, err)
+	writer.Close()
+
+	assert.Equal(t, http.StatusNoContent, <-done, "the in-flight write should complete")
+	require.NoError(t, <-shut)
+
+	db, err = datastore.Open(dir, 0)
+	require.NoError(t, err, "shutdown should release the store")
+	defer db.Close()
+	value, err := db.Get("k")
+	require.NoError(t, err)
+	assert.Equal(t, "v", value)
+}
+
+func TestListenAddr(t *testing.T) {
+	orig := *port
+	defer func() { *port = orig }()
+
+	*port = 0
+	t.Setenv(envPort, "")
+	addr, err := listenAddr()
+	require.NoError(t, err)
+	assert.Equal(t, ":8083", addr)
+
+	t.Setenv(envPort, "9000")
+	addr, err = listenAddr()
+	require.NoError(t, err)
+	assert.Equal(t, ":9000", addr)
+
+	*port = 9100
+	addr, err = listenAddr()
+	require.NoError(t, err)
+	assert.Equal(t, ":9100", addr, "-port should override the environment")
+
+	*port = 0
+	t.Setenv(envPort, "db")
+	_, err = listenAddr()
+	assert.Error(t, err)
+	*port = 70000
+	_, err = listenAddr()
+	assert.Error(t, err)
+}
+
+func TestCheckWritable(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "nested", "data")
+	require.NoError(t, checkWritable(dir), "a missing directory should be created")
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "the probe file should be removed")
+
+	file := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(file, nil, 0644))
+	assert.Error(t, checkWritable(filepath.Join(file, "data")))
+}
+
+func TestBackupHandler(t *testing.T) {
+	r := newDiskRouter(t)
+	assert.Equal(t, http.StatusNoContent, doRequest(r, "POST", "/db/k", `{"value":"v"}`).Code)
+
+	rr := doRequest(r, "GET", "/db/backup", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/x-tar", rr.Header().Get("Content-Type"))
+
+	dir := filepath.Join(t.TempDir(), "restored")
+	require.NoError(t, datastore.Restore(dir, rr.Body))
+	db, err := datastore.Open(dir, 0)
+	require.NoError(t, err)
+	defer db.Close()
+	value, err := db.Get("k")
+	require.NoError(t, err)
+	assert.Equal(t, "v", value)
+}
+
+func TestPutHandlers_TooLarge(t *testing.T) {
+	r := newRouter(datastore.OpenMemory(datastore.Options{MaxKeyLen: 4, MaxValueLen: 4}))
+
+	assert.Equal(t, http.StatusNoContent, doRequest(r, "POST", "/db/kkkk", `{"value":"vvvv"}`).Code)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, doRequest(r, "POST", "/db/kkkkk", `{"value":"v"}`).Code)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, doRequest(r, "POST", "/db/k", `{"value":"vvvvv"}`).Code)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, doRequest(r, "POST", "/db/batch", `{"a":"1","b":"vvvvv"}`).Code)
+	assert.Equal(t, http.StatusNotFound, doRequest(r, "GET", "/db/a", "").Code, "an oversize batch should not be written")
+}
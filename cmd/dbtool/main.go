@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/maxnetyaga/architecture-practice-5/datastore"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s compact [-dir path] [-notify addr]\n", os.Args[0])
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	switch os.Args[1] {
+	case "compact":
+		compact(os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+func compact(args []string) {
+	fs := flag.NewFlagSet("compact", flag.ExitOnError)
+	dir := fs.String("dir", "./data", "data directory of the store")
+	notify := fs.String("notify", "", "address of the DB server to ask to reindex after compaction, e.g. localhost:8083")
+	fs.Parse(args)
+
+	merged, err := datastore.CompactOffline(*dir)
+	if err != nil {
+		log.Fatalf("Compaction failed: %v", err)
+	}
+	log.Printf("Compacted %d segments in %s", merged, *dir)
+
+	if *notify == "" || merged == 0 {
+		return
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(fmt.Sprintf("http://%s/admin/reindex", *notify), "application/json", nil)
+	if err != nil {
+		log.Fatalf("Reindex request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		log.Fatalf("Reindex failed with status %d", resp.StatusCode)
+	}
+	log.Printf("DB server at %s adopted the compaction", *notify)
+}
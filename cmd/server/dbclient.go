@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/maxnetyaga/architecture-practice-5/tracing"
+)
+
+var (
+	dbTimeout      = flag.Duration("db-timeout", 3*time.Second, "timeout of a request to the DB, including reading its response")
+	dbRetries      = flag.Int("db-retries", 2, "how many times a DB read is retried after a connection error")
+	dbInitRetries  = flag.Int("db-init-retries", 10, "how many times the startup write is retried while the DB comes up")
+	dbInitTimeout  = flag.Duration("db-init-timeout", time.Minute, "how long the server waits for the DB at startup before exiting")
+	dbRetryBackoff = flag.Duration("db-retry-backoff", 100*time.Millisecond, "wait before the first retry of a DB request, doubled on each further one")
+)
+
+// dbClient is shared by all requests to the DB. main sets its timeout from
+// -db-timeout.
+var dbClient = &http.Client{Timeout: 3 * time.Second}
+
+// dbRequest sends a request to the DB, retrying up to retries times with
+// exponential backoff when it can't be delivered. Responses are returned as
+// they are, whatever their status, and timeouts aren't retried since the DB
+// is more likely overloaded than down.
+func dbRequest(ctx context.Context, method, target string, body []byte, retries int) (*http.Response, error) {
+	var addr string
+	if u, err := url.Parse(target); err == nil {
+		addr = u.Host
+	}
+	ctx, span := tracing.StartClient(ctx, "db "+method, addr)
+	defer span.End()
+
+	var resp *http.Response
+	err := retry(ctx, retries, method+" "+target, func() (bool, error) {
+		req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+		if err != nil {
+			return false, err
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		tracing.Inject(ctx, req.Header)
+		resp, err = dbClient.Do(req)
+		return err != nil && transient(ctx, err), err
+	})
+	if err != nil {
+		tracing.Fail(span, err)
+	} else {
+		tracing.SetStatus(span, resp.StatusCode)
+	}
+	return resp, err
+}
+
+// retry calls try until it succeeds, reports an error that isn't worth
+// retrying, or has been retried retries times. The wait between calls
+// starts at -db-retry-backoff and doubles each time.
+func retry(ctx context.Context, retries int, what string, try func() (again bool, err error)) error {
+	backoff := *dbRetryBackoff
+	for attempt := 1; ; attempt++ {
+		again, err := try()
+		if err == nil || !again || attempt > retries {
+			return err
+		}
+
+		slog.Warn("DB request failed, retrying", "request", what, "attempt", attempt, "backoff", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w after %v", ctx.Err(), err)
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// initDb stores today's date under team. Since the DB may still be starting,
+// connection errors and 5xx answers are retried up to -db-init-retries times
+// within -db-init-timeout.
+func initDb(dbAddr, team string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), *dbInitTimeout)
+	defer cancel()
+
+	payload, _ := json.Marshal(map[string]string{"value": time.Now().Format("2006-01-02")})
+	target := fmt.Sprintf("http://%s/db/%s", dbAddr, url.PathEscape(team))
+	return retry(ctx, *dbInitRetries, "init", func() (bool, error) {
+		resp, err := dbRequest(ctx, "POST", target, payload, 0)
+		if err != nil {
+			return transient(ctx, err), err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			body, _ := io.ReadAll(resp.Body)
+			return resp.StatusCode >= 500, fmt.Errorf("status %d: %s", resp.StatusCode, body)
+		}
+		return false, nil
+	})
+}
+
+func transient(ctx context.Context, err error) bool {
+	var netErr net.Error
+	return ctx.Err() == nil && !(errors.As(err, &netErr) && netErr.Timeout())
+}
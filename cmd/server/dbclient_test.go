@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func withDbConfig(t *testing.T, timeout time.Duration, retries int) {
+	origTimeout, origRetries, origBackoff := dbClient.Timeout, *dbRetries, *dbRetryBackoff
+	t.Cleanup(func() { dbClient.Timeout, *dbRetries, *dbRetryBackoff = origTimeout, origRetries, origBackoff })
+	dbClient.Timeout, *dbRetries, *dbRetryBackoff = timeout, retries, time.Millisecond
+}
+
+func TestSomeDataHandler_SlowDbTimesOut(t *testing.T) {
+	withDbConfig(t, 50*time.Millisecond, 2)
+	var calls atomic.Int32
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	start := time.Now()
+	rr := httptest.NewRecorder()
+	someDataHandler(strings.TrimPrefix(ts.URL, "http://"))(rr, httptest.NewRequest("GET", "/api/v1/some-data?key=k", nil))
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.Less(t, time.Since(start), time.Second, "the handler should give up after -db-timeout")
+	assert.EqualValues(t, 1, calls.Load(), "timeouts should not be retried")
+}
+
+func TestSomeDataHandler_RetriesDroppedConnection(t *testing.T) {
+	withDbConfig(t, time.Second, 2)
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			require.NoError(t, err)
+			conn.Close()
+			return
+		}
+		w.Write([]byte(`{"key":"k","value":"v"}`))
+	}))
+	defer ts.Close()
+
+	rr := httptest.NewRecorder()
+	someDataHandler(strings.TrimPrefix(ts.URL, "http://"))(rr, httptest.NewRequest("GET", "/api/v1/some-data?key=k", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"key":"k","value":"v"}`, rr.Body.String())
+	assert.EqualValues(t, 2, calls.Load())
+}
+
+func TestDbRequest_GivesUpAfterRetries(t *testing.T) {
+	withDbConfig(t, time.Second, 0)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	addr := ts.URL
+	ts.Close()
+
+	_, err := dbRequest(context.Background(), "POST", addr+"/db/team", []byte(`{}`), 3)
+	assert.Error(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = dbRequest(ctx, "GET", addr+"/db/team", nil, 3)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestInitDb_WaitsForDb(t *testing.T) {
+	withDbConfig(t, time.Second, 0)
+	var calls atomic.Int32
+	var stored string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= 3 {
+			http.Error(w, "starting", http.StatusServiceUnavailable)
+			return
+		}
+		stored = r.URL.Path
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	require.NoError(t, initDb(strings.TrimPrefix(ts.URL, "http://"), "my team"))
+	assert.EqualValues(t, 4, calls.Load(), "init should retry until the DB accepts the write")
+	assert.Equal(t, "/db/my team", stored)
+}
+
+func TestInitDb_GivesUp(t *testing.T) {
+	withDbConfig(t, time.Second, 0)
+	origRetries := *dbInitRetries
+	defer func() { *dbInitRetries = origRetries }()
+	*dbInitRetries = 2
+
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "starting", http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+	assert.ErrorContains(t, initDb(strings.TrimPrefix(ts.URL, "http://"), "team"), "status 503")
+	assert.EqualValues(t, 3, calls.Load())
+
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "bad", http.StatusBadRequest)
+	}))
+	defer ts.Close()
+	calls.Store(0)
+	assert.Error(t, initDb(strings.TrimPrefix(ts.URL, "http://"), "team"))
+	assert.EqualValues(t, 1, calls.Load(), "client errors should not be retried")
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// readiness answers /ready by pinging the DB's /db/stats, so the balancer
+// only routes to servers that can reach their data. A result is reused for
+// ttl to keep probes from hammering the DB.
+type readiness struct {
+	dbAddr string
+	ttl    time.Duration
+
+	mu        sync.Mutex
+	checkedAt time.Time
+	ready     bool
+}
+
+func newReadiness(dbAddr string, ttl time.Duration) *readiness {
+	return &readiness{dbAddr: dbAddr, ttl: ttl}
+}
+
+func (rd *readiness) check(ctx context.Context) bool {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	if !rd.checkedAt.IsZero() && time.Since(rd.checkedAt) < rd.ttl {
+		return rd.ready
+	}
+
+	resp, err := dbRequest(ctx, "GET", "http://"+rd.dbAddr+"/db/stats", nil, 0)
+	rd.ready = err == nil && resp.StatusCode == http.StatusOK
+	if err == nil {
+		resp.Body.Close()
+	}
+	rd.checkedAt = time.Now()
+	return rd.ready
+}
+
+func (rd *readiness) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("content-type", "text/plain")
+	if !rd.check(r.Context()) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		rw.Write([]byte("DB UNREACHABLE"))
+		return
+	}
+	rw.WriteHeader(http.StatusOK)
+	rw.Write([]byte("READY"))
+}
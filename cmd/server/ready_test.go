@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReady_DbUpAndDown(t *testing.T) {
+	var calls atomic.Int32
+	db := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		assert.Equal(t, "/db/stats", r.URL.Path)
+		w.Write([]byte(`{}`))
+	}))
+	rd := newReadiness(strings.TrimPrefix(db.URL, "http://"), time.Hour)
+
+	for i := 0; i < 3; i++ {
+		rr := httptest.NewRecorder()
+		rd.ServeHTTP(rr, httptest.NewRequest("GET", "/ready", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+	assert.EqualValues(t, 1, calls.Load(), "the DB ping should be cached")
+
+	db.Close()
+	rr := httptest.NewRecorder()
+	rd.ServeHTTP(rr, httptest.NewRequest("GET", "/ready", nil))
+	assert.Equal(t, http.StatusOK, rr.Code, "a cached result should be served until it expires")
+
+	rd.ttl = 0
+	rr = httptest.NewRecorder()
+	rd.ServeHTTP(rr, httptest.NewRequest("GET", "/ready", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code, "an unreachable DB should fail readiness")
+}
+
+func TestReady_DbError(t *testing.T) {
+	db := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer db.Close()
+
+	rr := httptest.NewRecorder()
+	newReadiness(strings.TrimPrefix(db.URL, "http://"), 0).ServeHTTP(rr, httptest.NewRequest("GET", "/ready", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+}
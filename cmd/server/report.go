@@ -1,32 +1,190 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"io/fs"
+	"log/slog"
 	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
 )
 
+// reportMaxLen is the default of -report-max-len.
 const reportMaxLen = 100
 
-type Report map[string][]string
+// Report keeps the last maxLen request counters seen from each lb-author.
+// A balancer run with -trace sends lb-author, its name, and lb-req-cnt, its
+// count of forwarded requests, with every request. It is safe for concurrent
+// use.
+type Report struct {
+	mu      sync.Mutex
+	maxLen  int
+	entries map[string][]string
+	// dirty is set by Process and cleared by Save.
+	dirty bool
+}
+
+func NewReport(maxLen int) *Report {
+	return &Report{maxLen: maxLen, entries: make(map[string][]string)}
+}
 
-func (r Report) Process(req *http.Request) {
+func (r *Report) Process(req *http.Request) {
 	author := req.Header.Get("lb-author")
 	counter := req.Header.Get("lb-req-cnt")
-	log.Printf("GET some-data from [%s] request [%s]", author, counter)
+	slog.Info("GET some-data", "author", author, "request", counter)
 
 	if len(author) > 0 {
-		list := r[author]
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		list := r.entries[author]
 		list = append(list, counter)
-		if len(list) > reportMaxLen {
-			list = list[len(list)-reportMaxLen:]
+		if len(list) > r.maxLen {
+			list = list[len(list)-r.maxLen:]
 		}
-		r[author] = list
+		r.entries[author] = list
+		r.dirty = true
+	}
+}
+
+// Track processes every request before passing it to next.
+func (r *Report) Track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		r.Process(req)
+		next.ServeHTTP(rw, req)
+	})
+}
+
+func (r *Report) ServeHTTP(rw http.ResponseWriter, _ *http.Request) {
+	r.mu.Lock()
+	body, err := json.Marshal(r.entries)
+	r.mu.Unlock()
+	if err != nil {
+		http.Error(rw, "failed to encode report", http.StatusInternalServerError)
+		return
 	}
+
+	rw.Header().Set("content-type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write(append(body, '\n'))
+}
+
+// Reset clears the report.
+func (r *Report) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries, r.dirty = make(map[string][]string), true
+}
+
+// ServeReset handles DELETE /report.
+func (r *Report) ServeReset(rw http.ResponseWriter, _ *http.Request) {
+	r.Reset()
+	rw.WriteHeader(http.StatusNoContent)
 }
 
-func (r Report) ServeHTTP(rw http.ResponseWriter, _ *http.Request) {
+// ServeAuthor handles GET /report/{author}, answering with the counters of
+// that author alone.
+func (r *Report) ServeAuthor(rw http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	list, ok := r.entries[req.PathValue("author")]
+	body, err := json.Marshal(list)
+	r.mu.Unlock()
+	if !ok {
+		http.NotFound(rw, req)
+		return
+	}
+	if err != nil {
+		http.Error(rw, "failed to encode report", http.StatusInternalServerError)
+		return
+	}
+
 	rw.Header().Set("content-type", "application/json")
 	rw.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(rw).Encode(r)
+	_, _ = rw.Write(append(body, '\n'))
+}
+
+// Load replaces the report with the one saved at path, trimmed to maxLen.
+// A missing file leaves the report as it is; so does an unreadable one, whose
+// error is returned.
+func (r *Report) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var entries map[string][]string
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	if entries == nil {
+		entries = make(map[string][]string)
+	}
+	for author, list := range entries {
+		if len(list) > r.maxLen {
+			entries[author] = list[len(list)-r.maxLen:]
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries, r.dirty = entries, false
+	return nil
+}
+
+// Save writes the report to path if it changed since the last save. The file
+// is replaced by a rename, so a crash leaves either the old or the new report.
+func (r *Report) Save(path string) error {
+	r.mu.Lock()
+	if !r.dirty {
+		r.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(r.entries)
+	r.dirty = false
+	r.mu.Unlock()
+	if err == nil {
+		err = writeFileAtomic(path, data)
+	}
+	if err != nil {
+		r.mu.Lock()
+		r.dirty = true
+		r.mu.Unlock()
+	}
+	return err
+}
+
+// Persist saves the report to path every interval until ctx is done.
+func (r *Report) Persist(ctx context.Context, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Save(path); err != nil {
+				slog.Warn("Failed to save report", "path", path, "error", err)
+			}
+		}
+	}
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
 }
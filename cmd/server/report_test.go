@@ -5,54 +5,176 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestReportProcess_NoAuthor(t *testing.T) {
-	r := make(Report)
+	r := NewReport(reportMaxLen)
 	req := httptest.NewRequest("GET", "/", nil)
 	r.Process(req)
-	assert.Empty(t, r, "nothing should be added without lb-author header")
+	assert.Empty(t, r.entries, "nothing should be added without lb-author header")
 }
 
 func TestReportProcess_AddAndTrim(t *testing.T) {
-	r := make(Report)
+	r := NewReport(reportMaxLen)
 	req := httptest.NewRequest("GET", "/", nil)
 	req.Header.Set("lb-author", "usr")
 	req.Header.Set("lb-req-cnt", "1")
 
 	r.Process(req)
-	assert.Equal(t, []string{"1"}, r["usr"], "first entry should be added")
+	assert.Equal(t, []string{"1"}, r.entries["usr"], "first entry should be added")
 
 	req.Header.Set("lb-req-cnt", "2")
 	r.Process(req)
-	assert.Equal(t, []string{"1", "2"}, r["usr"], "second entry should be appended")
+	assert.Equal(t, []string{"1", "2"}, r.entries["usr"], "second entry should be appended")
 
 	for i := 3; i <= reportMaxLen+5; i++ {
 		req.Header.Set("lb-req-cnt", fmt.Sprintf("%d", i))
 		r.Process(req)
 	}
-	list := r["usr"]
+	list := r.entries["usr"]
 	assert.Len(t, list, reportMaxLen, "list length should not exceed reportMaxLen")
 	assert.Equal(t, fmt.Sprintf("%d", reportMaxLen+5), list[len(list)-1], "last element should be the last added")
 }
 
 func TestReportServeHTTP(t *testing.T) {
-	orig := Report{
+	orig := map[string][]string{
 		"alice": {"1", "2"},
 		"bob":   {"x"},
 	}
+	r := NewReport(reportMaxLen)
+	r.entries = orig
 	rr := httptest.NewRecorder()
 
-	orig.ServeHTTP(rr, nil)
+	r.ServeHTTP(rr, nil)
 
 	assert.Equal(t, http.StatusOK, rr.Code)
 	assert.Equal(t, "application/json", rr.Header().Get("content-type"))
 
-	var got Report
+	var got map[string][]string
 	err := json.Unmarshal(rr.Body.Bytes(), &got)
 	assert.NoError(t, err)
 	assert.Equal(t, orig, got, "JSON response should exactly match the report")
-}
\ No newline at end of file
+}
+func TestReport_ConfiguredMaxLen(t *testing.T) {
+	r := NewReport(3)
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("lb-author", "usr")
+	for i := 1; i <= 5; i++ {
+		req.Header.Set("lb-req-cnt", fmt.Sprintf("%d", i))
+		r.Process(req)
+	}
+	assert.Equal(t, []string{"3", "4", "5"}, r.entries["usr"])
+}
+
+func TestReport_ConcurrentProcessAndServe(t *testing.T) {
+	r := NewReport(10)
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				req := httptest.NewRequest("GET", "/", nil)
+				req.Header.Set("lb-author", fmt.Sprintf("usr%d", i%3))
+				req.Header.Set("lb-req-cnt", fmt.Sprintf("%d", i))
+				r.Process(req)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				rr := httptest.NewRecorder()
+				r.ServeHTTP(rr, nil)
+				var got map[string][]string
+				assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, r.entries, 3)
+}
+
+func TestReport_Track(t *testing.T) {
+	r := NewReport(reportMaxLen)
+	var served int
+	handler := r.Track(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { served++ }))
+
+	for i := 1; i <= 3; i++ {
+		req := httptest.NewRequest("GET", "/api/v1/some-data", nil)
+		req.Header.Set("lb-author", "lb1")
+		req.Header.Set("lb-req-cnt", fmt.Sprintf("%d", i))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	assert.Equal(t, 3, served)
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, nil)
+	assert.JSONEq(t, `{"lb1": ["1", "2", "3"]}`, rr.Body.String())
+}
+
+func TestReport_ResetAndAuthor(t *testing.T) {
+	r := NewReport(reportMaxLen)
+	for _, author := range []string{"lb1", "lb2"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("lb-author", author)
+		req.Header.Set("lb-req-cnt", "1")
+		r.Process(req)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /report", r)
+	mux.HandleFunc("DELETE /report", r.ServeReset)
+	mux.HandleFunc("GET /report/{author}", r.ServeAuthor)
+	do := func(method, target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(method, target, nil))
+		return rr
+	}
+
+	rr := do("GET", "/report/lb1")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `["1"]`, rr.Body.String())
+
+	assert.Equal(t, http.StatusNotFound, do("GET", "/report/unknown").Code)
+
+	assert.Equal(t, http.StatusNoContent, do("DELETE", "/report").Code)
+	rr = do("GET", "/report")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{}`, rr.Body.String(), "the report should be empty after a reset")
+	assert.Equal(t, http.StatusNotFound, do("GET", "/report/lb1").Code)
+}
+
+func TestReport_SaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	r := NewReport(reportMaxLen)
+	for i := 1; i <= 3; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("lb-author", "lb1")
+		req.Header.Set("lb-req-cnt", fmt.Sprint(i))
+		r.Process(req)
+	}
+	require.NoError(t, r.Save(path))
+
+	restored := NewReport(2)
+	require.NoError(t, restored.Load(path))
+	assert.Equal(t, map[string][]string{"lb1": {"2", "3"}}, restored.entries,
+		"the saved report should be restored and trimmed to maxLen")
+}
+
+func TestReport_LoadMissingOrCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	r := NewReport(reportMaxLen)
+	assert.NoError(t, r.Load(filepath.Join(dir, "missing.json")), "a missing file should start an empty report")
+	assert.Empty(t, r.entries)
+
+	corrupt := filepath.Join(dir, "corrupt.json")
+	require.NoError(t, os.WriteFile(corrupt, []byte("{not json"), 0o644))
+	assert.Error(t, r.Load(corrupt))
+	assert.Empty(t, r.entries, "a corrupt file should leave the report empty")
+}
@@ -1,77 +1,131 @@
 package main
 
 import (
-	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
-	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/maxnetyaga/architecture-practice-5/httptools"
+	"github.com/maxnetyaga/architecture-practice-5/logging"
 	"github.com/maxnetyaga/architecture-practice-5/signal"
+	"github.com/maxnetyaga/architecture-practice-5/tracing"
 )
 
-var port = flag.Int("port", 8080, "server port")
+var (
+	port          = flag.Int("port", 8080, "server port")
+	readyCacheTTL = flag.Duration("ready-cache-ttl", time.Second, "how long /ready reuses the result of a DB ping")
+	reportLen     = flag.Int("report-max-len", reportMaxLen, "request counters kept per author in /report")
+	reportFile    = flag.String("report-file", "", "file /report is saved to and restored from across restarts (empty keeps it in memory only)")
+	reportFlush   = flag.Duration("report-flush-interval", 10*time.Second, "how often the report is saved to -report-file")
+	logFormat     = flag.String("log-format", logging.FormatText, "log output format: 'text' or 'json'")
+	logLevel      = flag.String("log-level", "info", "lowest level logged: 'debug', 'info', 'warn' or 'error'")
+	gzipThreshold = flag.Int("gzip-threshold", 1024,
+		"compress responses larger than this many bytes for gzip-accepting clients (0 disables compression)")
+)
 
 const (
 	confResponseDelaySec = "CONF_RESPONSE_DELAY_SEC"
 	confHealthFailure    = "CONF_HEALTH_FAILURE"
+	confHealthStatus     = "CONF_HEALTH_FAILURE_STATUS"
+	confHealthBody       = "CONF_HEALTH_FAILURE_BODY"
+	confHealthDelayMs    = "CONF_HEALTH_DELAY_MS"
 	envTeamName          = "TEAM_NAME"
 	envDbAddr            = "DB_ADDR"
 )
 
 func main() {
 	flag.Parse()
+	if err := logging.Setup(os.Stderr, *logFormat, *logLevel); err != nil {
+		logging.Fatal("Invalid logging flags", "error", err)
+	}
 
 	team := os.Getenv(envTeamName)
 	if team == "" {
-		log.Fatal("Environment variable TEAM_NAME is required")
+		logging.Fatal("Environment variable TEAM_NAME is required")
 	}
 	dbAddr := os.Getenv(envDbAddr)
 	if dbAddr == "" {
 		dbAddr = "db:8083"
 	}
 
-	dateStr := time.Now().Format("2006-01-02")
-	payload, _ := json.Marshal(map[string]string{"value": dateStr})
-
-	resp, err := http.Post(
-		fmt.Sprintf("http://%s/db/%s", dbAddr, url.PathEscape(team)),
-		"application/json",
-		bytes.NewReader(payload),
-	)
+	shutdownTracing, err := tracing.Setup(context.Background(), "server")
 	if err != nil {
-		log.Fatalf("DB init failed (request): %v", err)
+		logging.Fatal("Tracing setup failed", "error", err)
 	}
-	defer resp.Body.Close()
+	defer shutdownTracing(context.Background())
 
-	if resp.StatusCode != http.StatusNoContent {
-		body, _ := io.ReadAll(resp.Body)
-		log.Fatalf("DB init failed (status %d): %s", resp.StatusCode, string(body))
+	dbClient.Timeout = *dbTimeout
+	if err := initDb(dbAddr, team); err != nil {
+		logging.Fatal("DB init failed", "error", err)
 	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/health", healthHandler)
-	mux.HandleFunc("/api/v1/some-data", someDataHandler(dbAddr))
-	mux.Handle("/report", make(Report))
+	mux.Handle("/ready", newReadiness(dbAddr, *readyCacheTTL))
+	report := NewReport(*reportLen)
+	if *reportFile != "" {
+		if err := report.Load(*reportFile); err != nil {
+			slog.Warn("Failed to load report, starting empty", "path", *reportFile, "error", err)
+		}
+		ctx, stopPersist := context.WithCancel(context.Background())
+		defer stopPersist()
+		go report.Persist(ctx, *reportFile, *reportFlush)
+	}
+	mux.Handle("/api/v1/some-data", report.Track(tracing.Middleware("some-data", someDataHandler(dbAddr))))
+	mux.Handle("POST /api/v1/some-data", tracing.Middleware("put some-data", putDataHandler(dbAddr)))
+	mux.Handle("GET /report", report)
+	mux.HandleFunc("DELETE /report", report.ServeReset)
+	mux.HandleFunc("GET /report/{author}", report.ServeAuthor)
 
 	server := httptools.CreateServer(*port, mux)
 	server.Start()
 	signal.WaitForTerminationSignal()
+
+	if *reportFile != "" {
+		if err := report.Save(*reportFile); err != nil {
+			slog.Error("Failed to save report", "path", *reportFile, "error", err)
+		}
+	}
 }
 
+// healthHandler reports liveness according to CONF_HEALTH_FAILURE:
+// "true" fails with CONF_HEALTH_FAILURE_STATUS (default 500) and
+// CONF_HEALTH_FAILURE_BODY (default "FAILURE"), "degraded" answers 200 with a
+// Warning header, anything else is healthy. CONF_HEALTH_DELAY_MS delays every
+// answer to simulate a slow backend.
 func healthHandler(rw http.ResponseWriter, r *http.Request) {
+	if ms, err := strconv.Atoi(os.Getenv(confHealthDelayMs)); err == nil && ms > 0 {
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+	}
+
 	rw.Header().Set("content-type", "text/plain")
-	if os.Getenv(confHealthFailure) == "true" {
-		rw.WriteHeader(http.StatusInternalServerError)
-		rw.Write([]byte("FAILURE"))
-	} else {
+	switch os.Getenv(confHealthFailure) {
+	case "true":
+		status := http.StatusInternalServerError
+		if code, err := strconv.Atoi(os.Getenv(confHealthStatus)); err == nil && code >= 100 && code <= 599 {
+			status = code
+		}
+		body := "FAILURE"
+		if b := os.Getenv(confHealthBody); b != "" {
+			body = b
+		}
+		rw.WriteHeader(status)
+		rw.Write([]byte(body))
+	case "degraded":
+		rw.Header().Set("Warning", `199 - "degraded"`)
+		rw.WriteHeader(http.StatusOK)
+		rw.Write([]byte("DEGRADED"))
+	default:
 		rw.WriteHeader(http.StatusOK)
 		rw.Write([]byte("OK"))
 	}
@@ -90,8 +144,9 @@ func someDataHandler(dbAddr string) http.HandlerFunc {
 			http.Error(rw, "key required", http.StatusBadRequest)
 			return
 		}
+		tracing.Key(r.Context(), key)
 
-		dbResp, err := http.Get("http://" + dbAddr + "/db/" + url.PathEscape(key))
+		dbResp, err := dbRequest(r.Context(), "GET", "http://"+dbAddr+"/db/"+url.PathEscape(key), nil, *dbRetries)
 		if err != nil {
 			http.Error(rw, "error fetching data", http.StatusInternalServerError)
 			return
@@ -104,7 +159,108 @@ func someDataHandler(dbAddr string) http.HandlerFunc {
 		}
 
 		rw.Header().Set("Content-Type", "application/json")
-		rw.WriteHeader(http.StatusOK)
+		writeMaybeCompressed(rw, r, dbResp.Body)
+	}
+}
+
+// maxPutBody caps the size of a body accepted by putDataHandler.
+const maxPutBody = 1 << 20
+
+// putDataHandler stores the {"value": ...} body under key by forwarding it to
+// the DB as it is, and answers with the DB's status.
+func putDataHandler(dbAddr string) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		key := r.URL.Query().Get("key")
+		if key == "" {
+			http.Error(rw, "key required", http.StatusBadRequest)
+			return
+		}
+		tracing.Key(r.Context(), key)
+		body, err := io.ReadAll(http.MaxBytesReader(rw, r.Body, maxPutBody))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(rw, "body too large", http.StatusRequestEntityTooLarge)
+			return
+		} else if err != nil {
+			http.Error(rw, "failed to read body", http.StatusBadRequest)
+			return
+		}
+		var value struct {
+			Value *string `json:"value"`
+		}
+		if err := json.Unmarshal(body, &value); err != nil || value.Value == nil {
+			http.Error(rw, `body must be {"value": "..."}`, http.StatusBadRequest)
+			return
+		}
+
+		dbResp, err := dbRequest(r.Context(), "POST", "http://"+dbAddr+"/db/"+url.PathEscape(key), body, *dbRetries)
+		if err != nil {
+			http.Error(rw, "error storing data", http.StatusInternalServerError)
+			return
+		}
+		defer dbResp.Body.Close()
+		rw.WriteHeader(dbResp.StatusCode)
 		io.Copy(rw, dbResp.Body)
 	}
 }
+
+func writeMaybeCompressed(rw http.ResponseWriter, r *http.Request, body io.Reader) {
+	if *gzipThreshold <= 0 {
+		rw.WriteHeader(http.StatusOK)
+		io.Copy(rw, body)
+		return
+	}
+
+	head, err := io.ReadAll(io.LimitReader(body, int64(*gzipThreshold)+1))
+	if err != nil || len(head) <= *gzipThreshold {
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(head)
+		return
+	}
+
+	rw.Header().Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(head)
+		io.Copy(rw, body)
+		return
+	}
+
+	rw.Header().Set("Content-Encoding", "gzip")
+	rw.Header().Del("Content-Length")
+	rw.WriteHeader(http.StatusOK)
+	gz := gzip.NewWriter(rw)
+	defer gz.Close()
+	gz.Write(head)
+	io.Copy(gz, body)
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows a gzip
+// response: gzip, or * if gzip isn't listed, must have a non-zero q-value.
+func acceptsGzip(header string) bool {
+	gzipQ, anyQ := -1.0, -1.0
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			name, value, ok := strings.Cut(param, "=")
+			if !ok || !strings.EqualFold(strings.TrimSpace(name), "q") {
+				continue
+			}
+			var err error
+			if q, err = strconv.ParseFloat(strings.TrimSpace(value), 64); err != nil {
+				q = 0
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(coding)) {
+		case "gzip", "x-gzip":
+			gzipQ = q
+		case "*":
+			anyQ = q
+		}
+	}
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+	return anyQ > 0
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newDbStub(t *testing.T, value string) string {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"key": "k", "value": value})
+	}))
+	t.Cleanup(ts.Close)
+	return strings.TrimPrefix(ts.URL, "http://")
+}
+
+func TestSomeDataHandler_GzipLargeResponse(t *testing.T) {
+	value := strings.Repeat("a", 4096)
+	handler := someDataHandler(newDbStub(t, value))
+
+	req := httptest.NewRequest("GET", "/api/v1/some-data?key=k", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "gzip", rr.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", rr.Header().Get("Vary"))
+
+	gz, err := gzip.NewReader(rr.Body)
+	require.NoError(t, err)
+	raw, err := io.ReadAll(gz)
+	require.NoError(t, err)
+
+	var body map[string]string
+	require.NoError(t, json.Unmarshal(raw, &body))
+	assert.Equal(t, value, body["value"])
+}
+
+func TestSomeDataHandler_NoGzipWithoutAcceptEncoding(t *testing.T) {
+	value := strings.Repeat("a", 4096)
+	handler := someDataHandler(newDbStub(t, value))
+
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("GET", "/api/v1/some-data?key=k", nil))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get("Content-Encoding"))
+
+	var body map[string]string
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+	assert.Equal(t, value, body["value"])
+}
+
+func TestSomeDataHandler_NoGzipForSmallResponse(t *testing.T) {
+	handler := someDataHandler(newDbStub(t, "small"))
+
+	req := httptest.NewRequest("GET", "/api/v1/some-data?key=k", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get("Content-Encoding"), "small responses should not be compressed")
+	assert.Contains(t, rr.Body.String(), `"small"`)
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	cases := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"deflate, gzip", true},
+		{"GZIP;q=0.5", true},
+		{"x-gzip", true},
+		{"gzip;q=0", false},
+		{"gzip; q=0.000, deflate", false},
+		{"gzip;q=bogus", false},
+		{"*", true},
+		{"*;q=0", false},
+		{"gzip;q=0, *", false},
+		{"gzip, *;q=0", true},
+		{"deflate, br", false},
+		{"gzipx", false},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.want, acceptsGzip(tc.header), "Accept-Encoding: %q", tc.header)
+	}
+}
+
+func TestSomeDataHandler_NoGzipWhenRefused(t *testing.T) {
+	handler := someDataHandler(newDbStub(t, strings.Repeat("a", 4096)))
+
+	req := httptest.NewRequest("GET", "/api/v1/some-data?key=k", nil)
+	req.Header.Set("Accept-Encoding", "gzip;q=0, identity")
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get("Content-Encoding"), "gzip;q=0 refuses gzip")
+}
+
+func TestHealthHandler_Modes(t *testing.T) {
+	cases := []struct {
+		name    string
+		env     map[string]string
+		status  int
+		body    string
+		warning bool
+	}{
+		{"healthy", map[string]string{confHealthFailure: "false"}, http.StatusOK, "OK", false},
+		{"default failure", map[string]string{confHealthFailure: "true"}, http.StatusInternalServerError, "FAILURE", false},
+		{"custom failure", map[string]string{
+			confHealthFailure: "true",
+			confHealthStatus:  "503",
+			confHealthBody:    "maintenance",
+		}, http.StatusServiceUnavailable, "maintenance", false},
+		{"invalid status", map[string]string{
+			confHealthFailure: "true",
+			confHealthStatus:  "abc",
+		}, http.StatusInternalServerError, "FAILURE", false},
+		{"degraded", map[string]string{confHealthFailure: "degraded"}, http.StatusOK, "DEGRADED", true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+			rr := httptest.NewRecorder()
+			healthHandler(rr, httptest.NewRequest("GET", "/health", nil))
+
+			assert.Equal(t, tc.status, rr.Code)
+			assert.Equal(t, tc.body, rr.Body.String())
+			assert.Equal(t, tc.warning, rr.Header().Get("Warning") != "")
+		})
+	}
+}
+
+func TestHealthHandler_Delay(t *testing.T) {
+	t.Setenv(confHealthDelayMs, "50")
+
+	start := time.Now()
+	rr := httptest.NewRecorder()
+	healthHandler(rr, httptest.NewRequest("GET", "/health", nil))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond, "health answer should be delayed")
+}
+
+func TestPutDataHandler_ForwardsBody(t *testing.T) {
+	var gotPath, gotBody string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		body, _ := io.ReadAll(r.Body)
+		gotPath, gotBody = r.URL.EscapedPath(), string(body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+	handler := putDataHandler(strings.TrimPrefix(ts.URL, "http://"))
+
+	body := `{"value": "hello \"world\""}`
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("POST", "/api/v1/some-data?key=a%2Fb", strings.NewReader(body)))
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, "/db/a%2Fb", gotPath)
+	assert.Equal(t, body, gotBody, "the body should be forwarded verbatim")
+}
+
+func TestPutDataHandler_Validates(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+	handler := putDataHandler(strings.TrimPrefix(ts.URL, "http://"))
+
+	for _, tc := range []struct {
+		target, body string
+		code         int
+	}{
+		{"/api/v1/some-data", `{"value": "v"}`, http.StatusBadRequest},
+		{"/api/v1/some-data?key=k", `not json`, http.StatusBadRequest},
+		{"/api/v1/some-data?key=k", `{"other": "v"}`, http.StatusBadRequest},
+		{"/api/v1/some-data?key=k", `{"value": "` + strings.Repeat("a", maxPutBody) + `"}`, http.StatusRequestEntityTooLarge},
+	} {
+		rr := httptest.NewRecorder()
+		handler(rr, httptest.NewRequest("POST", tc.target, strings.NewReader(tc.body)))
+		assert.Equal(t, tc.code, rr.Code, "%s %.20s", tc.target, tc.body)
+	}
+	assert.Zero(t, calls, "invalid requests should not reach the DB")
+}
+
+func TestPutDataHandler_PassesDbStatus(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "failed to store value", http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	rr := httptest.NewRecorder()
+	putDataHandler(strings.TrimPrefix(ts.URL, "http://"))(rr,
+		httptest.NewRequest("POST", "/api/v1/some-data?key=k", strings.NewReader(`{"value": "v"}`)))
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.Contains(t, rr.Body.String(), "failed to store value")
+}
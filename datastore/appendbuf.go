@@ -0,0 +1,91 @@
+package datastore
+
+import (
+	"log"
+	"time"
+)
+
+const defaultAppendFlushInterval = 100 * time.Millisecond
+
+// appendOut writes p, a run of whole records, to the current-data file, or
+// to the append buffer if there is one. The write lock, or the read lock and
+// appendMu, must be held.
+func (db *Db) appendOut(p []byte) error {
+	if db.appendBufSize == 0 {
+		_, err := db.out.Write(p)
+		return err
+	}
+	if len(db.appendBuf)+len(p) > db.appendBufSize {
+		if err := db.flushAppendsLocked(); err != nil {
+			return err
+		}
+		if len(p) >= db.appendBufSize {
+			_, err := db.out.Write(p)
+			return err
+		}
+	}
+	db.appendBuf = append(db.appendBuf, p...)
+	return nil
+}
+
+// flushAppendsLocked writes the append buffer to the current-data file. The
+// write lock, or the read lock and appendMu, must be held.
+func (db *Db) flushAppendsLocked() error {
+	if len(db.appendBuf) == 0 {
+		return nil
+	}
+	n, err := db.out.Write(db.appendBuf)
+	db.appendBuf = db.appendBuf[:copy(db.appendBuf, db.appendBuf[n:])]
+	return err
+}
+
+// flushAppends is flushAppendsLocked for a holder of the read lock.
+func (db *Db) flushAppends() error {
+	if db.appendBufSize == 0 {
+		return nil
+	}
+	db.appendMu.Lock()
+	defer db.appendMu.Unlock()
+	return db.flushAppendsLocked()
+}
+
+// flushFor makes sure the record at offset in the current-data file has left
+// the append buffer before it is read. db.mu must be held, but neither
+// appendMu nor a shard lock.
+func (db *Db) flushFor(offset int64) error {
+	if db.appendBufSize == 0 {
+		return nil
+	}
+	db.appendMu.Lock()
+	defer db.appendMu.Unlock()
+	if offset < db.outOffset-int64(len(db.appendBuf)) {
+		return nil
+	}
+	return db.flushAppendsLocked()
+}
+
+// Flush writes the records held by AppendBuffer to the current-data file,
+// without syncing it; Sync does both.
+func (db *Db) Flush() error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.flushAppends()
+}
+
+func (db *Db) flushPeriodically(interval time.Duration) {
+	defer db.bg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := db.Flush(); err != nil {
+				log.Printf("datastore: periodic flush failed: %s", err)
+			}
+		case <-db.done:
+			return
+		}
+	}
+}
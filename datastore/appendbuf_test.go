@@ -0,0 +1,117 @@
+package datastore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAppendBuffer(t *testing.T) {
+	tmp := t.TempDir()
+	db, err := OpenWithOptions(tmp, Options{AppendBuffer: 1 << 20, AppendFlushInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		if err := db.Put(fmt.Sprintf("k%d", i), fmt.Sprintf("v%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(tmp, outFileName)
+	if info, err := os.Stat(path); err != nil || info.Size() != 0 {
+		t.Fatalf("Expected Puts to stay buffered, got %v, %v", info, err)
+	}
+
+	if value, err := db.Get("k3"); err != nil || value != "v3" {
+		t.Errorf("Get(k3) = %q, %v", value, err)
+	}
+	values, err := db.GetMulti([]string{"k1", "k9"})
+	if err != nil || values["k1"] != "v1" || values["k9"] != "v9" {
+		t.Errorf("GetMulti = %v, %v", values, err)
+	}
+	it, err := db.Scan("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for it.Next() {
+		if _, err := it.Value(); err != nil {
+			t.Error(err)
+		}
+		n++
+	}
+	it.Close()
+	if n != 10 {
+		t.Errorf("Scan found %d keys, expected 10", n)
+	}
+
+	if err := db.Put("last", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	db.mu.RLock()
+	outOffset := db.outOffset
+	db.mu.RUnlock()
+	if info, err := os.Stat(path); err != nil || info.Size() != outOffset {
+		t.Errorf("Expected Flush to write everything out, got %v, %v", info, err)
+	}
+
+	if err := db.Put("unflushed", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = OpenWithOptions(tmp, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if value, err := db.Get("unflushed"); err != nil || value != "value" {
+		t.Errorf("Expected Close to flush the buffer, Get(unflushed) = %q, %v", value, err)
+	}
+}
+
+func TestAppendBufferRollover(t *testing.T) {
+	db, err := OpenWithOptions(t.TempDir(), Options{SegmentSize: 100, AppendBuffer: 64, DisableAutoMerge: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 50; i++ {
+		if err := db.Put(fmt.Sprintf("k%d", i), fmt.Sprintf("v%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("k%d", i)
+		if value, err := db.Get(key); err != nil || value != fmt.Sprintf("v%d", i) {
+			t.Errorf("Get(%s) = %q, %v", key, value, err)
+		}
+	}
+}
+
+func BenchmarkPutAppendBuffer(b *testing.B) {
+	for _, size := range []int{0, 64 << 10} {
+		b.Run(fmt.Sprintf("buffer=%d", size), func(b *testing.B) {
+			db, err := OpenWithOptions(b.TempDir(), Options{SegmentSize: 64 << 20, AppendBuffer: size})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer db.Close()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := db.Put(fmt.Sprintf("key%d", i%1024), "value"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package datastore
+
+import (
+	"fmt"
+	"sort"
+)
+
+// BatchError reports a PutBatch that failed part way. The keys in Stored
+// were written and indexed before the failure; the rest of the batch was not.
+type BatchError struct {
+	Stored []string
+	Err    error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("batch write failed after storing %d keys: %s", len(e.Stored), e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// PutBatch stores all pairs under a single lock acquisition, writing the
+// records that fit in the current segment with one write call. A batch that
+// crosses the segment size is split at the rollover. The batch is not atomic:
+// if a write fails, PutBatch returns a *BatchError listing the keys stored
+// before the failure, which stay durable.
+func (db *Db) PutBatch(pairs map[string]string) error {
+	_, _, err := db.PutBatchN(pairs)
+	return err
+}
+
+// PutBatchN is PutBatch that also returns the number of bytes and records it
+// wrote, including those written before a *BatchError.
+func (db *Db) PutBatchN(pairs map[string]string) (int, int, error) {
+	if db.readOnly {
+		return 0, 0, ErrReadOnly
+	}
+	keys := make([]string, 0, len(pairs))
+	for key, value := range pairs {
+		if err := db.limits.check(key, value); err != nil {
+			return 0, 0, err
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return 0, 0, nil
+	}
+	sort.Strings(keys)
+
+	records := make([]entry, len(keys))
+	for i, key := range keys {
+		e, err := db.compress(entry{key: key, value: pairs[key]})
+		if err != nil {
+			return 0, 0, err
+		}
+		records[i] = e
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var (
+		stored []string
+		n      int
+	)
+	write := db.writeRaw
+	overhead := 0
+	if db.wal != nil {
+		write, overhead = db.writeChunk, walMarkerMaxSize
+	}
+	err := db.writeChunked(records, overhead, func(chunk []byte, written []walRecord) error {
+		if err := write(chunk, written); err != nil {
+			return err
+		}
+		n += len(chunk)
+		for _, r := range written {
+			stored = append(stored, r.e.key)
+		}
+		return nil
+	})
+	if err != nil {
+		return n, len(stored), &BatchError{Stored: stored, Err: err}
+	}
+	return n, len(stored), nil
+}
+
+// writeChunked encodes records into chunks that each fit in the current
+// segment, reserving overhead bytes per chunk, and hands every chunk to
+// write. The current file is sealed between chunks. The write lock must be held.
+func (db *Db) writeChunked(records []entry, overhead int, write func(chunk []byte, written []walRecord) error) error {
+	var (
+		chunk   []byte
+		written []walRecord
+	)
+	for _, e := range records {
+		encoded := e.Encode()
+		next := db.outOffset + int64(len(chunk))
+		if db.segmentSize > 0 && next > 0 && next+int64(len(encoded)+overhead) > db.segmentSize {
+			if len(chunk) > 0 {
+				if err := write(chunk, written); err != nil {
+					return err
+				}
+				chunk, written = nil, nil
+			}
+			if err := db.createNewSegment(); err != nil {
+				return err
+			}
+		}
+		written = append(written, walRecord{e: e, offset: db.outOffset + int64(len(chunk))})
+		chunk = append(chunk, encoded...)
+	}
+	if len(chunk) == 0 {
+		return nil
+	}
+	return write(chunk, written)
+}
+
+// writeRaw appends chunk to the current-data file and indexes its records.
+func (db *Db) writeRaw(chunk []byte, written []walRecord) error {
+	if err := db.appendOut(chunk); err != nil {
+		return err
+	}
+	for _, r := range written {
+		db.applyEntry(r.e, r.offset)
+	}
+	db.outOffset += int64(len(chunk))
+	return db.wrote()
+}
@@ -0,0 +1,85 @@
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestPutBatch(t *testing.T) {
+	for _, opts := range []Options{
+		{SegmentSize: 100, DisableAutoMerge: true},
+		{SegmentSize: 100, DisableAutoMerge: true, WriteBuffer: 1024},
+	} {
+		t.Run(fmt.Sprintf("WriteBuffer=%d", opts.WriteBuffer), func(t *testing.T) {
+			tmp := t.TempDir()
+			db, err := OpenWithOptions(tmp, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { _ = db.Close() })
+
+			pairs := make(map[string]string)
+			size := 0
+			for i := 0; i < 20; i++ {
+				e := entry{key: fmt.Sprintf("key%02d", i), value: fmt.Sprintf("value%02d", i)}
+				pairs[e.key] = e.value
+				size += len(e.Encode())
+			}
+			n, records, err := db.PutBatchN(pairs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != size || records != len(pairs) {
+				t.Errorf("PutBatchN wrote %d bytes in %d records, expected %d in %d", n, records, size, len(pairs))
+			}
+			if n := countSegments(t, tmp); n == 0 {
+				t.Error("Batch larger than the segment size did not roll over")
+			}
+
+			check := func(stage string) {
+				t.Helper()
+				for key, expected := range pairs {
+					if value, err := db.Get(key); err != nil || value != expected {
+						t.Errorf("%s: Get(%q) = %q, %v; wanted %q", stage, key, value, err, expected)
+					}
+				}
+			}
+			check("after batch")
+
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+			db, err = OpenWithOptions(tmp, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			check("after reopen")
+		})
+	}
+}
+
+func TestPutBatchErrors(t *testing.T) {
+	db, err := Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	if err := db.PutBatch(map[string]string{"k": "v", walMarkerKey: "v"}); err != ErrReservedKey {
+		t.Errorf("PutBatch with a reserved key returned %v", err)
+	}
+	if _, err := db.Get("k"); err != ErrNotFound {
+		t.Error("Batch with a reserved key should not be written at all")
+	}
+
+	db.out.Close()
+	err = db.PutBatch(map[string]string{"k": "v"})
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("Expected a *BatchError, got %v", err)
+	}
+	if len(batchErr.Stored) != 0 {
+		t.Errorf("Failed batch reports stored keys %v", batchErr.Stored)
+	}
+}
@@ -0,0 +1,155 @@
+package datastore
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"hash/fnv"
+	"os"
+	"strings"
+)
+
+const (
+	bloomBitsPerKey = 10
+	bloomHashes     = 7
+)
+
+// bloomFilter answers whether a sealed segment may hold a key. With ten bits
+// per key and seven hashes about 1% of absent keys are false positives.
+type bloomFilter struct {
+	bits []uint64
+	k    uint32
+}
+
+func newBloomFilter(keys int) *bloomFilter {
+	words := (keys*bloomBitsPerKey + 63) / 64
+	if words == 0 {
+		words = 1
+	}
+	return &bloomFilter{bits: make([]uint64, words), k: bloomHashes}
+}
+
+// positions derives the filter bits of key by double hashing.
+func (f *bloomFilter) positions(key string, fn func(bit uint64)) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32|1
+	m := uint64(len(f.bits)) * 64
+	for i := uint64(0); i < uint64(f.k); i++ {
+		fn((h1 + i*h2) % m)
+	}
+}
+
+func (f *bloomFilter) add(key string) {
+	f.positions(key, func(bit uint64) {
+		f.bits[bit/64] |= 1 << (bit % 64)
+	})
+}
+
+func (f *bloomFilter) mayContain(key string) bool {
+	found := true
+	f.positions(key, func(bit uint64) {
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			found = false
+		}
+	})
+	return found
+}
+
+// A bloom file sits next to its segment:
+//
+//	(segment size) (k) [(bits)]... (crc32)
+//	8               4   8          4
+
+func bloomPath(segmentFile string) string {
+	return strings.TrimSuffix(segmentFile, ".segment") + ".bloom"
+}
+
+// bloomOf builds the filter of the live keys in hints.
+func bloomOf(hints []hintRecord) *bloomFilter {
+	f := newBloomFilter(len(hints))
+	for _, h := range hints {
+		if !h.deleted {
+			f.add(h.key)
+		}
+	}
+	return f
+}
+
+// writeBloomFile builds the filter of the live keys in hints and stores it
+// beside segmentFile.
+func writeBloomFile(segmentFile string, hints []hintRecord) (*bloomFilter, error) {
+	info, err := os.Stat(segmentFile)
+	if err != nil {
+		return nil, err
+	}
+
+	f := bloomOf(hints)
+
+	data := binary.LittleEndian.AppendUint64(nil, uint64(info.Size()))
+	data = binary.LittleEndian.AppendUint32(data, f.k)
+	for _, word := range f.bits {
+		data = binary.LittleEndian.AppendUint64(data, word)
+	}
+	data = binary.LittleEndian.AppendUint32(data, crc32.ChecksumIEEE(data))
+
+	path := bloomPath(segmentFile)
+	tempFile := path + ".new"
+	if err := os.WriteFile(tempFile, data, 0o600); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tempFile, path); err != nil {
+		os.Remove(tempFile)
+		return nil, err
+	}
+	return f, nil
+}
+
+// readBloomFile loads the filter of segmentFile, failing if it is missing or
+// does not match the segment.
+func readBloomFile(segmentFile string) (*bloomFilter, error) {
+	data, err := os.ReadFile(bloomPath(segmentFile))
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(segmentFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 24 || (len(data)-16)%8 != 0 {
+		return nil, errBadHint
+	}
+	body, sum := data[:len(data)-4], binary.LittleEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum || int64(binary.LittleEndian.Uint64(body)) != info.Size() {
+		return nil, errBadHint
+	}
+
+	f := &bloomFilter{k: binary.LittleEndian.Uint32(body[8:])}
+	for words := body[12:]; len(words) > 0; words = words[8:] {
+		f.bits = append(f.bits, binary.LittleEndian.Uint64(words))
+	}
+	return f, nil
+}
+
+// segmentsMayHold returns a check of whether any sealed segment may hold a
+// value of a key, answered from the segments' filters without reading them.
+// A segment whose filter isn't built yet may hold any key. db.mu must be
+// held.
+func (db *Db) segmentsMayHold() func(key string) bool {
+	filters := make([]*bloomFilter, 0, len(db.segmentRecords))
+	for segmentFile := range db.segmentRecords {
+		filter := db.blooms[segmentFile]
+		if filter == nil {
+			return func(string) bool { return true }
+		}
+		filters = append(filters, filter)
+	}
+	return func(key string) bool {
+		for _, f := range filters {
+			if f.mayContain(key) {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,73 @@
+package datastore
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestBloomFilter(t *testing.T) {
+	const n = 10000
+	f := newBloomFilter(n)
+	for i := 0; i < n; i++ {
+		f.add(fmt.Sprintf("team:%d:requests", i))
+	}
+
+	for i := 0; i < n; i++ {
+		if key := fmt.Sprintf("team:%d:requests", i); !f.mayContain(key) {
+			t.Fatalf("False negative for %q", key)
+		}
+	}
+
+	falsePositives := 0
+	for i := n; i < 2*n; i++ {
+		if f.mayContain(fmt.Sprintf("team:%d:requests", i)) {
+			falsePositives++
+		}
+	}
+	rate := float64(falsePositives) / n
+	t.Logf("False positive rate: %.2f%%", rate*100)
+	if rate > 0.03 {
+		t.Errorf("False positive rate %.2f%% is too high", rate*100)
+	}
+}
+
+func TestBloomFiles(t *testing.T) {
+	tmp := t.TempDir()
+	opts := Options{DisableAutoMerge: true}
+	db, err := OpenWithOptions(tmp, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	for i := 0; i < 100; i++ {
+		if err := db.Put(fmt.Sprintf("key%d", i), "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sealed, err := db.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = OpenWithOptions(tmp, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := db.blooms[sealed]
+	if filter == nil {
+		t.Fatal("Bloom filter of the sealed segment was not loaded")
+	}
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if !filter.mayContain(key) {
+			t.Errorf("Persisted filter misses %q", key)
+		}
+		if value, err := db.Get(key); err != nil || value != "v" {
+			t.Errorf("Get(%q) = %q, %v", key, value, err)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package datastore
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// readCache is an LRU cache of values, bounded by the total size of the keys
+// and values it holds. A nil *readCache caches nothing.
+type readCache struct {
+	capacity int
+
+	mu    sync.Mutex
+	size  int
+	lru   *list.List // of *cacheEntry, most recently used first
+	items map[string]*list.Element
+	// gen is bumped by every invalidation, so a read that started before a
+	// write doesn't cache the value it overwrote.
+	gen uint64
+
+	hits, misses atomic.Int64
+}
+
+type cacheEntry struct {
+	key, value string
+}
+
+func newReadCache(capacity int) *readCache {
+	if capacity <= 0 {
+		return nil
+	}
+	return &readCache{
+		capacity: capacity,
+		lru:      list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// generation must be taken before the index lookup that locates a value
+// to be cached with add.
+func (c *readCache) generation() uint64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+func (c *readCache) get(key string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.lru.MoveToFront(el)
+		c.hits.Add(1)
+		return el.Value.(*cacheEntry).value, true
+	}
+	c.misses.Add(1)
+	return "", false
+}
+
+// add caches value unless an invalidation happened since gen was taken, or
+// it would take more than the whole capacity.
+func (c *readCache) add(key, value string, gen uint64) {
+	if c == nil {
+		return
+	}
+	size := len(key) + len(value)
+	if size > c.capacity {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.gen {
+		return
+	}
+	c.removeLocked(key)
+	c.items[key] = c.lru.PushFront(&cacheEntry{key: key, value: value})
+	c.size += size
+	for c.size > c.capacity {
+		c.removeLocked(c.lru.Back().Value.(*cacheEntry).key)
+	}
+}
+
+// invalidate drops key. It must be called after the index stops pointing
+// at the cached value.
+func (c *readCache) invalidate(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	c.removeLocked(key)
+}
+
+func (c *readCache) clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	c.lru.Init()
+	c.items = make(map[string]*list.Element)
+	c.size = 0
+}
+
+func (c *readCache) removeLocked(key string) {
+	el, ok := c.items[key]
+	if !ok {
+		return
+	}
+	e := c.lru.Remove(el).(*cacheEntry)
+	delete(c.items, key)
+	c.size -= len(e.key) + len(e.value)
+}
+
+func (c *readCache) stats() (hits, misses int64) {
+	if c == nil {
+		return 0, 0
+	}
+	return c.hits.Load(), c.misses.Load()
+}
@@ -0,0 +1,95 @@
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestReadCache(t *testing.T) {
+	db, err := OpenWithOptions(t.TempDir(), WithReadCache(1<<10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Put("k", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if value, err := db.Get("k"); err != nil || value != "v1" {
+			t.Fatalf("Get: got %q, %v, want v1", value, err)
+		}
+	}
+	if stats := db.Stats(); stats.CacheHits != 2 || stats.CacheMisses != 1 {
+		t.Errorf("got %d hits and %d misses, want 2 and 1", stats.CacheHits, stats.CacheMisses)
+	}
+
+	if err := db.Put("k", "v2"); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := db.Get("k"); err != nil || value != "v2" {
+		t.Errorf("Get after Put: got %q, %v, want v2", value, err)
+	}
+
+	if err := db.Delete("k"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Get("k"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestReadCacheAcrossMerge(t *testing.T) {
+	db, err := OpenWithOptions(t.TempDir(), WithReadCache(1<<10), WithAutoMerge(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := db.Put("k", fmt.Sprintf("v%d", i)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Get("k"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.merge(); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := db.Get("k"); err != nil || value != "v2" {
+		t.Errorf("Get after merge: got %q, %v, want v2", value, err)
+	}
+}
+
+func TestReadCacheEviction(t *testing.T) {
+	c := newReadCache(4)
+	c.add("a", "1", c.generation())
+	c.add("b", "2", c.generation())
+	c.get("a")
+	c.add("c", "3", c.generation())
+
+	if _, ok := c.get("b"); ok {
+		t.Error("the least recently used key should have been evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := c.get(key); !ok {
+			t.Errorf("%s should still be cached", key)
+		}
+	}
+
+	gen := c.generation()
+	c.invalidate("a")
+	c.add("a", "stale", gen)
+	if _, ok := c.get("a"); ok {
+		t.Error("a value read before an invalidation should not be cached")
+	}
+	c.add("too-long", "value", c.generation())
+	if _, ok := c.get("too-long"); ok {
+		t.Error("a value larger than the cache should not be cached")
+	}
+}
@@ -0,0 +1,54 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+)
+
+// CompareAndSwap stores new under key if its current value is old, or if key
+// is absent when old is empty, and reports whether it did. The check and the
+// write happen under the write lock, so they are atomic with respect to
+// every other write.
+func (db *Db) CompareAndSwap(key, old, new string) (bool, error) {
+	if err := db.limits.check(key, new); err != nil {
+		return false, err
+	}
+	if db.readOnly {
+		return false, ErrReadOnly
+	}
+	e, err := db.compress(entry{key: key, value: new})
+	if err != nil {
+		return false, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	value, err := db.getLocked(context.Background(), key)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		if old != "" {
+			return false, nil
+		}
+	case err != nil:
+		return false, err
+	case old == "" || value != old:
+		return false, nil
+	}
+
+	if db.wal != nil {
+		err = db.writeCommitted([]entry{e})
+	} else {
+		err = db.appendEntry(e)
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PutIfAbsent stores value under key unless key already exists, even with an
+// empty value, and reports whether it did.
+func (db *Db) PutIfAbsent(key, value string) (bool, error) {
+	return db.CompareAndSwap(key, "", value)
+}
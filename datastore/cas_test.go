@@ -0,0 +1,116 @@
+package datastore
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCompareAndSwap(t *testing.T) {
+	db, err := OpenWithOptions(t.TempDir(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, tc := range []struct {
+		old, new string
+		swapped  bool
+		value    string
+	}{
+		{"", "v1", true, "v1"},
+		{"", "v2", false, "v1"},
+		{"other", "v2", false, "v1"},
+		{"v1", "v2", true, "v2"},
+	} {
+		swapped, err := db.CompareAndSwap("key", tc.old, tc.new)
+		if err != nil || swapped != tc.swapped {
+			t.Errorf("CompareAndSwap(%q, %q) = %v, %v; expected %v", tc.old, tc.new, swapped, err, tc.swapped)
+		}
+		if value, err := db.Get("key"); err != nil || value != tc.value {
+			t.Errorf("After CompareAndSwap(%q, %q), Get = %q, %v; expected %q", tc.old, tc.new, value, err, tc.value)
+		}
+	}
+
+	if swapped, err := db.CompareAndSwap("absent", "v1", "v2"); err != nil || swapped {
+		t.Errorf("CompareAndSwap of an absent key with old set = %v, %v", swapped, err)
+	}
+	if _, err := db.Get("absent"); err != ErrNotFound {
+		t.Errorf("Expected the absent key to stay absent, got %v", err)
+	}
+}
+
+func TestCompareAndSwapConcurrent(t *testing.T) {
+	db, err := OpenWithOptions(t.TempDir(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	const attempts = 50
+	var (
+		wg   sync.WaitGroup
+		wins atomic.Int32
+	)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			swapped, err := db.CompareAndSwap("lock", "", fmt.Sprintf("owner%d", i))
+			if err != nil {
+				t.Error(err)
+			}
+			if swapped {
+				wins.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := wins.Load(); n != 1 {
+		t.Errorf("Expected exactly one CompareAndSwap to win, %d did", n)
+	}
+}
+
+func TestPutIfAbsent(t *testing.T) {
+	db, err := OpenWithOptions(t.TempDir(), Options{SegmentSize: 100, DisableAutoMerge: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Put("empty", ""); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := db.Put(fmt.Sprintf("filler%d", i), "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stored, err := db.PutIfAbsent("empty", "v"); err != nil || stored {
+		t.Errorf("PutIfAbsent of a key with an empty value in a segment = %v, %v", stored, err)
+	}
+
+	const attempts = 50
+	var (
+		wg     sync.WaitGroup
+		stored atomic.Int32
+	)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := db.PutIfAbsent("leader", fmt.Sprintf("node%d", i))
+			if err != nil {
+				t.Error(err)
+			}
+			if ok {
+				stored.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := stored.Load(); n != 1 {
+		t.Errorf("Expected exactly one PutIfAbsent to store the key, %d did", n)
+	}
+}
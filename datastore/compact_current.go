@@ -0,0 +1,237 @@
+package datastore
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+const compactCurrentTempFile = "current-data.compact"
+
+// CompactCurrent rewrites the current-data file without the records that
+// later writes to the same keys have shadowed, and returns the number of
+// bytes reclaimed. It is the only way to reclaim space in a store that never
+// seals segments. Like a merge, the records are copied without holding
+// db.mu; the write lock is only taken to copy what was written meanwhile and
+// swap the files. If the file is sealed during the copy, nothing is changed.
+func (db *Db) CompactCurrent() (int64, error) {
+	if db.readOnly {
+		return 0, ErrReadOnly
+	}
+	db.mergeMu.Lock()
+	defer db.mergeMu.Unlock()
+
+	snap, err := db.snapshotCurrent()
+	if err != nil {
+		return 0, err
+	}
+	defer snap.file.Close()
+	src, end := snap.file, snap.end
+
+	op, ctx := db.ops.start("compact-current")
+	defer db.ops.finish(op)
+
+	tempPath := filepath.Join(db.dir, compactCurrentTempFile)
+	temp, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if temp != nil {
+			temp.Close()
+			os.Remove(tempPath)
+		}
+	}()
+
+	// Live records keep their order, so the file can be read back as is.
+	// Tombstones go last: they only shadow values in sealed segments.
+	var (
+		records    []entry
+		offsets    []int64
+		tombstones = make(map[string]entry)
+	)
+	in := bufio.NewReader(io.NewSectionReader(src, 0, end))
+	for offset := int64(0); offset < end; {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		var record entry
+		n, err := record.DecodeFromReader(in)
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case record.key == walMarkerKey:
+		case record.deleted:
+			if snap.shadows(record.key) {
+				tombstones[record.key] = record
+			}
+		default:
+			if live, ok := snap.live[record.key]; ok && live == offset {
+				records = append(records, record)
+				offsets = append(offsets, offset)
+			}
+		}
+		offset += int64(n)
+		op.progress(offset, end)
+	}
+	for key := range snap.live {
+		delete(tombstones, key)
+	}
+	tombstoneKeys := make([]string, 0, len(tombstones))
+	for key := range tombstones {
+		tombstoneKeys = append(tombstoneKeys, key)
+	}
+	sort.Strings(tombstoneKeys)
+	for _, key := range tombstoneKeys {
+		records = append(records, tombstones[key])
+	}
+
+	out := bufio.NewWriter(temp)
+	moved := make(map[int64]int64, len(offsets))
+	var size int64
+	for i, record := range records {
+		if i < len(offsets) {
+			moved[offsets[i]] = size
+		}
+		n, err := out.Write(record.Encode())
+		if err != nil {
+			return 0, err
+		}
+		size += int64(n)
+	}
+	if db.wal != nil {
+		marker := entry{key: walMarkerKey, value: strconv.Itoa(len(records))}
+		n, err := out.Write(marker.Encode())
+		if err != nil {
+			return 0, err
+		}
+		size += int64(n)
+	}
+	if err := out.Flush(); err != nil {
+		return 0, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.flushAppendsLocked(); err != nil {
+		return 0, err
+	}
+	if sealed, err := db.sealedSince(src); sealed || err != nil {
+		return 0, err
+	}
+	tail := db.outOffset - end
+	if _, err := io.Copy(temp, io.NewSectionReader(src, end, tail)); err != nil {
+		return 0, err
+	}
+	if err := temp.Sync(); err != nil {
+		return 0, err
+	}
+	if err := temp.Close(); err != nil {
+		return 0, err
+	}
+	temp = nil
+
+	if err := os.Rename(tempPath, db.out.Name()); err != nil {
+		os.Remove(tempPath)
+		return 0, err
+	}
+	reclaimed := db.outOffset - (size + tail)
+	if err := db.reopenOut(); err != nil {
+		return 0, err
+	}
+	for _, s := range db.shards {
+		s.mu.Lock()
+		for key, offset := range s.index {
+			if offset >= end {
+				s.index[key] = offset - end + size
+			} else if newOffset, ok := moved[offset]; ok {
+				s.index[key] = newOffset
+			}
+		}
+		s.mu.Unlock()
+	}
+	db.outOffset = size + tail
+	db.outRecords = len(records) + db.outRecords - snap.records
+	db.fileGen++
+	return reclaimed, nil
+}
+
+// currentSnapshot is the state of the current-data file CompactCurrent
+// copies from.
+type currentSnapshot struct {
+	file *os.File
+	// end is the length of the file and records the number of records in
+	// it when the snapshot was taken.
+	end     int64
+	records int
+	// live holds the offsets of the keys the file holds.
+	live map[string]int64
+	// shadows reports whether a sealed segment may hold a value of key, so
+	// that a tombstone of key has to be kept.
+	shadows func(key string) bool
+}
+
+// snapshotCurrent opens the current-data file and takes its snapshot under
+// the read lock.
+func (db *Db) snapshotCurrent() (*currentSnapshot, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	db.appendMu.Lock()
+	defer db.appendMu.Unlock()
+
+	if err := db.flushAppendsLocked(); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(db.out.Name())
+	if err != nil {
+		return nil, err
+	}
+	snap := &currentSnapshot{
+		file:    f,
+		end:     db.outOffset,
+		records: db.outRecords,
+		live:    make(map[string]int64),
+		shadows: db.segmentsMayHold(),
+	}
+	for _, s := range db.shards {
+		s.mu.RLock()
+		for key, offset := range s.index {
+			snap.live[key] = offset
+		}
+		s.mu.RUnlock()
+	}
+	return snap, nil
+}
+
+// sealedSince reports whether the current-data file is no longer the one src
+// was opened on. The write lock must be held.
+func (db *Db) sealedSince(src *os.File) (bool, error) {
+	srcInfo, err := src.Stat()
+	if err != nil {
+		return false, err
+	}
+	outInfo, err := db.out.Stat()
+	if err != nil {
+		return false, err
+	}
+	return !os.SameFile(srcInfo, outInfo), nil
+}
+
+// reopenOut reopens the current-data file for appending after it was
+// replaced. The write lock must be held.
+func (db *Db) reopenOut() error {
+	f, err := os.OpenFile(db.out.Name(), os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o600)
+	if err != nil {
+		return errors.Join(err, db.out.Close())
+	}
+	db.out.Close()
+	db.out = f
+	db.readerPool.reopenCurrent()
+	return nil
+}
@@ -0,0 +1,198 @@
+package datastore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestCompactCurrent(t *testing.T) {
+	for _, opts := range []Options{{}, {WriteBuffer: 1024}, {AppendBuffer: 4096}} {
+		t.Run(fmt.Sprintf("WriteBuffer=%d,AppendBuffer=%d", opts.WriteBuffer, opts.AppendBuffer), func(t *testing.T) {
+			tmp := t.TempDir()
+			db, err := OpenWithOptions(tmp, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 2000; i++ {
+				if err := db.Put("hot", fmt.Sprintf("v%d", i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := db.Put("cold", "value"); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			path := filepath.Join(tmp, outFileName)
+			before, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reclaimed, err := db.CompactCurrent()
+			if err != nil {
+				t.Fatal(err)
+			}
+			after, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if after.Size() >= before.Size()/100 || reclaimed != before.Size()-after.Size() {
+				t.Errorf("Compaction shrank the file from %d to %d bytes, reporting %d reclaimed", before.Size(), after.Size(), reclaimed)
+			}
+
+			check := func(stage string) {
+				t.Helper()
+				for key, expected := range map[string]string{"hot": "v1999", "cold": "value"} {
+					if value, err := db.Get(key); err != nil || value != expected {
+						t.Errorf("%s: Get(%s) = %q, %v; expected %q", stage, key, value, err, expected)
+					}
+				}
+			}
+			check("after compaction")
+			if err := db.Put("new", "value"); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if db, err = OpenWithOptions(tmp, opts); err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			check("after reopen")
+			if value, err := db.Get("new"); err != nil || value != "value" {
+				t.Errorf("Get(new) = %q, %v after writing to the compacted file", value, err)
+			}
+		})
+	}
+}
+
+func TestCompactCurrentKeepsTombstones(t *testing.T) {
+	tmp := t.TempDir()
+	db, err := OpenWithOptions(tmp, Options{SegmentSize: 100, DisableAutoMerge: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		if err := db.Put(fmt.Sprintf("k%d", i), "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Delete("k0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CompactCurrent(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = OpenWithOptions(tmp, Options{SegmentSize: 100, DisableAutoMerge: true}); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Get("k0"); err != ErrNotFound {
+		t.Errorf("Get(k0) returned %v, expected the deletion to survive compaction", err)
+	}
+}
+
+func TestCompactCurrentDropsUnneededTombstones(t *testing.T) {
+	tmp := t.TempDir()
+	opts := Options{DisableAutoMerge: true}
+	db, err := OpenWithOptions(tmp, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("sealed", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	// Reopening waits for the filter of the sealed segment.
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = OpenWithOptions(tmp, opts); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	if err := db.Put("fresh", "v"); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"fresh", "sealed"} {
+		if err := db.Delete(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.CompactCurrent(); err != nil {
+		t.Fatal(err)
+	}
+
+	hints, _, err := segmentHints(filepath.Join(tmp, outFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hints) != 1 || hints[0].key != "sealed" || !hints[0].deleted {
+		t.Errorf("Expected only the tombstone of the sealed key to be kept, got %+v", hints)
+	}
+	for _, key := range []string{"fresh", "sealed"} {
+		if _, err := db.Get(key); err != ErrNotFound {
+			t.Errorf("Get(%q) returned %v, expected ErrNotFound", key, err)
+		}
+	}
+}
+
+func TestCompactCurrentConcurrent(t *testing.T) {
+	db, err := OpenWithOptions(t.TempDir(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	const keys = 8
+	var wg sync.WaitGroup
+	for w := 0; w < keys; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			key := fmt.Sprintf("key%d", w)
+			for i := 0; i < 500; i++ {
+				if err := db.Put(key, fmt.Sprintf("v%d", i)); err != nil {
+					t.Error(err)
+					return
+				}
+				if value, err := db.Get(key); err != nil || value != fmt.Sprintf("v%d", i) {
+					t.Errorf("Get(%s) = %q, %v; expected v%d", key, value, err, i)
+					return
+				}
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			if _, err := db.CompactCurrent(); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	<-done
+
+	for w := 0; w < keys; w++ {
+		key := fmt.Sprintf("key%d", w)
+		if value, err := db.Get(key); err != nil || value != "v499" {
+			t.Errorf("Get(%s) = %q, %v", key, value, err)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	compactLockFile     = "compact.lock"
+	compactTempFile     = "compact.tmp"
+	compactPendingFile  = "compact.pending"
+	compactManifestFile = "compact.manifest"
+)
+
+var ErrCompactionPending = fmt.Errorf("a compaction is already waiting to be adopted")
+
+// CompactOffline merges the sealed segments in dir without opening the store,
+// so a separate process can take the compaction I/O off the serving one. The
+// result is only staged next to the segments: the live Db adopts it on its
+// next Reindex, or the next Open if the store is closed. Live
+// stores compacted this way should be opened with DisableAutoMerge. It
+// returns the number of segments that were merged.
+func CompactOffline(dir string) (int, error) {
+	lock, err := os.OpenFile(filepath.Join(dir, compactLockFile), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return 0, fmt.Errorf("another compaction is running in %s", dir)
+		}
+		return 0, err
+	}
+	lock.Close()
+	defer os.Remove(lock.Name())
+
+	if _, err := os.Stat(filepath.Join(dir, compactManifestFile)); err == nil {
+		return 0, ErrCompactionPending
+	}
+
+	segmentFiles, err := listSegments(dir)
+	if err != nil {
+		return 0, err
+	}
+	if len(segmentFiles) < 2 {
+		return 0, nil
+	}
+
+	// Tombstones and expired records are kept: the result replaces the
+	// newest input first, and older inputs a crash leaves behind must not
+	// bring back the keys they shadow.
+	allKeys, err := latestValues(context.Background(), segmentFiles, true, func(int) {})
+	if err != nil {
+		return 0, err
+	}
+
+	tempFile := filepath.Join(dir, compactTempFile)
+	if _, err := writeSegment(tempFile, allKeys); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tempFile, filepath.Join(dir, compactPendingFile)); err != nil {
+		os.Remove(tempFile)
+		return 0, err
+	}
+
+	names := make([]string, len(segmentFiles))
+	for i, segmentFile := range segmentFiles {
+		names[i] = filepath.Base(segmentFile)
+	}
+	manifestTemp := filepath.Join(dir, compactManifestFile+".tmp")
+	if err := os.WriteFile(manifestTemp, []byte(strings.Join(names, "\n")), 0o600); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(manifestTemp, filepath.Join(dir, compactManifestFile)); err != nil {
+		os.Remove(manifestTemp)
+		return 0, err
+	}
+	return len(segmentFiles), nil
+}
+
+// Reindex adopts a compaction staged by CompactOffline, if any, and rebuilds
+// the in-memory index from the files on disk.
+func (db *Db) Reindex() error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	db.mergeMu.Lock()
+	defer db.mergeMu.Unlock()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.flushAppendsLocked(); err != nil {
+		return err
+	}
+
+	segmentFiles, err := db.segmentFiles()
+	if err != nil {
+		return err
+	}
+	for _, segmentFile := range segmentFiles {
+		db.readerPool.removeSegment(segmentFile)
+	}
+
+	adoptErr := db.adoptCompaction()
+
+	db.shards = newIndexShards()
+	db.cache.clear()
+	db.hinted = make(map[string]bool)
+	db.blooms = make(map[string]*bloomFilter)
+	db.segmentRecords = make(map[string]int)
+	db.outRecords = 0
+	db.outOffset = 0
+	db.fileGen++
+	if err := db.recover(); err != nil && err != io.EOF {
+		return err
+	}
+	if db.tags != nil {
+		db.tags = newTagIndex(db.tags.fn)
+		if err := db.rebuildTags(); err != nil {
+			return err
+		}
+	}
+	return adoptErr
+}
+
+// adoptCompaction swaps the staged compacted segment in place of its inputs.
+// The merged file takes the name of the newest input, so segments sealed
+// after the compaction started still take precedence over it. Replacing the
+// newest input first keeps the store consistent if the process dies midway:
+// the merged file keeps the tombstones and expired records of its keys, so
+// leftover older inputs only hold records it shadows. The manifest is
+// removed last, so the next Open or Reindex finishes the job.
+func (db *Db) adoptCompaction() error {
+	manifestPath := filepath.Join(db.dir, compactManifestFile)
+	manifest, err := os.ReadFile(manifestPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	names := strings.Split(string(manifest), "\n")
+	pendingPath := filepath.Join(db.dir, compactPendingFile)
+	target := filepath.Join(db.dir, names[len(names)-1])
+
+	if _, err := os.Stat(pendingPath); err == nil {
+		for _, name := range names {
+			if _, err := os.Stat(filepath.Join(db.dir, name)); err != nil {
+				os.Remove(pendingPath)
+				os.Remove(manifestPath)
+				return fmt.Errorf("discarding stale compaction: input segment %s is gone", name)
+			}
+		}
+		os.Remove(hintPath(target))
+		os.Remove(bloomPath(target))
+		if err := os.Rename(pendingPath, target); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range names[:len(names)-1] {
+		if err := os.Remove(filepath.Join(db.dir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		os.Remove(hintPath(filepath.Join(db.dir, name)))
+		os.Remove(bloomPath(filepath.Join(db.dir, name)))
+	}
+	return os.Remove(manifestPath)
+}
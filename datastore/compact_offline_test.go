@@ -0,0 +1,168 @@
+package datastore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompactOfflineAndReindex(t *testing.T) {
+	tmp := t.TempDir()
+	opts := Options{SegmentSize: 120, DisableAutoMerge: true}
+	db, err := OpenWithOptions(tmp, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	expected := make(map[string]string)
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 5; i++ {
+			key := fmt.Sprintf("key%d", i)
+			value := fmt.Sprintf("value%d-%d", i, round)
+			if err := db.Put(key, value); err != nil {
+				t.Fatal(err)
+			}
+			expected[key] = value
+		}
+	}
+
+	before := countSegments(t, tmp)
+	if before < 3 {
+		t.Fatalf("Expected several segments, got %d", before)
+	}
+
+	merged, err := CompactOffline(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged != before {
+		t.Errorf("CompactOffline merged %d segments, expected %d", merged, before)
+	}
+	if n := countSegments(t, tmp); n != before {
+		t.Errorf("Segments changed before Reindex: %d -> %d", before, n)
+	}
+	if _, err := CompactOffline(tmp); err != ErrCompactionPending {
+		t.Errorf("Second CompactOffline = %v, expected ErrCompactionPending", err)
+	}
+
+	// A write after the offline compaction must win over the compacted data.
+	if err := db.Put("key0", "fresh"); err != nil {
+		t.Fatal(err)
+	}
+	expected["key0"] = "fresh"
+
+	if err := db.Reindex(); err != nil {
+		t.Fatal(err)
+	}
+	if n := countSegments(t, tmp); n != 1 {
+		t.Errorf("Expected 1 segment after Reindex, got %d", n)
+	}
+
+	check := func(db *Db) {
+		t.Helper()
+		for key, value := range expected {
+			if got, err := db.Get(key); err != nil || got != value {
+				t.Errorf("Get(%q) = %q, %v; wanted %q", key, got, err, value)
+			}
+		}
+	}
+	check(db)
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = OpenWithOptions(tmp, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(db)
+}
+
+func TestCompactOfflineCrashMidAdopt(t *testing.T) {
+	advance := fakeClock(t)
+	tmp := t.TempDir()
+	opts := Options{DisableAutoMerge: true}
+	db, err := OpenWithOptions(tmp, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"deleted", "expiring", "kept"} {
+		if err := db.Put(key, "v1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("deleted"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutWithTTL("expiring", "v2", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	advance(time.Hour)
+
+	if merged, err := CompactOffline(tmp); err != nil || merged != 2 {
+		t.Fatalf("CompactOffline = %d, %v", merged, err)
+	}
+
+	// Stop adopting right after the merged file replaced the newest input,
+	// leaving the older input and the manifest behind.
+	manifest, err := os.ReadFile(filepath.Join(tmp, compactManifestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := strings.Split(string(manifest), "\n")
+	target := filepath.Join(tmp, names[len(names)-1])
+	if err := os.Rename(filepath.Join(tmp, compactPendingFile), target); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(hintPath(target))
+
+	check := func(stage string, db *Db) {
+		t.Helper()
+		for _, key := range []string{"deleted", "expiring"} {
+			if value, err := db.Get(key); err != ErrNotFound {
+				t.Errorf("%s: Get(%q) = %q, %v; wanted ErrNotFound", stage, key, value, err)
+			}
+		}
+		if value, err := db.Get("kept"); err != nil || value != "v1" {
+			t.Errorf("%s: Get(kept) = %q, %v", stage, value, err)
+		}
+	}
+
+	// A read-only store doesn't adopt, so it sees the leftover input.
+	ro := opts
+	ro.ReadOnly = true
+	if db, err = OpenWithOptions(tmp, ro); err != nil {
+		t.Fatal(err)
+	}
+	check("with the older input left over", db)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if db, err = OpenWithOptions(tmp, opts); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	check("after Open finished adopting", db)
+	if n := countSegments(t, tmp); n != 1 {
+		t.Errorf("Expected Open to remove the older input, %d segments left", n)
+	}
+	if _, err := os.Stat(filepath.Join(tmp, compactManifestFile)); !os.IsNotExist(err) {
+		t.Errorf("Expected Open to remove the manifest, got %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package datastore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// compress gzips the value of e if it reaches the configured threshold and
+// compression saves space.
+func (db *Db) compress(e entry) (entry, error) {
+	if db.compressAt <= 0 || e.deleted || e.compressed || len(e.value) < db.compressAt {
+		return e, nil
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := io.WriteString(zw, e.value); err != nil {
+		return e, err
+	}
+	if err := zw.Close(); err != nil {
+		return e, err
+	}
+	if buf.Len() < len(e.value) {
+		e.value = buf.String()
+		e.compressed = true
+	}
+	return e, nil
+}
+
+// payload returns the stored value, decompressing it if needed.
+func (e *entry) payload() (string, error) {
+	if !e.compressed {
+		return e.value, nil
+	}
+	zr, err := gzip.NewReader(strings.NewReader(e.value))
+	if err != nil {
+		return "", fmt.Errorf("decompressing %q: %w", e.key, err)
+	}
+	defer zr.Close()
+
+	value, err := io.ReadAll(zr)
+	if err != nil {
+		return "", fmt.Errorf("decompressing %q: %w", e.key, err)
+	}
+	return string(value), nil
+}
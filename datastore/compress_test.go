@@ -0,0 +1,85 @@
+package datastore
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	tmp := t.TempDir()
+	opts := Options{CompressThreshold: 100, DisableAutoMerge: true}
+	db, err := OpenWithOptions(tmp, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	large := strings.Repeat(`{"team":"blue","score":42},`, 200)
+	expected := map[string]string{
+		"large": large,
+		"small": "tiny",
+	}
+	for key, value := range expected {
+		if err := db.Put(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	size, err := db.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size >= int64(len(large)) {
+		t.Errorf("On-disk size %d is not smaller than the raw value (%d bytes)", size, len(large))
+	}
+
+	check := func(stage string) {
+		t.Helper()
+		for key, value := range expected {
+			if got, err := db.Get(key); err != nil || got != value {
+				t.Errorf("%s: Get(%q) returned %d bytes, %v", stage, key, len(got), err)
+			}
+		}
+	}
+	check("after put")
+
+	if _, err := db.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("other", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CompactNow(); err != nil {
+		t.Fatal(err)
+	}
+	expected["other"] = "v"
+	check("after merge")
+
+	segmentFiles, err := db.segmentFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged, err := latestValues(t.Context(), segmentFiles, false, func(int) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := merged["large"]; !e.compressed || len(e.value) >= len(large) {
+		t.Error("Merge did not keep the value compressed")
+	}
+	if merged["small"].compressed {
+		t.Error("Value below the threshold was compressed")
+	}
+
+	// A store opened without compression still reads compressed records.
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = OpenWithOptions(tmp, Options{DisableAutoMerge: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("after reopen")
+}
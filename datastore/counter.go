@@ -0,0 +1,87 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// NotIntegerError is returned by GetInt and Increment when the stored value
+// is not a decimal int64.
+type NotIntegerError struct {
+	Key   string
+	Value string
+	Err   error
+}
+
+func (e *NotIntegerError) Error() string {
+	return fmt.Sprintf("value of %q is not an integer: %q", e.Key, e.Value)
+}
+
+func (e *NotIntegerError) Unwrap() error {
+	return e.Err
+}
+
+// PutInt stores n as a decimal string, so it can also be read with Get.
+func (db *Db) PutInt(key string, n int64) error {
+	return db.Put(key, strconv.FormatInt(n, 10))
+}
+
+func (db *Db) GetInt(key string) (int64, error) {
+	value, err := db.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	return parseInt(key, value)
+}
+
+// Increment atomically adds delta to the integer stored under key and
+// returns the new value. A missing key counts from zero. The key keeps its
+// TTL, if it has one.
+func (db *Db) Increment(key string, delta int64) (int64, error) {
+	if err := db.limits.check(key, ""); err != nil {
+		return 0, err
+	}
+	if db.readOnly {
+		return 0, ErrReadOnly
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var n int64
+	value, err := db.getLocked(context.Background(), key)
+	switch {
+	case errors.Is(err, ErrNotFound):
+	case err != nil:
+		return 0, err
+	default:
+		if n, err = parseInt(key, value); err != nil {
+			return 0, err
+		}
+	}
+
+	n += delta
+	e := entry{key: key, value: strconv.FormatInt(n, 10)}
+	if s := db.shard(key); !s.expired(key) {
+		e.expires = s.expiries[key]
+	}
+	if db.wal != nil {
+		err = db.writeCommitted([]entry{e})
+	} else {
+		err = db.appendEntry(e)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func parseInt(key, value string) (int64, error) {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, &NotIntegerError{Key: key, Value: value, Err: err}
+	}
+	return n, nil
+}
@@ -0,0 +1,58 @@
+package datastore
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestIncrement(t *testing.T) {
+	for _, opts := range []Options{{}, {WriteBuffer: 1024}} {
+		db, err := OpenWithOptions(t.TempDir(), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if n, err := db.Increment("counter", 5); err != nil || n != 5 {
+			t.Errorf("Increment of a missing key = %d, %v; wanted 5", n, err)
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := db.Increment("counter", 2); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if n, err := db.GetInt("counter"); err != nil || n != 105 {
+			t.Errorf("GetInt(counter) = %d, %v after concurrent increments; wanted 105", n, err)
+		}
+
+		if err := db.PutInt("neg", -7); err != nil {
+			t.Fatal(err)
+		}
+		if n, err := db.Increment("neg", -3); err != nil || n != -10 {
+			t.Errorf("Increment(neg) = %d, %v; wanted -10", n, err)
+		}
+
+		if err := db.Put("text", "hello"); err != nil {
+			t.Fatal(err)
+		}
+		var notInt *NotIntegerError
+		if _, err := db.Increment("text", 1); !errors.As(err, &notInt) || notInt.Key != "text" {
+			t.Errorf("Increment of a string value returned %v, wanted *NotIntegerError", err)
+		}
+		if _, err := db.GetInt("text"); !errors.As(err, &notInt) {
+			t.Errorf("GetInt of a string value returned %v, wanted *NotIntegerError", err)
+		}
+		if _, err := db.GetInt("missing"); err != ErrNotFound {
+			t.Errorf("GetInt of a missing key returned %v", err)
+		}
+		db.Close()
+	}
+}
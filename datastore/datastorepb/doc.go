@@ -0,0 +1,5 @@
+// Package datastorepb holds the gRPC API of the datastore, generated from
+// datastore.proto.
+package datastorepb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative datastore.proto
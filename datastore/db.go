@@ -2,24 +2,49 @@ package datastore
 
 import (
 	"bufio"
+	"cmp"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"log"
+	"math"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
-	"runtime"
+	"sync/atomic"
+	"time"
 )
 
 const outFileName = "current-data"
 
 var ErrNotFound = fmt.Errorf("record does not exist")
 
+// ErrReadTimeout is returned by reads that a worker didn't finish within
+// Options.ReadTimeout.
+var ErrReadTimeout = fmt.Errorf("read timed out")
+
 var simulateMergeError = false
 
+// mergeHook is called by merges between reading the inputs and writing the
+// merged segment, while db.mu is not held.
+var mergeHook = func() {}
+
+// readHook is called by a read worker before it serves a request.
+var readHook = func() {}
+
+// adviseFile passes page cache advice for a file to the OS.
+var adviseFile = fadvise
+
+var (
+	timeNow                 = time.Now
+	segmentAgeCheckInterval = time.Second
+)
+
 type hashIndex map[string]int64
 
 type segmentInfo struct {
@@ -28,10 +53,11 @@ type segmentInfo struct {
 }
 
 type readRequest struct {
-	key        string
+	ctx         context.Context
+	key         string
 	segmentFile string
-	offset     int64
-	result     chan readResult
+	offset      int64
+	result      chan readResult
 }
 
 type readResult struct {
@@ -39,43 +65,93 @@ type readResult struct {
 	err   error
 }
 
+type valueReader interface {
+	read(ctx context.Context, key string, segmentFile string, offset int64) (string, error)
+	stats() ReadPoolStats
+	addSegment(segmentFile string)
+	removeSegment(segmentFile string)
+	reopenCurrent()
+	close()
+}
+
 type readWorkerPool struct {
 	requests   chan readRequest
 	workers    int
 	wg         sync.WaitGroup
 	ctx        chan struct{}
 	dbFilePath string
+	timeout    time.Duration
+
+	// queued counts the reads waiting for a worker and inFlight those being
+	// served.
+	queued   atomic.Int64
+	inFlight atomic.Int64
+
+	// readers counts the reads a shardedReadPool has routed here, which
+	// must finish before the pool of a removed segment is stopped.
+	readers sync.WaitGroup
+
+	handles *fileHandles
 }
 
-func newReadWorkerPool(workers int, dbFilePath string) *readWorkerPool {
+// fileHandles caches a read-only handle per file. Reads go through ReadAt, so
+// workers share a handle without contending on its file offset. A handle is
+// only closed with db.mu held for writing, once no key points into its file.
+type fileHandles struct {
+	mu    sync.Mutex
+	files map[string]*os.File
+}
+
+func newFileHandles() *fileHandles {
+	return &fileHandles{files: make(map[string]*os.File)}
+}
+
+// newReadWorkerPool starts workers reading through handles, or through a
+// cache of their own if handles is nil.
+func newReadWorkerPool(workers int, dbFilePath string, timeout time.Duration, handles *fileHandles) *readWorkerPool {
 	if workers <= 0 {
 		workers = runtime.NumCPU() * 2
 	}
-	
+	if handles == nil {
+		handles = newFileHandles()
+	}
+
 	pool := &readWorkerPool{
 		requests:   make(chan readRequest, workers*2),
 		workers:    workers,
 		ctx:        make(chan struct{}),
 		dbFilePath: dbFilePath,
+		timeout:    timeout,
+		handles:    handles,
 	}
-	
+
 	for i := 0; i < workers; i++ {
 		pool.wg.Add(1)
 		go pool.worker()
 	}
-	
+
 	return pool
 }
 
 func (pool *readWorkerPool) worker() {
 	defer pool.wg.Done()
-	
+
 	for {
 		select {
 		case req := <-pool.requests:
-			value, err := pool.performRead(req)
+			pool.queued.Add(-1)
+			pool.inFlight.Add(1)
+			readHook()
+			var (
+				value string
+				err   error
+			)
+			if err = req.ctx.Err(); err == nil {
+				value, err = pool.performRead(req)
+			}
 			req.result <- readResult{value: value, err: err}
-			
+			pool.inFlight.Add(-1)
+
 		case <-pool.ctx:
 			return
 		}
@@ -89,376 +165,1297 @@ func (pool *readWorkerPool) performRead(req readRequest) (string, error) {
 	} else {
 		filePath = pool.dbFilePath
 	}
-	
-	file, err := os.Open(filePath)
+
+	file, err := pool.handles.get(filePath)
 	if err != nil {
 		return "", err
 	}
-	defer file.Close()
+	return readAt(file, req.key, req.offset)
+}
 
-	_, err = file.Seek(req.offset, 0)
+// get returns the cached handle of path, opening it on first use.
+func (h *fileHandles) get(path string) (*os.File, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if file, ok := h.files[path]; ok {
+		return file, nil
+	}
+	file, err := os.Open(path)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	h.files[path] = file
+	return file, nil
+}
+
+// forget closes the cached handle of path, if any.
+func (h *fileHandles) forget(path string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if file, ok := h.files[path]; ok {
+		file.Close()
+		delete(h.files, path)
+	}
+}
+
+func (h *fileHandles) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for path, file := range h.files {
+		file.Close()
+		delete(h.files, path)
+	}
+}
+
+// errMovedRecord is returned by readAt for an offset that holds the record of
+// another key, as one resolved before a rollover and read after it does.
+var errMovedRecord = fmt.Errorf("record has moved")
 
+func readAt(file *os.File, key string, offset int64) (string, error) {
 	var record entry
-	if _, err = record.DecodeFromReader(bufio.NewReader(file)); err != nil {
+	in := bufio.NewReader(io.NewSectionReader(file, offset, math.MaxInt64-offset))
+	if _, err := record.DecodeFromReader(in); err != nil {
 		return "", err
 	}
-	
-	return record.value, nil
+	if record.key != key {
+		return "", errMovedRecord
+	}
+	return record.payload()
 }
 
-func (pool *readWorkerPool) read(key string, segmentFile string, offset int64) (string, error) {
+// read gives up with ErrReadTimeout if the pool has a timeout and the read
+// isn't done within it, whether it was still queued or being served.
+func (pool *readWorkerPool) read(ctx context.Context, key string, segmentFile string, offset int64) (string, error) {
+	if pool.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, pool.timeout, ErrReadTimeout)
+		defer cancel()
+	}
 	resultChan := make(chan readResult, 1)
-	
+
 	req := readRequest{
-		key:        key,
+		ctx:         ctx,
+		key:         key,
 		segmentFile: segmentFile,
-		offset:     offset,
-		result:     resultChan,
+		offset:      offset,
+		result:      resultChan,
 	}
-	
+
+	pool.queued.Add(1)
 	select {
 	case pool.requests <- req:
-		result := <-resultChan
-		return result.value, result.err
 	case <-pool.ctx:
+		pool.queued.Add(-1)
 		return "", fmt.Errorf("worker pool is shutting down")
+	case <-ctx.Done():
+		pool.queued.Add(-1)
+		return "", context.Cause(ctx)
+	}
+
+	select {
+	case result := <-resultChan:
+		return result.value, result.err
+	case <-ctx.Done():
+		return "", context.Cause(ctx)
 	}
 }
 
-func (pool *readWorkerPool) close() {
+func (pool *readWorkerPool) stats() ReadPoolStats {
+	return ReadPoolStats{
+		Workers:  pool.workers,
+		Queued:   int(pool.queued.Load()),
+		InFlight: int(pool.inFlight.Load()),
+	}
+}
+
+// addSegment drops the handle of the current-data file, which now refers to
+// the file just sealed into a segment.
+func (pool *readWorkerPool) addSegment(string) {
+	pool.handles.forget(pool.dbFilePath)
+}
+
+func (pool *readWorkerPool) removeSegment(segmentFile string) {
+	pool.handles.forget(segmentFile)
+}
+
+// reopenCurrent drops the handle of the current-data file after the file was
+// replaced under the same name.
+func (pool *readWorkerPool) reopenCurrent() {
+	pool.handles.forget(pool.dbFilePath)
+}
+
+// stop ends the workers, leaving the handles open for other pools sharing
+// them.
+func (pool *readWorkerPool) stop() {
 	close(pool.ctx)
 	pool.wg.Wait()
 }
 
+func (pool *readWorkerPool) close() {
+	pool.stop()
+	pool.handles.closeAll()
+}
+
+// Options configures a Db opened with OpenWithOptions.
+type Options struct {
+	// SegmentSize is the size in bytes after which the current-data file is
+	// sealed into a segment. Zero disables size-based rollover.
+	SegmentSize int64
+	// MaxSegmentAge seals the current-data file once it has been open for
+	// this long and holds at least one record. Zero disables age-based rollover.
+	MaxSegmentAge time.Duration
+	// ShardReads gives each sealed segment its own read workers once the
+	// store holds enough segments, so reads of different segments don't
+	// contend on a single request channel.
+	ShardReads bool
+	// TagFunc enables a secondary index from a tag derived from each value
+	// to its keys, queried with ByTag. The index is rebuilt on Open by
+	// reading every live value.
+	TagFunc TagFunc
+	// DisableAutoMerge stops segment rollover from starting a background
+	// merge, e.g. when compaction runs out of process via CompactOffline.
+	DisableAutoMerge bool
+	// WriteBuffer enables group commit: concurrent Puts are buffered up to
+	// this many bytes and flushed together with a single write and fsync.
+	// Zero writes every Put directly.
+	WriteBuffer int
+	// AppendBuffer collects records written to the current-data file in
+	// memory, up to this many bytes, and writes them with one call, saving a
+	// syscall per Put. Unlike WriteBuffer it doesn't make Puts wait: records
+	// reach the file once the buffer fills, every AppendFlushInterval, on
+	// Flush or Sync, or before a read of a buffered record, so a crash can
+	// lose the buffered Puts. Zero writes every Put directly.
+	AppendBuffer int
+	// AppendFlushInterval is how often AppendBuffer is written out in the
+	// background. Zero uses 100ms.
+	AppendFlushInterval time.Duration
+	// PinMergedSegments asks the OS to keep freshly merged segments in the
+	// page cache and to drop the segments they replace. It is a no-op where
+	// posix_fadvise is unavailable.
+	PinMergedSegments bool
+	// ReadOnly opens the store without taking the directory lock, so it can
+	// be inspected while another process writes to it. Writes, merges and
+	// rotation fail with ErrReadOnly.
+	ReadOnly bool
+	// LockWait is how long Open waits for another store to release the
+	// directory lock before failing with ErrLocked. Zero fails immediately.
+	LockWait time.Duration
+	// ReadWorkers is the number of goroutines serving reads. Each worker
+	// may hold a file descriptor and a read buffer while it serves a
+	// request, so lower it on machines short of descriptors or memory.
+	// Zero or a negative value uses twice the number of CPUs.
+	ReadWorkers int
+	// SyncEvery fsyncs the current-data file after this many writes, so 1
+	// makes every acknowledged Put durable at the cost of a disk flush per
+	// Put, often tens of times slower (see BenchmarkPutSync). Zero leaves
+	// flushing to the OS. Writes through WriteBuffer are always synced.
+	SyncEvery int
+	// SyncInterval fsyncs the current-data file in the background this often
+	// when it has unsynced writes, bounding how much a crash can lose.
+	SyncInterval time.Duration
+	// CompressThreshold gzips values of at least this many bytes before
+	// they are written, when that makes them smaller. Get decompresses them
+	// transparently. Zero disables compression.
+	CompressThreshold int
+	// MergeMaxSegments starts a background merge on rollover once there are
+	// more sealed segments than this. Zero uses defaultMergeMaxSegments.
+	MergeMaxSegments int
+	// MergeStaleRatio starts a background merge on rollover once this
+	// fraction of the records in sealed segments has been superseded by
+	// newer writes. Zero uses defaultMergeStaleRatio.
+	MergeStaleRatio float64
+	// MergeInterval checks the merge policy this often and merges when it
+	// calls for it, so a store that stops receiving writes still compacts
+	// its segments. It applies even with DisableAutoMerge. Zero disables it.
+	MergeInterval time.Duration
+	// RepairOnOpen truncates a partial record at the end of the newest
+	// segment, as a crash mid-write can leave, instead of failing Open. The
+	// discarded byte count is logged. Corruption anywhere else still fails.
+	// A read-only store skips the partial record without truncating it.
+	RepairOnOpen bool
+	// OnMergeError is called from the merge goroutine with the error of every
+	// failed background merge, including merges stopped by CancelOperation.
+	OnMergeError func(error)
+	// MaxKeyLen and MaxValueLen make writes of longer keys and values fail
+	// with ErrKeyTooLong and ErrValueTooLong. Zero, or a limit above what the
+	// record format holds, uses the format's limit.
+	MaxKeyLen   int
+	MaxValueLen int
+	// ReadTimeout fails reads that wait for a read worker and are served
+	// for longer than this in total with ErrReadTimeout, so a burst of
+	// reads that the pool can't keep up with fails fast instead of queueing
+	// without bound. Zero waits as long as the read's context allows.
+	ReadTimeout time.Duration
+	// ReadCache keeps recently read values in memory, up to this many bytes
+	// of keys and values, so repeated Gets of a hot key skip the disk. Writes
+	// and merges drop the keys they touch. Zero disables the cache.
+	ReadCache int
+}
+
 type Db struct {
 	dir         string
 	out         *os.File
 	outOffset   int64
+	outOpenedAt time.Time
 	segmentSize int64
 	segmentNum  int
-	
-	index      hashIndex
-	segments   map[string]*segmentInfo
-	mu         sync.RWMutex
-	readerPool *readWorkerPool
+
+	maxSegmentAge time.Duration
+	syncEvery     int
+	syncInterval  time.Duration
+	unsynced      int
+	autoMerge     bool
+	pinSegments   bool
+	onMergeError  func(error)
+	mergeMaxSegs  int
+	mergeStale    float64
+	mergeInterval time.Duration
+	compressAt    int
+	limits        sizeLimits
+	readOnly      bool
+	repairOnOpen  bool
+	lock          *dirLock
+
+	// shards locate every key; see indexShard for their locking.
+	shards [indexShards]*indexShard
+	// segmentRecords counts the records in every sealed segment, and
+	// outRecords those in the current-data file, for the merge policy.
+	segmentRecords map[string]int
+	outRecords     int
+	// hinted holds the sealed segments whose hint file is complete.
+	hinted map[string]bool
+	// blooms holds the bloom filters of sealed segments that have one.
+	blooms map[string]*bloomFilter
+	// fileGen changes whenever records move between files, by sealing or
+	// merging, so callers that read outside the lock can detect it.
+	fileGen uint64
+	mergeMu sync.Mutex
+	// mergeRunning and mergeQueued coalesce background merge triggers:
+	// a trigger during a merge makes it run once more instead of starting
+	// another merge.
+	mergeRunning atomic.Bool
+	mergeQueued  atomic.Bool
+	mu           sync.RWMutex
+	// appendMu serializes writers that hold mu for reading. It guards the
+	// current-data file and what a write changes besides the shards:
+	// offsets, record and sync counters, the tag index and the sequence.
+	appendMu   sync.Mutex
+	readerPool valueReader
+	cache      *readCache
+	ops        *operationRegistry
+	tags       *tagIndex
+	wal        *writeBuffer
+
+	// appendBuf holds whole records written past the end of the
+	// current-data file, up to appendBufSize bytes. It is guarded like the
+	// file itself.
+	appendBuf     []byte
+	appendBufSize int
+
+	seq        uint64
+	seqChanged chan struct{}
+
+	done chan struct{}
+	bg   sync.WaitGroup
 }
 
+// Open opens the store in dir, sealing segments of segmentSize bytes.
+//
+// Deprecated: Use OpenWithOptions with WithSegmentSize.
 func Open(dir string, segmentSize int64) (*Db, error) {
+	return OpenWithOptions(dir, WithSegmentSize(segmentSize))
+}
+
+// OpenWithOptions opens the store in dir, applying options in order. An
+// Options value sets every setting at once, and With functions set one each.
+func OpenWithOptions(dir string, options ...Option) (*Db, error) {
+	var opts Options
+	for _, o := range options {
+		o.apply(&opts)
+	}
+
+	var lock *dirLock
+	if !opts.ReadOnly {
+		var err error
+		lock, err = acquireLock(filepath.Join(dir, lockFileName), opts.LockWait)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	outputPath := filepath.Join(dir, outFileName)
-	f, err := os.OpenFile(outputPath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o600)
+	flags := os.O_APPEND | os.O_WRONLY | os.O_CREATE
+	if opts.ReadOnly {
+		flags = os.O_RDONLY
+	}
+	f, err := os.OpenFile(outputPath, flags, 0o600)
 	if err != nil {
+		lock.release()
 		return nil, err
 	}
-	
+
+	var readerPool valueReader = newReadWorkerPool(opts.ReadWorkers, outputPath, opts.ReadTimeout, nil)
+	if opts.ShardReads {
+		readerPool = newShardedReadPool(readerPool.(*readWorkerPool))
+	}
+
 	db := &Db{
-		dir:         dir,
-		out:         f,
-		segmentSize: segmentSize,
-		index:       make(hashIndex),
-		segments:    make(map[string]*segmentInfo),
-		readerPool:  newReadWorkerPool(0, outputPath),
-	}
-	
+		dir:            dir,
+		out:            f,
+		outOpenedAt:    timeNow(),
+		segmentSize:    opts.SegmentSize,
+		maxSegmentAge:  opts.MaxSegmentAge,
+		syncEvery:      opts.SyncEvery,
+		syncInterval:   opts.SyncInterval,
+		autoMerge:      !opts.DisableAutoMerge,
+		pinSegments:    opts.PinMergedSegments,
+		onMergeError:   opts.OnMergeError,
+		mergeMaxSegs:   cmp.Or(opts.MergeMaxSegments, defaultMergeMaxSegments),
+		mergeStale:     cmp.Or(opts.MergeStaleRatio, defaultMergeStaleRatio),
+		mergeInterval:  opts.MergeInterval,
+		compressAt:     opts.CompressThreshold,
+		limits:         newSizeLimits(opts),
+		readOnly:       opts.ReadOnly,
+		repairOnOpen:   opts.RepairOnOpen,
+		lock:           lock,
+		shards:         newIndexShards(),
+		hinted:         make(map[string]bool),
+		blooms:         make(map[string]*bloomFilter),
+		segmentRecords: make(map[string]int),
+		readerPool:     readerPool,
+		cache:          newReadCache(opts.ReadCache),
+		ops:            newOperationRegistry(),
+		seqChanged:     make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+	if opts.WriteBuffer > 0 {
+		db.wal = newWriteBuffer(opts.WriteBuffer)
+	}
+	if opts.AppendBuffer > 0 && !opts.ReadOnly {
+		db.appendBufSize = opts.AppendBuffer
+		db.appendBuf = make([]byte, 0, opts.AppendBuffer)
+	}
+	fail := func(err error) (*Db, error) {
+		readerPool.close()
+		f.Close()
+		lock.release()
+		return nil, err
+	}
+
+	if !db.readOnly {
+		// Finish adopting a compaction that was staged, or cut short by a
+		// crash, while the store was closed.
+		if err := db.adoptCompaction(); err != nil {
+			log.Printf("datastore: adopting the staged compaction failed: %s", err)
+		}
+	}
 	err = db.recover()
 	if err != nil && err != io.EOF {
-		return nil, err
+		return fail(err)
 	}
-	
+
+	if opts.TagFunc != nil {
+		db.tags = newTagIndex(opts.TagFunc)
+		if err := db.rebuildTags(); err != nil {
+			return fail(err)
+		}
+	}
+
+	if db.maxSegmentAge > 0 && !db.readOnly {
+		db.bg.Add(1)
+		go db.rollOnAge()
+	}
+	if db.wal != nil {
+		db.bg.Add(1)
+		go db.runFlusher()
+	}
+	if !db.readOnly {
+		db.bg.Add(1)
+		go db.sweepExpired()
+	}
+	if db.syncInterval > 0 && !db.readOnly {
+		db.bg.Add(1)
+		go db.syncPeriodically()
+	}
+	if db.appendBufSize > 0 {
+		db.bg.Add(1)
+		go db.flushPeriodically(cmp.Or(opts.AppendFlushInterval, defaultAppendFlushInterval))
+	}
+	if db.mergeInterval > 0 && !db.readOnly {
+		db.bg.Add(1)
+		go db.mergePeriodically()
+	}
+
 	return db, nil
 }
 
+func (db *Db) rollOnAge() {
+	defer db.bg.Done()
+
+	ticker := time.NewTicker(segmentAgeCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			db.mu.Lock()
+			if db.outOffset > 0 && timeNow().Sub(db.outOpenedAt) >= db.maxSegmentAge {
+				if err := db.createNewSegment(); err != nil {
+					log.Printf("datastore: age-based rollover failed: %s", err)
+				}
+			}
+			db.mu.Unlock()
+		case <-db.done:
+			return
+		}
+	}
+}
+
 func (db *Db) recover() error {
+	segmentFiles, err := db.segmentFiles()
+	if err != nil {
+		return err
+	}
+
+	for i, segmentFile := range segmentFiles {
+		err = db.recoverFromSegment(segmentFile, i == len(segmentFiles)-1)
+		if err != nil {
+			return err
+		}
+		db.readerPool.addSegment(segmentFile)
+
+		if num := segmentNumber(segmentFile); num >= db.segmentNum {
+			db.segmentNum = num + 1
+		}
+	}
+
 	f, err := os.Open(db.out.Name())
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
+	// Records are applied once a write-ahead commit marker confirms them.
+	// Files written without the write buffer carry no markers, so their
+	// trailing records are applied at EOF unless the buffer is enabled and
+	// the file holds a marker; a file without any was written unbuffered,
+	// even if the buffer is enabled now. The records of a transaction are
+	// held back until all txLeft of them have been read, so a transaction
+	// cut short is dropped as a whole; txStart and txPending locate where it
+	// began.
+	var (
+		pending   []walRecord
+		committed int64
+		torn      bool
+		sawMarker bool
+		txLeft    int
+		txStart   int64
+		txPending int
+	)
+	apply := func() {
+		for _, r := range pending {
+			db.outRecords++
+			s := db.shard(r.e.key)
+			delete(s.segments, r.e.key)
+			s.trackExpiry(r.e)
+			if r.e.deleted {
+				delete(s.index, r.e.key)
+			} else {
+				s.index[r.e.key] = r.offset
+			}
+		}
+		pending = pending[:0]
+	}
+
 	in := bufio.NewReader(f)
-	for err == nil {
-		var (
-			record entry
-			n      int
-		)
-		n, err = record.DecodeFromReader(in)
+	for {
+		var record entry
+		n, err := record.DecodeFromReader(in)
 		if errors.Is(err, io.EOF) {
-			if n != 0 {
+			break
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			if db.wal == nil && txLeft == 0 {
 				return fmt.Errorf("corrupted file")
 			}
 			break
 		}
+		if tornTail(err, in) {
+			torn = true
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%s at offset %d: %w", db.out.Name(), db.outOffset, err)
+		}
 
-		db.index[record.key] = db.outOffset
+		switch size, isTx := txSize(record); {
+		case isTx:
+			if db.wal == nil {
+				apply()
+				committed = db.outOffset
+			}
+			txLeft, txStart, txPending = size, db.outOffset, len(pending)
+		case record.key == walMarkerKey:
+			apply()
+			committed = db.outOffset + int64(n)
+			sawMarker = true
+		default:
+			pending = append(pending, walRecord{e: record, offset: db.outOffset})
+			if txLeft > 0 {
+				txLeft--
+				if txLeft == 0 && db.wal == nil {
+					apply()
+					committed = db.outOffset + int64(n)
+				}
+			}
+		}
 		db.outOffset += int64(n)
 	}
-	
-	pattern := filepath.Join(db.dir, "*.segment")
-	segmentFiles, err := filepath.Glob(pattern)
-	if err != nil {
-		return err
+
+	if db.wal == nil || !sawMarker {
+		if txLeft > 0 {
+			pending, committed = pending[:txPending], txStart
+		} else {
+			committed = db.outOffset
+		}
+		apply()
 	}
-	
-	sort.Strings(segmentFiles)
-	
-	for _, segmentFile := range segmentFiles {
-		err = db.recoverFromSegment(segmentFile)
-		if err != nil {
+	if (db.outOffset != committed || torn) && !db.readOnly {
+		if err := os.Truncate(db.out.Name(), committed); err != nil {
 			return err
 		}
-		
-		base := filepath.Base(segmentFile)
-		if strings.HasSuffix(base, ".segment") {
-			numStr := strings.TrimSuffix(base, ".segment")
-			if num, parseErr := strconv.Atoi(numStr); parseErr == nil && num >= db.segmentNum {
-				db.segmentNum = num + 1
-			}
-		}
+		db.outOffset = committed
 	}
-	
 	return nil
 }
 
-func (db *Db) recoverFromSegment(segmentFile string) error {
-	f, err := os.Open(segmentFile)
+// tornTail reports whether err is a checksum failure of the last record read
+// from in. Like a truncated record, it is taken for a write cut short by a
+// crash, so the records before it are kept.
+func tornTail(err error, in *bufio.Reader) bool {
+	if !errors.Is(err, ErrChecksumMismatch) {
+		return false
+	}
+	_, peekErr := in.Peek(1)
+	return errors.Is(peekErr, io.EOF)
+}
+
+// segmentFiles lists the sealed segment files of the store from oldest to newest.
+func (db *Db) segmentFiles() ([]string, error) {
+	return listSegments(db.dir)
+}
+
+func listSegments(dir string) ([]string, error) {
+	segmentFiles, err := filepath.Glob(filepath.Join(dir, "*.segment"))
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer f.Close()
+	sort.Slice(segmentFiles, func(i, j int) bool {
+		return segmentNumber(segmentFiles[i]) < segmentNumber(segmentFiles[j])
+	})
+	return segmentFiles, nil
+}
 
-	in := bufio.NewReader(f)
-	var offset int64
-	
-	for {
-		var (
-			record entry
-			n      int
-		)
-		n, err = record.DecodeFromReader(in)
-		if errors.Is(err, io.EOF) {
-			if n != 0 {
-				return fmt.Errorf("corrupted segment file: %s", segmentFile)
+func segmentNumber(segmentFile string) int {
+	num, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(segmentFile), ".segment"))
+	if err != nil {
+		return -1
+	}
+	return num
+}
+
+// recoverFromSegment indexes segmentFile from its hint file, falling back to
+// reading the segment itself if the hint file is missing or bad. A partial
+// record at the end of the newest segment is repaired if RepairOnOpen is set.
+func (db *Db) recoverFromSegment(segmentFile string, newest bool) error {
+	hints, records, err := readHintFile(segmentFile)
+	hinted := err == nil
+	if err != nil {
+		hints, records, err = segmentHints(segmentFile)
+		var partial *partialRecordError
+		if errors.As(err, &partial) && newest && db.repairOnOpen {
+			err = nil
+			if !db.readOnly {
+				err = partial.repair()
 			}
-			break
 		}
 		if err != nil {
 			return err
 		}
+	}
 
-		db.segments[record.key] = &segmentInfo{
-			file:   segmentFile,
-			offset: offset,
+	for _, h := range hints {
+		s := db.shard(h.key)
+		s.trackExpiry(entry{key: h.key, expires: h.expires, deleted: h.deleted})
+		if h.deleted {
+			delete(s.segments, h.key)
+		} else {
+			s.segments[h.key] = &segmentInfo{
+				file:   segmentFile,
+				offset: h.offset,
+			}
+			delete(s.index, h.key)
 		}
-		
-		delete(db.index, record.key)
-		
-		offset += int64(n)
 	}
-	
+	if hinted {
+		db.hinted[segmentFile] = true
+	}
+	if filter, err := readBloomFile(segmentFile); err == nil {
+		db.blooms[segmentFile] = filter
+	} else {
+		db.blooms[segmentFile] = bloomOf(hints)
+	}
+	db.segmentRecords[segmentFile] = records
 	return nil
 }
 
 func (db *Db) Close() error {
+	close(db.done)
+	db.bg.Wait()
+	flushErr := db.flushAppendsLocked()
+	if db.unsynced > 0 && (db.syncEvery > 0 || db.syncInterval > 0) {
+		_ = db.out.Sync()
+	}
 	if db.readerPool != nil {
 		db.readerPool.close()
 	}
-	return db.out.Close()
+	err := db.out.Close()
+	if err == nil {
+		err = flushErr
+	}
+	if lockErr := db.lock.release(); err == nil {
+		err = lockErr
+	}
+	return err
 }
 
 func (db *Db) Get(key string) (string, error) {
+	return db.GetContext(context.Background(), key)
+}
+
+// GetContext is Get that stops waiting for the read once ctx is done. With
+// Options.ReadTimeout set, it also fails with ErrReadTimeout once the read
+// has taken that long.
+func (db *Db) GetContext(ctx context.Context, key string) (string, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
-	
-	if segInfo, ok := db.segments[key]; ok {
-		return db.readerPool.read(key, segInfo.file, segInfo.offset)
+
+	return db.getLocked(ctx, key)
+}
+
+// getLocked must be called with db.mu held for reading or writing. The
+// shard is only locked while the key is looked up; the files it points to
+// are not replaced while db.mu is held.
+func (db *Db) getLocked(ctx context.Context, key string) (string, error) {
+	gen := db.cache.generation()
+	s := db.shard(key)
+	s.mu.RLock()
+	expired := s.expired(key)
+	segInfo, inSegments := s.segments[key]
+	position, inIndex := s.index[key]
+	var segmentFile string
+	if inSegments {
+		segmentFile, position = segInfo.file, segInfo.offset
 	}
-	
-	position, ok := db.index[key]
-	if !ok {
+	s.mu.RUnlock()
+
+	if expired || (!inSegments && !inIndex) {
 		return "", ErrNotFound
 	}
-
-	return db.readerPool.read(key, "", position)
+	if !inSegments {
+		if err := db.flushFor(position); err != nil {
+			return "", err
+		}
+	}
+	if value, ok := db.cache.get(key); ok {
+		return value, nil
+	}
+	value, err := db.readerPool.read(ctx, key, segmentFile, position)
+	if err == nil {
+		db.cache.add(key, value, gen)
+	}
+	return value, err
 }
 
 func (db *Db) Put(key, value string) error {
+	return db.PutContext(context.Background(), key, value)
+}
+
+// PutContext is Put that gives up once ctx is done. A write that has already
+// been handed to the file or the write buffer is not undone, so a cancelled
+// PutContext may still have stored the value.
+func (db *Db) PutContext(ctx context.Context, key, value string) error {
+	_, err := db.write(ctx, entry{key: key, value: value})
+	return err
+}
+
+// PutN is Put that also returns the size of the record it wrote.
+func (db *Db) PutN(key, value string) (int, error) {
+	return db.PutNContext(context.Background(), key, value)
+}
+
+// PutNContext is PutContext that also returns the size of the record it
+// wrote.
+func (db *Db) PutNContext(ctx context.Context, key, value string) (int, error) {
+	return db.write(ctx, entry{key: key, value: value})
+}
+
+// Delete removes key by appending a tombstone record, so the deletion
+// survives reopening and merges. It returns ErrNotFound if key is absent.
+// Like CompareAndSwap, the check and the write happen under the write lock,
+// so a concurrent Delete of the same key can't both succeed.
+func (db *Db) Delete(key string) error {
+	if err := db.limits.check(key, ""); err != nil {
+		return err
+	}
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	e := entry{key: key, deleted: true}
+
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	
-	e := entry{
-		key:   key,
-		value: value,
+
+	if !db.existsLocked(key) {
+		return ErrNotFound
+	}
+	if db.wal != nil {
+		return db.writeCommitted([]entry{e})
+	}
+	return db.appendEntry(e)
+}
+
+// write appends e and returns the size of its encoded record.
+func (db *Db) write(ctx context.Context, e entry) (int, error) {
+	if err := db.limits.check(e.key, e.value); err != nil {
+		return 0, err
+	}
+	if db.readOnly {
+		return 0, ErrReadOnly
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	e, err := db.compress(e)
+	if err != nil {
+		return 0, err
 	}
-	
 	encoded := e.Encode()
-	
-	if db.segmentSize > 0 && db.outOffset+int64(len(encoded)) > db.segmentSize {
+	if db.wal != nil {
+		if err := db.putBuffered(ctx, e, len(encoded)); err != nil {
+			return 0, err
+		}
+		return len(encoded), nil
+	}
+
+	// Appends that fit the current-data file only need the read lock, so
+	// they run alongside reads. Sealing touches every shard and takes the
+	// write lock instead.
+	db.mu.RLock()
+	if err := ctx.Err(); err != nil {
+		db.mu.RUnlock()
+		return 0, err
+	}
+	db.appendMu.Lock()
+	if !db.overflows(len(encoded)) {
+		err := db.appendEncoded(e, encoded)
+		db.appendMu.Unlock()
+		db.mu.RUnlock()
+		if err != nil {
+			return 0, err
+		}
+		return len(encoded), nil
+	}
+	db.appendMu.Unlock()
+	db.mu.RUnlock()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	if err := db.appendEncoded(e, encoded); err != nil {
+		return 0, err
+	}
+	return len(encoded), nil
+}
+
+// appendEntry writes e to the current-data file, sealing it first if e would
+// overflow the segment size. The write lock must be held.
+func (db *Db) appendEntry(e entry) error {
+	return db.appendEncoded(e, e.Encode())
+}
+
+// appendEncoded is appendEntry for an already encoded e. Holding appendMu
+// with the read lock suffices if the record doesn't overflow the segment.
+func (db *Db) appendEncoded(e entry, encoded []byte) error {
+	if db.overflows(len(encoded)) {
 		if err := db.createNewSegment(); err != nil {
 			return err
 		}
 	}
-	
-	n, err := db.out.Write(encoded)
-	if err == nil {
-		delete(db.segments, key)
-		
-		db.index[key] = db.outOffset
-		db.outOffset += int64(n)
+
+	if err := db.appendOut(encoded); err != nil {
+		return err
 	}
-	return err
+	db.applyEntry(e, db.outOffset)
+	db.outOffset += int64(len(encoded))
+	return db.wrote()
+}
+
+// ErrSegmentTooSmall is returned by SetSegmentSize for a size that couldn't
+// hold a single record or the writes waiting in the write buffer.
+var ErrSegmentTooSmall = fmt.Errorf("segment size is smaller than a pending write")
+
+// SegmentSize returns the size at which the current-data file is sealed into
+// a segment, or zero if it is never sealed by size.
+func (db *Db) SegmentSize() int64 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.segmentSize
+}
+
+// SetSegmentSize changes the size at which the current-data file is sealed,
+// starting with the next write; a current-data file already past size is
+// sealed then. Zero stops sealing by size.
+func (db *Db) SetSegmentSize(size int64) error {
+	if size < 0 {
+		return fmt.Errorf("negative segment size %d", size)
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if size > 0 {
+		pending := int64(entryOverhead + 1)
+		if db.wal != nil {
+			db.wal.mu.Lock()
+			if db.wal.batch != nil {
+				pending = max(pending, int64(db.wal.batch.size))
+			}
+			db.wal.mu.Unlock()
+		}
+		if size < pending {
+			return ErrSegmentTooSmall
+		}
+	}
+	db.segmentSize = size
+	return nil
+}
+
+// overflows reports whether a record of size bytes would push the
+// current-data file past the segment size.
+func (db *Db) overflows(size int) bool {
+	return db.segmentSize > 0 && db.outOffset+int64(size) > db.segmentSize
+}
+
+// applyEntry records e, written at offset in the current-data file, in the
+// in-memory indexes. The write lock, or the read lock and appendMu, must be
+// held.
+func (db *Db) applyEntry(e entry, offset int64) {
+	db.outRecords++
+	s := db.shard(e.key)
+	s.mu.Lock()
+	delete(s.segments, e.key)
+	s.trackExpiry(e)
+	if e.deleted {
+		delete(s.index, e.key)
+		delete(s.versions, e.key)
+	} else {
+		s.index[e.key] = offset
+		s.versions[e.key] = db.seq + 1
+	}
+	s.mu.Unlock()
+	db.cache.invalidate(e.key)
+
+	if db.tags != nil {
+		if e.deleted {
+			db.tags.remove(e.key)
+		} else if value, err := e.payload(); err == nil {
+			db.tags.set(e.key, value)
+		}
+	}
+	db.advanceSeq()
 }
 
 func (db *Db) createNewSegment() error {
-	if err := db.out.Close(); err != nil {
+	if _, err := db.sealCurrent(); err != nil {
 		return err
 	}
-	
+
+	if db.autoMerge && db.shouldMerge() {
+		db.bg.Add(1)
+		go func() {
+			defer db.bg.Done()
+			db.MergeSegments()
+		}()
+	}
+
+	return nil
+}
+
+// Rotate seals the current-data file into a new segment regardless of its
+// size and returns the sealed segment path. Later writes go to a fresh
+// current-data file, so the returned segment is complete and immutable until
+// a subsequent merge consumes it. An empty current-data file is left as it is
+// and Rotate returns an empty path.
+func (db *Db) Rotate() (string, error) {
+	if db.readOnly {
+		return "", ErrReadOnly
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.outOffset == 0 {
+		return "", nil
+	}
+	return db.sealCurrent()
+}
+
+func (db *Db) sealCurrent() (string, error) {
+	if err := db.flushAppendsLocked(); err != nil {
+		return "", err
+	}
+	if db.unsynced > 0 && (db.syncEvery > 0 || db.syncInterval > 0) {
+		if err := db.syncLocked(); err != nil {
+			return "", err
+		}
+	}
+	if err := db.out.Close(); err != nil {
+		return "", err
+	}
+
 	currentPath := db.out.Name()
 	segmentPath := filepath.Join(db.dir, fmt.Sprintf("%d.segment", db.segmentNum))
-	
+
 	if err := os.Rename(currentPath, segmentPath); err != nil {
-		return err
+		return "", err
 	}
-	
-	for key, offset := range db.index {
-		db.segments[key] = &segmentInfo{
-			file:   segmentPath,
-			offset: offset,
+	db.readerPool.addSegment(segmentPath)
+	db.bg.Add(1)
+	go db.writeSealedHint(segmentPath)
+
+	for _, s := range db.shards {
+		for key, offset := range s.index {
+			s.segments[key] = &segmentInfo{
+				file:   segmentPath,
+				offset: offset,
+			}
 		}
+		s.index = make(hashIndex)
 	}
-	
-	db.index = make(hashIndex)
 	db.segmentNum++
-	
+	db.fileGen++
+	db.segmentRecords[segmentPath] = db.outRecords
+	db.outRecords = 0
+
 	f, err := os.OpenFile(currentPath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o600)
 	if err != nil {
-		return err
+		return "", err
 	}
-	
+
 	db.out = f
 	db.outOffset = 0
-	
-	go db.MergeSegments()
-	
-	return nil
+	db.outOpenedAt = timeNow()
+
+	return segmentPath, nil
 }
 
+// MergeSegments merges the sealed segments, reporting a failure to the
+// OnMergeError callback. If a merge is already running, it is asked to run
+// again once it finishes and MergeSegments returns immediately.
 func (db *Db) MergeSegments() {
-	if simulateMergeError {
+	if !db.mergeRunning.CompareAndSwap(false, true) {
+		db.mergeQueued.Store(true)
 		return
 	}
-	
+	for {
+		db.mergeQueued.Store(false)
+
+		var err error
+		if simulateMergeError {
+			err = fmt.Errorf("merge: simulated failure")
+		} else {
+			_, err = db.merge()
+		}
+		if err != nil && db.onMergeError != nil {
+			db.onMergeError(err)
+		}
+
+		db.mergeRunning.Store(false)
+		if !db.mergeQueued.Load() || !db.mergeRunning.CompareAndSwap(false, true) {
+			return
+		}
+	}
+}
+
+// CompactNow merges all sealed segments synchronously, then compacts the
+// current-data file with CompactCurrent, and reports how many bytes of
+// on-disk storage were reclaimed. It waits for a background merge in
+// progress and returns only once the merged segment is in place and its
+// inputs are removed.
+func (db *Db) CompactNow() (int64, error) {
+	merged, err := db.merge()
+	if err != nil {
+		return 0, err
+	}
+	compacted, err := db.CompactCurrent()
+	if err != nil {
+		return 0, err
+	}
+	return merged + compacted, nil
+}
+
+// merge rewrites the sealed segments into one and returns the number of bytes
+// it freed. The files are read and written without holding db.mu, which is
+// only taken to pick the inputs and to swap the merged segment in, so reads
+// and writes carry on during a merge.
+func (db *Db) merge() (int64, error) {
+	if db.readOnly {
+		return 0, ErrReadOnly
+	}
+	db.mergeMu.Lock()
+	defer db.mergeMu.Unlock()
+
 	db.mu.Lock()
-	defer db.mu.Unlock()
-	
-	pattern := filepath.Join(db.dir, "*.segment")
-	segmentFiles, err := filepath.Glob(pattern)
-	if err != nil || len(segmentFiles) < 2 {
-		return
+	segmentFiles, err := db.segmentFiles()
+	if err != nil {
+		db.mu.Unlock()
+		return 0, fmt.Errorf("merge: listing segments in %s: %w", db.dir, err)
 	}
-	
+	if len(segmentFiles) < 2 {
+		db.mu.Unlock()
+		return 0, nil
+	}
+	// Segments sealed while the merge runs get higher numbers, so they
+	// still take precedence over the merged one.
+	mergedSegmentPath := filepath.Join(db.dir, fmt.Sprintf("%d.segment", db.segmentNum))
+	db.segmentNum++
+	db.mu.Unlock()
+
+	op, ctx := db.ops.start("merge")
+	defer db.ops.finish(op)
+
+	allKeys, err := latestValues(ctx, segmentFiles, false, func(done int) {
+		op.progress(int64(done), int64(len(segmentFiles)))
+	})
+	if err != nil {
+		return 0, err
+	}
+	mergeHook()
+
 	tempFile := filepath.Join(db.dir, "merge.tmp")
-	f, err := os.OpenFile(tempFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	hints, err := writeSegment(tempFile, allKeys)
 	if err != nil {
-		return
+		return 0, fmt.Errorf("merge: writing %s: %w", tempFile, err)
 	}
-	
-	allKeys := make(map[string]string)
-	
-	sort.Sort(sort.Reverse(sort.StringSlice(segmentFiles)))
-	
+
+	if err := os.Rename(tempFile, mergedSegmentPath); err != nil {
+		os.Remove(tempFile)
+		return 0, fmt.Errorf("merge: renaming %s to %s: %w", tempFile, mergedSegmentPath, err)
+	}
+
+	// The merged segment holds one record per key.
+	hintErr := writeHintFile(mergedSegmentPath, hints, len(hints))
+	if hintErr != nil {
+		log.Printf("datastore: writing hint file for %s failed: %s", mergedSegmentPath, hintErr)
+	}
+	filter, err := writeBloomFile(mergedSegmentPath, hints)
+	if err != nil {
+		log.Printf("datastore: writing bloom file for %s failed: %s", mergedSegmentPath, err)
+		filter = bloomOf(hints)
+	}
+
+	inputs := make(map[string]bool, len(segmentFiles))
 	for _, segmentFile := range segmentFiles {
+		inputs[segmentFile] = true
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// Keys written, deleted or sealed into a newer segment during the merge
+	// no longer point into the inputs and keep their newer location.
+	for _, h := range hints {
+		if segInfo, exists := db.shard(h.key).segments[h.key]; exists && inputs[segInfo.file] {
+			segInfo.file = mergedSegmentPath
+			segInfo.offset = h.offset
+			db.cache.invalidate(h.key)
+		}
+	}
+	// The merge leaves out keys whose newest record had expired, which may
+	// not have been swept from the index yet. Drop them rather than leave
+	// them pointing at removed inputs.
+	for _, s := range db.shards {
+		for key, segInfo := range s.segments {
+			if inputs[segInfo.file] {
+				db.dropKey(s, key)
+			}
+		}
+	}
+	db.fileGen++
+	if hintErr == nil {
+		db.hinted[mergedSegmentPath] = true
+	}
+	db.blooms[mergedSegmentPath] = filter
+	db.segmentRecords[mergedSegmentPath] = len(hints)
+
+	var freed int64
+	if info, err := os.Stat(mergedSegmentPath); err == nil {
+		freed -= info.Size()
+	}
+	db.readerPool.addSegment(mergedSegmentPath)
+	for _, segmentFile := range segmentFiles {
+		db.readerPool.removeSegment(segmentFile)
+		if db.pinSegments {
+			_ = adviseFile(segmentFile, fadvDontNeed)
+		}
+		if info, err := os.Stat(segmentFile); err == nil {
+			freed += info.Size()
+		}
+		os.Remove(segmentFile)
+		os.Remove(hintPath(segmentFile))
+		os.Remove(bloomPath(segmentFile))
+		delete(db.hinted, segmentFile)
+		delete(db.blooms, segmentFile)
+		delete(db.segmentRecords, segmentFile)
+	}
+	if db.pinSegments {
+		_ = adviseFile(mergedSegmentPath, fadvWillNeed)
+	}
+	return freed, nil
+}
+
+// latestValues reads segmentFiles, ordered from oldest to newest, and returns
+// the newest record of every key they hold. Keys whose newest record is a
+// tombstone or has expired are left out, so they are not carried forward,
+// unless keepTombstones is set because older records of them may outlive
+// the result. progress is called before each file.
+func latestValues(ctx context.Context, segmentFiles []string, keepTombstones bool, progress func(done int)) (map[string]entry, error) {
+	allKeys := make(map[string]entry)
+	now := timeNow().UnixNano()
+	seen := make(map[string]struct{})
+
+	for i := len(segmentFiles) - 1; i >= 0; i-- {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		progress(len(segmentFiles) - 1 - i)
+
+		segmentFile := segmentFiles[i]
 		segFile, err := os.Open(segmentFile)
 		if err != nil {
-			f.Close()
-			os.Remove(tempFile)
-			return
+			return nil, err
 		}
-		
+
+		// Later records in a file supersede earlier ones for the same key.
+		fileRecords := make(map[string]entry)
 		in := bufio.NewReader(segFile)
 		for {
 			var record entry
 			_, err := record.DecodeFromReader(in)
-			if errors.Is(err, io.EOF) {
+			if errors.Is(err, io.EOF) || tornTail(err, in) {
 				break
 			}
 			if err != nil {
 				segFile.Close()
-				f.Close()
-				os.Remove(tempFile)
-				return
+				return nil, fmt.Errorf("merge: reading %s: %w", segmentFile, err)
 			}
-			
-			if _, exists := allKeys[record.key]; !exists {
-				allKeys[record.key] = record.value
+			if record.key != walMarkerKey {
+				fileRecords[record.key] = record
 			}
 		}
 		segFile.Close()
+
+		for key, record := range fileRecords {
+			if _, exists := seen[key]; exists {
+				continue
+			}
+			seen[key] = struct{}{}
+			if keepTombstones || !record.deleted && (record.expires == 0 || record.expires > now) {
+				allKeys[key] = record
+			}
+		}
 	}
-	
-	newSegments := make(map[string]*segmentInfo)
+	return allKeys, nil
+}
+
+// writeSegment writes records into a new file at path in key order, so the
+// same records always produce the same file, and returns the location of
+// every record in written order. The file is removed if writing fails.
+func writeSegment(path string, records map[string]entry) ([]hintRecord, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(records))
+	for key := range records {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	hints := make([]hintRecord, 0, len(records))
 	var offset int64
-	
-	for key, value := range allKeys {
-		e := entry{key: key, value: value}
+
+	for _, key := range keys {
+		e := records[key]
 		encoded := e.Encode()
-		
+
 		if _, err := f.Write(encoded); err != nil {
 			f.Close()
-			os.Remove(tempFile)
-			return
-		}
-		
-		newSegments[key] = &segmentInfo{
-			file:   tempFile,
-			offset: offset,
+			os.Remove(path)
+			return nil, err
 		}
+
+		hints = append(hints, hintRecord{key: key, offset: offset, expires: e.expires, deleted: e.deleted})
 		offset += int64(len(encoded))
 	}
-	
-	f.Close()
-	
-	mergedSegmentPath := filepath.Join(db.dir, fmt.Sprintf("%d.segment", db.segmentNum))
-	if err := os.Rename(tempFile, mergedSegmentPath); err != nil {
-		os.Remove(tempFile)
-		return
-	}
-	
-	for key := range newSegments {
-		if segInfo, exists := db.segments[key]; exists {
-			segInfo.file = mergedSegmentPath
-			segInfo.offset = newSegments[key].offset
-		}
-	}
-	
-	for _, segmentFile := range segmentFiles {
-		os.Remove(segmentFile)
+
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return nil, err
 	}
-	
-	db.segmentNum++
+	return hints, nil
 }
 
 func (db *Db) Size() (int64, error) {
+	_, _, total, err := db.SizeDetailed()
+	return total, err
+}
+
+// SizeDetailed returns the on-disk size of the current-data file, of the
+// sealed segments, and their sum.
+func (db *Db) SizeDetailed() (current int64, segments int64, total int64, err error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	current, segments, _, err = db.diskUsage()
+	return current, segments, current + segments, err
+}
+
+// diskUsage returns the on-disk sizes of the current-data file and of the
+// sealed segments, and the number of segments. db.mu must be held.
+func (db *Db) diskUsage() (int64, int64, int, error) {
 	info, err := db.out.Stat()
 	if err != nil {
-		return 0, err
+		return 0, 0, 0, err
 	}
-	
-	size := info.Size()
-	
+
 	pattern := filepath.Join(db.dir, "*.segment")
 	segmentFiles, err := filepath.Glob(pattern)
 	if err != nil {
-		return size, nil
+		return info.Size(), 0, 0, nil
 	}
-	
+
+	var segments int64
 	for _, segmentFile := range segmentFiles {
 		if segInfo, err := os.Stat(segmentFile); err == nil {
-			size += segInfo.Size()
+			segments += segInfo.Size()
 		}
 	}
-	
-	return size, nil
-}
\ No newline at end of file
+
+	return info.Size(), segments, len(segmentFiles), nil
+}
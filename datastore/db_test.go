@@ -1,10 +1,16 @@
 package datastore
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -129,8 +135,9 @@ func TestDbSegmentation(t *testing.T) {
 	})
 
 	t.Run("merge operation", func(t *testing.T) {
-		// wait for merge to complete
-		time.Sleep(2 * time.Second)
+		if _, err := db.CompactNow(); err != nil {
+			t.Fatal(err)
+		}
 
 		files, err := filepath.Glob(filepath.Join(tmp, "*.segment"))
 		if err != nil {
@@ -295,3 +302,1075 @@ func TestConcurrentReadsAndWrites(t *testing.T) {
 		}
 	}
 }
+
+func TestConcurrentDistinctKeyWrites(t *testing.T) {
+	db, err := OpenWithOptions(t.TempDir(), Options{SegmentSize: 512})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	const writers = 16
+	const keysPerWriter = 8
+	const rounds = 20
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := 0; r < rounds; r++ {
+				for k := 0; k < keysPerWriter; k++ {
+					key := fmt.Sprintf("w%d-k%d", w, k)
+					value := fmt.Sprintf("v%d", r)
+					if err := db.Put(key, value); err != nil {
+						errs <- err
+						return
+					}
+					// Each key has a single writer, so it reads its own write.
+					if got, err := db.Get(key); err != nil || got != value {
+						errs <- fmt.Errorf("Get(%s) = %q, %v, expected %q", key, got, err, value)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	for w := 0; w < writers; w++ {
+		for k := 0; k < keysPerWriter; k++ {
+			key := fmt.Sprintf("w%d-k%d", w, k)
+			if value, err := db.Get(key); err != nil || value != fmt.Sprintf("v%d", rounds-1) {
+				t.Errorf("Final Get(%s) = %q, %v", key, value, err)
+			}
+		}
+	}
+	if stats := db.Stats(); stats.CurrentKeys+stats.SegmentKeys != writers*keysPerWriter {
+		t.Errorf("Stats count %d keys, expected %d", stats.CurrentKeys+stats.SegmentKeys, writers*keysPerWriter)
+	}
+}
+
+// BenchmarkConcurrentReadsAndWrites mixes Gets and Puts of distinct keys
+// across goroutines, one Put for every three Gets.
+func BenchmarkConcurrentReadsAndWrites(b *testing.B) {
+	db, err := OpenWithOptions(b.TempDir(), Options{SegmentSize: 1 << 20})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+
+	const keys = 1024
+	for i := 0; i < keys; i++ {
+		if err := db.Put(fmt.Sprintf("key%d", i), "value"); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	var n atomic.Int64
+	b.SetParallelism(4)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := n.Add(1)
+			key := fmt.Sprintf("key%d", i%keys)
+			var err error
+			if i%4 == 0 {
+				err = db.Put(key, "value")
+			} else {
+				_, err = db.Get(key)
+			}
+			if err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func TestDbAgeRollover(t *testing.T) {
+	tmp := t.TempDir()
+
+	var (
+		clockMu sync.Mutex
+		now     = time.Now()
+	)
+	origNow, origInterval := timeNow, segmentAgeCheckInterval
+	timeNow = func() time.Time {
+		clockMu.Lock()
+		defer clockMu.Unlock()
+		return now
+	}
+	segmentAgeCheckInterval = 10 * time.Millisecond
+	defer func() { timeNow, segmentAgeCheckInterval = origNow, origInterval }()
+
+	db, err := OpenWithOptions(tmp, Options{MaxSegmentAge: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Put("k1", "v1"); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if n := countSegments(t, tmp); n != 0 {
+		t.Fatalf("Segment sealed before age threshold, got %d segments", n)
+	}
+
+	clockMu.Lock()
+	now = now.Add(time.Hour)
+	clockMu.Unlock()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for countSegments(t, tmp) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := countSegments(t, tmp); n != 1 {
+		t.Fatalf("Expected 1 segment after age threshold, got %d", n)
+	}
+
+	if value, err := db.Get("k1"); err != nil || value != "v1" {
+		t.Errorf("Get(k1) = %q, %v after rollover", value, err)
+	}
+}
+
+func TestDbRotate(t *testing.T) {
+	tmp := t.TempDir()
+	db, err := Open(tmp, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Put("k1", "v1"); err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := db.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(sealed) != tmp || filepath.Ext(sealed) != ".segment" {
+		t.Errorf("Unexpected sealed segment path %s", sealed)
+	}
+	if info, err := os.Stat(sealed); err != nil || info.Size() == 0 {
+		t.Fatalf("Sealed segment is missing or empty: %v", err)
+	}
+
+	if empty, err := db.Rotate(); err != nil || empty != "" {
+		t.Errorf("Rotate of an empty current-data file = %q, %v; wanted no segment", empty, err)
+	}
+	if n := countSegments(t, tmp); n != 1 {
+		t.Errorf("Expected 1 segment after rotating an empty file, got %d", n)
+	}
+
+	if err := db.Put("k2", "v2"); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filepath.Join(tmp, outFileName)); err != nil || info.Size() != int64(len((&entry{key: "k2", value: "v2"}).Encode())) {
+		t.Errorf("Current-data file should only hold writes made after Rotate (err: %v)", err)
+	}
+
+	for key, expected := range map[string]string{"k1": "v1", "k2": "v2"} {
+		if value, err := db.Get(key); err != nil || value != expected {
+			t.Errorf("Get(%q) = %q, %v; wanted %q", key, value, err, expected)
+		}
+	}
+}
+
+func TestDbCompactNow(t *testing.T) {
+	compact := func(writeDuringMerge bool) int64 {
+		tmp := t.TempDir()
+		// Segments hold two records, leaving room for the write made during
+		// the merge in the current-data file.
+		db, err := OpenWithOptions(tmp, Options{SegmentSize: 120, DisableAutoMerge: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		value := strings.Repeat("x", 30)
+		for i := 0; i < 10; i++ {
+			if err := db.Put("k1", value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if countSegments(t, tmp) < 2 {
+			t.Fatal("Expected several segments before compaction")
+		}
+
+		if writeDuringMerge {
+			origHook := mergeHook
+			mergeHook = func() {
+				if err := db.Put("k2", "v"); err != nil {
+					t.Error(err)
+				}
+			}
+			defer func() { mergeHook = origHook }()
+		}
+		before, err := db.Size()
+		if err != nil {
+			t.Fatal(err)
+		}
+		freed, err := db.CompactNow()
+		if err != nil {
+			t.Fatal(err)
+		}
+		after, err := db.Size()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if freed <= 0 {
+			t.Errorf("CompactNow freed %d bytes, expected a positive count", freed)
+		}
+		if !writeDuringMerge && freed != before-after {
+			t.Errorf("CompactNow freed %d bytes, but size changed by %d", freed, before-after)
+		}
+		if value, err := db.Get("k1"); err != nil || value != strings.Repeat("x", 30) {
+			t.Errorf("Get(k1) after compaction = %q, %v", value, err)
+		}
+		return freed
+	}
+
+	// A write made while the merge runs is not counted against it.
+	if quiet, busy := compact(false), compact(true); quiet != busy {
+		t.Errorf("CompactNow freed %d bytes with a concurrent write, %d without", busy, quiet)
+	}
+}
+
+func TestDbSizeDetailed(t *testing.T) {
+	tmp := t.TempDir()
+	db, err := Open(tmp, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Put("k1", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("k2", "v2"); err != nil {
+		t.Fatal(err)
+	}
+
+	current, segments, total, err := db.SizeDetailed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if segments <= 0 {
+		t.Errorf("segments = %d after a rollover, expected a positive size", segments)
+	}
+	if want := int64(len((&entry{key: "k2", value: "v2"}).Encode())); current != want {
+		t.Errorf("current = %d, expected %d for the write made after the rollover", current, want)
+	}
+	if total != current+segments {
+		t.Errorf("total = %d, expected %d + %d", total, current, segments)
+	}
+	if size, err := db.Size(); err != nil || size != total {
+		t.Errorf("Size() = %d, %v; expected %d", size, err, total)
+	}
+	if stats := db.Stats(); stats.CurrentSize != current || stats.SegmentsSize != segments || stats.DiskSize != total {
+		t.Errorf("Stats() sizes = %d/%d/%d, expected %d/%d/%d",
+			stats.CurrentSize, stats.SegmentsSize, stats.DiskSize, current, segments, total)
+	}
+}
+
+func TestDbDelete(t *testing.T) {
+	tmp := t.TempDir()
+	db, err := OpenWithOptions(tmp, Options{DisableAutoMerge: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	if err := db.Delete("missing"); err != ErrNotFound {
+		t.Errorf("Delete of a missing key returned %v, wanted ErrNotFound", err)
+	}
+
+	for _, key := range []string{"old", "new", "kept"} {
+		if err := db.Put(key, "v-"+key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("new", "v-new2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Delete("old"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("new"); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(stage string) {
+		t.Helper()
+		for _, key := range []string{"old", "new"} {
+			if _, err := db.Get(key); err != ErrNotFound {
+				t.Errorf("%s: Get(%q) returned %v, wanted ErrNotFound", stage, key, err)
+			}
+		}
+		if value, err := db.Get("kept"); err != nil || value != "v-kept" {
+			t.Errorf("%s: Get(kept) = %q, %v", stage, value, err)
+		}
+	}
+	check("after delete")
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = OpenWithOptions(tmp, Options{DisableAutoMerge: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("after reopen")
+
+	if _, err := db.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CompactNow(); err != nil {
+		t.Fatal(err)
+	}
+	check("after merge")
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = OpenWithOptions(tmp, Options{DisableAutoMerge: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("after reopening the merged store")
+}
+
+func TestDbConcurrentDelete(t *testing.T) {
+	for _, opts := range []Options{{}, {WriteBuffer: 1 << 10}} {
+		db, err := OpenWithOptions(t.TempDir(), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = db.Close() })
+
+		for round := 0; round < 20; round++ {
+			if err := db.Put("k", "v"); err != nil {
+				t.Fatal(err)
+			}
+			var deleted atomic.Int64
+			var wg sync.WaitGroup
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					switch err := db.Delete("k"); err {
+					case nil:
+						deleted.Add(1)
+					case ErrNotFound:
+					default:
+						t.Error(err)
+					}
+				}()
+			}
+			wg.Wait()
+			if n := deleted.Load(); n != 1 {
+				t.Fatalf("WriteBuffer %d: %d concurrent Deletes of one key succeeded, wanted 1", opts.WriteBuffer, n)
+			}
+		}
+	}
+}
+
+func TestDbChecksumRecovery(t *testing.T) {
+	corrupt := func(t *testing.T, path string, offset int64) {
+		t.Helper()
+		f, err := os.OpenFile(path, os.O_RDWR, 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteAt([]byte{0xff}, offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recordSize := int64(len((&entry{key: "k1", value: "v1"}).Encode()))
+
+	t.Run("tail", func(t *testing.T) {
+		tmp := t.TempDir()
+		db, err := Open(tmp, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range []string{"k1", "k2"} {
+			if err := db.Put(key, "v"+key[1:]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		db.Close()
+		corrupt(t, filepath.Join(tmp, outFileName), recordSize+recordSize-2)
+
+		db, err = Open(tmp, 0)
+		if err != nil {
+			t.Fatalf("Corrupted last record should be dropped, got %v", err)
+		}
+		defer db.Close()
+		if value, err := db.Get("k1"); err != nil || value != "v1" {
+			t.Errorf("Get(k1) = %q, %v", value, err)
+		}
+		if _, err := db.Get("k2"); err != ErrNotFound {
+			t.Errorf("Get(k2) returned %v, expected the torn record to be dropped", err)
+		}
+		if err := db.Put("k3", "v3"); err != nil {
+			t.Fatal(err)
+		}
+		if value, err := db.Get("k3"); err != nil || value != "v3" {
+			t.Errorf("Get(k3) = %q, %v after writing past a torn tail", value, err)
+		}
+	})
+
+	t.Run("middle", func(t *testing.T) {
+		tmp := t.TempDir()
+		db, err := Open(tmp, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range []string{"k1", "k2"} {
+			if err := db.Put(key, "v"+key[1:]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		db.Close()
+		corrupt(t, filepath.Join(tmp, outFileName), recordSize-2)
+
+		if db, err := Open(tmp, 0); !errors.Is(err, ErrChecksumMismatch) {
+			if err == nil {
+				db.Close()
+			}
+			t.Errorf("Open with a corrupted middle record returned %v, expected ErrChecksumMismatch", err)
+		}
+	})
+}
+
+func TestDbReadWorkers(t *testing.T) {
+	for workers, expected := range map[int]int{3: 3, 0: runtime.NumCPU() * 2, -1: runtime.NumCPU() * 2} {
+		db, err := OpenWithOptions(t.TempDir(), Options{ReadWorkers: workers})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := db.readerPool.(*readWorkerPool).workers; n != expected {
+			t.Errorf("ReadWorkers %d started %d workers, expected %d", workers, n, expected)
+		}
+		if err := db.Put("k", "v"); err != nil {
+			t.Fatal(err)
+		}
+		if value, err := db.Get("k"); err != nil || value != "v" {
+			t.Errorf("Get(k) = %q, %v", value, err)
+		}
+		db.Close()
+	}
+}
+
+func TestDbContext(t *testing.T) {
+	db, err := Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	if err := db.PutContext(ctx, "k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := db.GetContext(ctx, "k"); err != nil || value != "v" {
+		t.Errorf("GetContext(k) = %q, %v", value, err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := db.GetContext(cancelled, "k"); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetContext with a cancelled context returned %v", err)
+	}
+	if err := db.PutContext(cancelled, "k", "v2"); !errors.Is(err, context.Canceled) {
+		t.Errorf("PutContext with a cancelled context returned %v", err)
+	}
+	if value, err := db.Get("k"); err != nil || value != "v" {
+		t.Errorf("Cancelled PutContext changed the value: %q, %v", value, err)
+	}
+}
+
+func TestDbOnMergeError(t *testing.T) {
+	tmp := t.TempDir()
+	errs := make(chan error, 1)
+	db, err := OpenWithOptions(tmp, Options{
+		DisableAutoMerge: true,
+		OnMergeError:     func(err error) { errs <- err },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, key := range []string{"k1", "k2"} {
+		if err := db.Put(key, "v"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A directory in place of the merge temp file makes the merge fail.
+	if err := os.Mkdir(filepath.Join(tmp, "merge.tmp"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	db.MergeSegments()
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "merge.tmp") {
+			t.Errorf("Merge error %q does not name the file involved", err)
+		}
+	default:
+		t.Fatal("OnMergeError was not called for a failed merge")
+	}
+	if n := countSegments(t, tmp); n != 2 {
+		t.Errorf("Failed merge changed the segments, %d left", n)
+	}
+}
+
+func TestDbMergeDoesNotBlock(t *testing.T) {
+	tmp := t.TempDir()
+	db, err := OpenWithOptions(tmp, Options{DisableAutoMerge: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 10; j++ {
+			if err := db.Put(fmt.Sprintf("key%d", j), fmt.Sprintf("value%d-%d", j, i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := db.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	paused, resume := make(chan struct{}), make(chan struct{})
+	origHook := mergeHook
+	mergeHook = func() {
+		close(paused)
+		<-resume
+	}
+	defer func() { mergeHook = origHook }()
+
+	merged := make(chan error)
+	go func() {
+		_, err := db.CompactNow()
+		merged <- err
+	}()
+	<-paused
+
+	const bound = 200 * time.Millisecond
+	timed := func(name string, op func() error) {
+		t.Helper()
+		start := time.Now()
+		if err := op(); err != nil {
+			t.Errorf("%s during merge: %v", name, err)
+		}
+		if elapsed := time.Since(start); elapsed > bound {
+			t.Errorf("%s blocked for %s during merge", name, elapsed)
+		}
+	}
+	timed("Get", func() error {
+		_, err := db.Get("key1")
+		return err
+	})
+	timed("Put", func() error { return db.Put("key1", "during-merge") })
+	timed("Delete", func() error { return db.Delete("key2") })
+	timed("Put+Rotate", func() error {
+		if err := db.Put("key3", "sealed-during-merge"); err != nil {
+			return err
+		}
+		_, err := db.Rotate()
+		return err
+	})
+
+	close(resume)
+	if err := <-merged; err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(stage string) {
+		t.Helper()
+		for key, expected := range map[string]string{
+			"key1": "during-merge",
+			"key3": "sealed-during-merge",
+			"key4": "value4-2",
+		} {
+			if value, err := db.Get(key); err != nil || value != expected {
+				t.Errorf("%s: Get(%q) = %q, %v; wanted %q", stage, key, value, err, expected)
+			}
+		}
+		if _, err := db.Get("key2"); err != ErrNotFound {
+			t.Errorf("%s: key deleted during the merge came back: %v", stage, err)
+		}
+	}
+	check("after merge")
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = OpenWithOptions(tmp, Options{DisableAutoMerge: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("after reopen")
+}
+
+func TestDbMergeRemapsConcurrentWrites(t *testing.T) {
+	advance := fakeClock(t)
+	tmp := t.TempDir()
+	db, err := OpenWithOptions(tmp, Options{DisableAutoMerge: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	expected := make(map[string]string)
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 20; j++ {
+			key, value := fmt.Sprintf("key%d", j), fmt.Sprintf("value%d-%d", j, i)
+			if err := db.Put(key, value); err != nil {
+				t.Fatal(err)
+			}
+			expected[key] = value
+		}
+		if _, err := db.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.PutWithTTL("short", "lived", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	// The key expires before the merge reads it, but isn't swept yet.
+	advance(2 * time.Second)
+
+	origHook := mergeHook
+	mergeHook = func() {
+		for j := 0; j < 20; j += 2 {
+			key, value := fmt.Sprintf("key%d", j), fmt.Sprintf("during-merge%d", j)
+			if err := db.Put(key, value); err != nil {
+				t.Error(err)
+			}
+			expected[key] = value
+			if j%4 == 0 {
+				if _, err := db.Rotate(); err != nil {
+					t.Error(err)
+				}
+			}
+		}
+	}
+	defer func() { mergeHook = origHook }()
+
+	if _, err := db.merge(); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, value := range expected {
+		if got, err := db.Get(key); err != nil || got != value {
+			t.Errorf("Get(%q) = %q, %v; wanted %q", key, got, err, value)
+		}
+	}
+	if _, err := db.Get("short"); err != ErrNotFound {
+		t.Errorf("Get(short) = %v after expiring, wanted ErrNotFound", err)
+	}
+	for _, s := range db.shards {
+		for key, segInfo := range s.segments {
+			if _, err := os.Stat(segInfo.file); err != nil {
+				t.Errorf("key %q points at %s: %v", key, segInfo.file, err)
+			}
+		}
+	}
+}
+
+func TestDbRapidRolloverMerges(t *testing.T) {
+	tmp := t.TempDir()
+	db, err := Open(tmp, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	expected := make(map[string]string)
+	for i := 0; i < 300; i++ {
+		key := fmt.Sprintf("key%d", i%20)
+		value := fmt.Sprintf("value%d", i)
+		if err := db.Put(key, value); err != nil {
+			t.Fatal(err)
+		}
+		expected[key] = value
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for (db.mergeRunning.Load() || db.mergeQueued.Load()) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	db.mergeMu.Lock()
+	db.mergeMu.Unlock()
+
+	if hasMergeTempFiles(t, tmp) {
+		t.Error("Merge temp file left behind")
+	}
+	check := func(stage string) {
+		t.Helper()
+		for key, value := range expected {
+			if got, err := db.Get(key); err != nil || got != value {
+				t.Errorf("%s: Get(%q) = %q, %v; wanted %q", stage, key, got, err, value)
+			}
+		}
+	}
+	check("after merges")
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(tmp, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("after reopen")
+}
+
+func TestDbMergeDeterministic(t *testing.T) {
+	mergeInto := func(dir string) []byte {
+		t.Helper()
+		db, err := OpenWithOptions(dir, Options{DisableAutoMerge: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		for i := 0; i < 3; i++ {
+			for j := 0; j < 50; j++ {
+				if err := db.Put(fmt.Sprintf("key%d", (j*7+i)%40), fmt.Sprintf("value%d-%d", i, j)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := db.Rotate(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := db.CompactNow(); err != nil {
+			t.Fatal(err)
+		}
+
+		segmentFiles, err := db.segmentFiles()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(segmentFiles) != 1 {
+			t.Fatalf("Expected a single merged segment, got %v", segmentFiles)
+		}
+		data, err := os.ReadFile(segmentFiles[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	first, second := mergeInto(t.TempDir()), mergeInto(t.TempDir())
+	if !bytes.Equal(first, second) {
+		t.Error("Merging the same input twice produced different segment files")
+	}
+}
+
+func TestDbRepairOnOpen(t *testing.T) {
+	recordSize := int64(len((&entry{key: "k1", value: "v1"}).Encode()))
+
+	// writeSegments seals one segment per key group and cuts the last record
+	// of the segment numbered truncated short.
+	writeSegments := func(t *testing.T, truncated int, groups ...[]string) string {
+		t.Helper()
+		tmp := t.TempDir()
+		db, err := OpenWithOptions(tmp, Options{DisableAutoMerge: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var segmentFiles []string
+		for _, keys := range groups {
+			for _, key := range keys {
+				if err := db.Put(key, "v"+key[1:]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			segmentFile, err := db.Rotate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			segmentFiles = append(segmentFiles, segmentFile)
+		}
+		db.Close()
+
+		info, err := os.Stat(segmentFiles[truncated])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Truncate(segmentFiles[truncated], info.Size()-3); err != nil {
+			t.Fatal(err)
+		}
+		return tmp
+	}
+
+	t.Run("tail", func(t *testing.T) {
+		tmp := writeSegments(t, 1, []string{"k1"}, []string{"k2", "k3"})
+
+		if _, err := Open(tmp, 0); err == nil {
+			t.Fatal("Open without RepairOnOpen should fail on a partial record")
+		}
+
+		db, err := OpenWithOptions(tmp, Options{RepairOnOpen: true})
+		if err != nil {
+			t.Fatalf("Open with RepairOnOpen failed: %v", err)
+		}
+		defer db.Close()
+		for _, key := range []string{"k1", "k2"} {
+			if value, err := db.Get(key); err != nil || value != "v"+key[1:] {
+				t.Errorf("Get(%s) = %q, %v", key, value, err)
+			}
+		}
+		if _, err := db.Get("k3"); err != ErrNotFound {
+			t.Errorf("Get(k3) returned %v, expected the partial record to be dropped", err)
+		}
+		segmentFiles, err := db.segmentFiles()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info, err := os.Stat(segmentFiles[1]); err != nil || info.Size() != recordSize {
+			t.Errorf("Repaired segment size = %v, %v, expected %d", info.Size(), err, recordSize)
+		}
+	})
+
+	t.Run("middle", func(t *testing.T) {
+		tmp := writeSegments(t, 0, []string{"k1", "k2"}, []string{"k3"})
+
+		if _, err := OpenWithOptions(tmp, Options{RepairOnOpen: true}); err == nil {
+			t.Fatal("A partial record in an older segment should fail Open")
+		}
+	})
+}
+
+func TestDbReadHandlesAfterMerge(t *testing.T) {
+	db, err := OpenWithOptions(t.TempDir(), Options{DisableAutoMerge: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, key := range []string{"k1", "k2"} {
+		if err := db.Put(key, "v"+key[1:]); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+		if value, err := db.Get(key); err != nil || value != "v"+key[1:] {
+			t.Fatalf("Get(%s) = %q, %v", key, value, err)
+		}
+	}
+	if _, err := db.CompactNow(); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"k1", "k2"} {
+		if value, err := db.Get(key); err != nil || value != "v"+key[1:] {
+			t.Errorf("Get(%s) after merge = %q, %v", key, value, err)
+		}
+	}
+
+	handles := db.readerPool.(*readWorkerPool).handles
+	handles.mu.Lock()
+	defer handles.mu.Unlock()
+	for path := range handles.files {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Handle of %s is still cached: %v", path, err)
+		}
+	}
+	if len(handles.files) != 1 {
+		t.Errorf("Expected only the merged segment to be open, got %d handles", len(handles.files))
+	}
+}
+
+func TestSetSegmentSize(t *testing.T) {
+	tmp := t.TempDir()
+	db, err := OpenWithOptions(tmp, Options{DisableAutoMerge: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	value := string(make([]byte, 50))
+	for i := 0; i < 5; i++ {
+		if err := db.Put(fmt.Sprintf("a%d", i), value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := countSegments(t, tmp); n != 0 {
+		t.Fatalf("Expected no segments with segmentation off, got %d", n)
+	}
+
+	if err := db.SetSegmentSize(200); err != nil {
+		t.Fatal(err)
+	}
+	if size := db.SegmentSize(); size != 200 {
+		t.Errorf("SegmentSize() = %d, want 200", size)
+	}
+	for i := 0; i < 6; i++ {
+		if err := db.Put(fmt.Sprintf("b%d", i), value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := filepath.Glob(filepath.Join(tmp, "*.segment"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) < 2 {
+		t.Fatalf("Expected the new size to seal segments, got %d", len(files))
+	}
+	for _, file := range files[1:] {
+		info, err := os.Stat(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > 200 {
+			t.Errorf("Segment %s has %d bytes, more than the new size", file, info.Size())
+		}
+	}
+	for _, key := range []string{"a0", "a4", "b0", "b5"} {
+		if got, err := db.Get(key); err != nil || got != value {
+			t.Errorf("Get(%s) = %q, %v", key, got, err)
+		}
+	}
+
+	if err := db.SetSegmentSize(entryOverhead); err != ErrSegmentTooSmall {
+		t.Errorf("Expected ErrSegmentTooSmall, got %v", err)
+	}
+	if err := db.SetSegmentSize(-1); err == nil {
+		t.Error("Expected an error for a negative size")
+	}
+	if err := db.SetSegmentSize(0); err != nil {
+		t.Fatal(err)
+	}
+	before := countSegments(t, tmp)
+	for i := 0; i < 5; i++ {
+		if err := db.Put(fmt.Sprintf("c%d", i), value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := countSegments(t, tmp); n != before {
+		t.Errorf("Expected zero to stop segmentation, segments went from %d to %d", before, n)
+	}
+}
+
+func TestPutN(t *testing.T) {
+	for _, opts := range []Options{{}, {WriteBuffer: 1024}} {
+		t.Run(fmt.Sprintf("WriteBuffer=%d", opts.WriteBuffer), func(t *testing.T) {
+			db, err := OpenWithOptions(t.TempDir(), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			e := entry{key: "key", value: "value"}
+			if n, err := db.PutN(e.key, e.value); err != nil || n != len(e.Encode()) {
+				t.Errorf("PutN = %d, %v; expected %d", n, err, len(e.Encode()))
+			}
+			if n, err := db.PutN(walMarkerKey, "value"); err != ErrReservedKey || n != 0 {
+				t.Errorf("PutN of the reserved key = %d, %v", n, err)
+			}
+		})
+	}
+}
+
+// TestReadsDuringRollover reads keys with Get and GetMulti while a writer
+// seals the current-data file every few records, so reads resolve offsets
+// right before the file they point into is renamed.
+func TestReadsDuringRollover(t *testing.T) {
+	db, err := OpenWithOptions(t.TempDir(), Options{SegmentSize: 256, MergeMaxSegments: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	const keys = 16
+	keyName := func(i int) string { return fmt.Sprintf("key%d", i) }
+	for i := 0; i < keys; i++ {
+		if err := db.Put(keyName(i), keyName(i)+"-0"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	check := func(key, value string) {
+		if !strings.HasPrefix(value, key+"-") {
+			t.Errorf("Read %q for %s", value, key)
+		}
+	}
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			all := make([]string, keys)
+			for i := range all {
+				all[i] = keyName(i)
+			}
+			for n := 0; ; n++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				key := keyName(n % keys)
+				value, err := db.Get(key)
+				if err != nil {
+					t.Errorf("Get(%s): %v", key, err)
+					return
+				}
+				check(key, value)
+
+				values, err := db.GetMulti(all)
+				if err != nil {
+					t.Errorf("GetMulti: %v", err)
+					return
+				}
+				for key, value := range values {
+					check(key, value)
+				}
+			}
+		}()
+	}
+
+	for round := 1; round <= 200; round++ {
+		for i := 0; i < keys; i++ {
+			if err := db.Put(keyName(i), fmt.Sprintf("%s-%d", keyName(i), round)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	close(done)
+	wg.Wait()
+}
@@ -5,54 +5,147 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
+	"math"
 )
 
 type entry struct {
 	key, value string
+	deleted    bool
+	// expires is the Unix time in nanoseconds after which the entry is
+	// treated as absent. Zero means it never expires.
+	expires int64
+	// compressed marks value as gzip-compressed; see payload.
+	compressed bool
 }
 
-// 0           4    8     kl+8  kl+12     <-- offset
-// (full size) (kl) (key) (vl)  (value)
-// 4           4    ....  4     .....     <-- length
+// 0           4     5    8     kl+8  kl+12         size-4   <-- offset
+// (full size) (ver) (kl) (key) (vl)  (value)  ... (crc32)
+// 4           1     3    ....  4     .....         4        <-- length
+//
+// A tombstone stores tombstoneLen in place of vl and has no value bytes.
+// An entry with an expiry sets expiresFlag in vl and stores the 8-byte
+// deadline between vl and the value. Older files never set the flag.
+// compressedFlag in vl marks a gzip-compressed value.
+//
+// Records of entryVersionChecksum end with the CRC32 of the preceding
+// bytes. Legacy records have a zero version byte and no checksum.
+
+const (
+	tombstoneLen   = math.MaxUint32
+	expiresFlag    = 1 << 31
+	compressedFlag = 1 << 30
+
+	entryVersionLegacy   = 0
+	entryVersionChecksum = 1
+	// entryOverhead is the encoded size of an entry besides its key and value.
+	entryOverhead = 16
+
+	keyLenMask = 1<<24 - 1
+)
+
+var ErrChecksumMismatch = fmt.Errorf("record checksum mismatch")
 
 func (e *entry) Encode() []byte {
 	kl, vl := len(e.key), len(e.value)
-	size := kl + vl + 12
+	if e.deleted {
+		vl = 0
+	}
+	size := kl + vl + entryOverhead
+	if e.expires != 0 && !e.deleted {
+		size += 8
+	}
 	res := make([]byte, size)
 	binary.LittleEndian.PutUint32(res, uint32(size))
-	binary.LittleEndian.PutUint32(res[4:], uint32(kl))
+	binary.LittleEndian.PutUint32(res[4:], uint32(kl)|entryVersionChecksum<<24)
 	copy(res[8:], e.key)
-	binary.LittleEndian.PutUint32(res[kl+8:], uint32(vl))
-	copy(res[kl+12:], e.value)
+	switch {
+	case e.deleted:
+		binary.LittleEndian.PutUint32(res[kl+8:], tombstoneLen)
+	case e.expires != 0:
+		binary.LittleEndian.PutUint32(res[kl+8:], uint32(vl)|expiresFlag|e.flags())
+		binary.LittleEndian.PutUint64(res[kl+12:], uint64(e.expires))
+		copy(res[kl+20:], e.value)
+	default:
+		binary.LittleEndian.PutUint32(res[kl+8:], uint32(vl)|e.flags())
+		copy(res[kl+12:], e.value)
+	}
+	binary.LittleEndian.PutUint32(res[size-4:], crc32.ChecksumIEEE(res[:size-4]))
 	return res
 }
 
 func (e *entry) Decode(input []byte) {
-	e.key = decodeString(input[4:])
-	e.value = decodeString(input[len(e.key)+8:])
+	kl := int(binary.LittleEndian.Uint32(input[4:]) & keyLenMask)
+	e.key = string(input[8 : 8+kl])
+	vl := input[kl+8:]
+	l := binary.LittleEndian.Uint32(vl)
+	e.deleted = l == tombstoneLen
+	e.expires = 0
+	e.compressed = false
+	switch {
+	case e.deleted:
+		e.value = ""
+	case l&expiresFlag != 0:
+		e.expires = int64(binary.LittleEndian.Uint64(vl[4:]))
+		e.compressed = l&compressedFlag != 0
+		e.value = string(vl[12 : 12+l&^(expiresFlag|compressedFlag)])
+	default:
+		e.compressed = l&compressedFlag != 0
+		e.value = string(vl[4 : 4+l&^compressedFlag])
+	}
 }
 
-func decodeString(v []byte) string {
-	l := binary.LittleEndian.Uint32(v)
-	buf := make([]byte, l)
-	copy(buf, v[4:4+int(l)])
-	return string(buf)
+func (e *entry) flags() uint32 {
+	if e.compressed {
+		return compressedFlag
+	}
+	return 0
+}
+
+// verify checks the checksum of an encoded record. Legacy records pass as is.
+func verify(input []byte) error {
+	if len(input) < 12 {
+		return ErrChecksumMismatch
+	}
+	switch input[7] {
+	case entryVersionLegacy:
+		return nil
+	case entryVersionChecksum:
+		if len(input) < entryOverhead {
+			return ErrChecksumMismatch
+		}
+		sum := binary.LittleEndian.Uint32(input[len(input)-4:])
+		if crc32.ChecksumIEEE(input[:len(input)-4]) != sum {
+			return ErrChecksumMismatch
+		}
+		return nil
+	}
+	return ErrChecksumMismatch
 }
 
 func (e *entry) DecodeFromReader(in *bufio.Reader) (int, error) {
 	sizeBuf, err := in.Peek(4)
 	if err != nil {
 		if errors.Is(err, io.EOF) {
+			if len(sizeBuf) > 0 {
+				return len(sizeBuf), io.ErrUnexpectedEOF
+			}
 			return 0, err
 		}
 		return 0, fmt.Errorf("DecodeFromReader, cannot read size: %w", err)
 	}
 	buf := make([]byte, int(binary.LittleEndian.Uint32(sizeBuf)))
-	n, err := in.Read(buf[:])
+	n, err := io.ReadFull(in, buf)
 	if err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return n, io.ErrUnexpectedEOF
+		}
 		return n, fmt.Errorf("DecodeFromReader, cannot read record: %w", err)
 	}
+	if err := verify(buf); err != nil {
+		return n, err
+	}
 	e.Decode(buf)
 	return n, nil
 }
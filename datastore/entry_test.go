@@ -7,7 +7,7 @@ import (
 )
 
 func TestEntry_Encode(t *testing.T) {
-	e := entry{"key", "value"}
+	e := entry{key: "key", value: "value"}
 	e.Decode(e.Encode())
 	if e.key != "key" {
 		t.Error("incorrect key")
@@ -21,7 +21,7 @@ func TestReadValue(t *testing.T) {
 	var (
 		a, b entry
 	)
-	a = entry{"key", "test-value"}
+	a = entry{key: "key", value: "test-value"}
 	originalBytes := a.Encode()
 
 	b.Decode(originalBytes)
@@ -43,3 +43,72 @@ func TestReadValue(t *testing.T) {
 		t.Errorf("DecodeFromReader() read %d bytes, expected %d", n, len(originalBytes))
 	}
 }
+
+func TestTombstoneEncoding(t *testing.T) {
+	a := entry{key: "key", deleted: true}
+	encoded := a.Encode()
+	if len(encoded) != len("key")+entryOverhead {
+		t.Errorf("Tombstone encodes to %d bytes, expected %d", len(encoded), len("key")+entryOverhead)
+	}
+
+	var b entry
+	if _, err := b.DecodeFromReader(bufio.NewReader(bytes.NewReader(encoded))); err != nil {
+		t.Fatal(err)
+	}
+	if a != b {
+		t.Errorf("Tombstone Encode/Decode mismatch: %+v != %+v", a, b)
+	}
+}
+
+func TestExpiryEncoding(t *testing.T) {
+	a := entry{key: "key", value: "value", expires: 1_700_000_000_000_000_000}
+
+	var b entry
+	if _, err := b.DecodeFromReader(bufio.NewReader(bytes.NewReader(a.Encode()))); err != nil {
+		t.Fatal(err)
+	}
+	if a != b {
+		t.Errorf("Expiring entry Encode/Decode mismatch: %+v != %+v", a, b)
+	}
+
+	plain := entry{key: "key", value: "value"}
+	b.Decode(plain.Encode())
+	if b != plain {
+		t.Errorf("Entry without expiry decoded as %+v", b)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	e := entry{key: "key", value: "value"}
+	encoded := e.Encode()
+	encoded[9] ^= 0xff
+
+	var b entry
+	if _, err := b.DecodeFromReader(bufio.NewReader(bytes.NewReader(encoded))); err != ErrChecksumMismatch {
+		t.Errorf("Corrupted record decoded with %v, expected ErrChecksumMismatch", err)
+	}
+
+	// Legacy records carry a zero version byte and no checksum.
+	legacy := []byte{18, 0, 0, 0, 3, 0, 0, 0, 'k', 'e', 'y', 3, 0, 0, 0, 'o', 'l', 'd'}
+	n, err := b.DecodeFromReader(bufio.NewReader(bytes.NewReader(legacy)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(legacy) || b.key != "key" || b.value != "old" {
+		t.Errorf("Legacy record decoded as %+v (%d bytes)", b, n)
+	}
+}
+
+func TestCompressedFlagEncoding(t *testing.T) {
+	for _, a := range []entry{
+		{key: "k1", value: "packed", compressed: true},
+		{key: "k2", value: "plain"},
+		{key: "k3", value: "packed", compressed: true, expires: 42},
+	} {
+		var b entry
+		b.Decode(a.Encode())
+		if a != b {
+			t.Errorf("Encode/Decode mismatch: %+v != %+v", a, b)
+		}
+	}
+}
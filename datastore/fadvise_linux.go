@@ -0,0 +1,29 @@
+//go:build linux && (amd64 || arm64)
+
+package datastore
+
+import (
+	"os"
+	"syscall"
+)
+
+const (
+	fadviseSupported = true
+
+	fadvWillNeed = 3
+	fadvDontNeed = 4
+)
+
+func fadvise(path string, advice int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, _, errno := syscall.Syscall6(syscall.SYS_FADVISE64, f.Fd(), 0, 0, uintptr(advice), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build !(linux && (amd64 || arm64))
+
+package datastore
+
+const (
+	fadviseSupported = false
+
+	fadvWillNeed = 3
+	fadvDontNeed = 4
+)
+
+// fadvise is a no-op on platforms without posix_fadvise support.
+func fadvise(string, int) error {
+	return nil
+}
@@ -0,0 +1,121 @@
+package datastore
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestPinMergedSegments(t *testing.T) {
+	for _, pin := range []bool{true, false} {
+		t.Run(fmt.Sprintf("pin=%v", pin), func(t *testing.T) {
+			advice := make(map[string]int)
+			origAdvise := adviseFile
+			adviseFile = func(path string, a int) error {
+				advice[path] = a
+				return origAdvise(path, a)
+			}
+			defer func() { adviseFile = origAdvise }()
+
+			db, err := OpenWithOptions(t.TempDir(), Options{SegmentSize: 100, PinMergedSegments: pin, DisableAutoMerge: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			value := strings.Repeat("x", 40)
+			for i := 0; i < 10; i++ {
+				if err := db.Put(fmt.Sprintf("key%d", i), value); err != nil {
+					t.Fatal(err)
+				}
+			}
+			inputs, err := db.segmentFiles()
+			if err != nil || len(inputs) < 2 {
+				t.Fatalf("Expected segments to merge, got %v, %v", inputs, err)
+			}
+			if _, err := db.CompactNow(); err != nil {
+				t.Fatal(err)
+			}
+			merged, err := db.segmentFiles()
+			if err != nil || len(merged) != 1 {
+				t.Fatalf("Expected one merged segment, got %v, %v", merged, err)
+			}
+
+			if !pin {
+				if len(advice) != 0 {
+					t.Errorf("Expected no page cache advice without the option, got %v", advice)
+				}
+			} else {
+				for _, input := range inputs {
+					if advice[input] != fadvDontNeed {
+						t.Errorf("Expected DONTNEED for merged input %s, got %v", input, advice[input])
+					}
+				}
+				if advice[merged[0]] != fadvWillNeed {
+					t.Errorf("Expected WILLNEED for merged segment %s, got %v", merged[0], advice[merged[0]])
+				}
+			}
+
+			for i := 0; i < 10; i++ {
+				key := fmt.Sprintf("key%d", i)
+				if got, err := db.Get(key); err != nil || got != value {
+					t.Errorf("Get(%q) = %q, %v", key, got, err)
+				}
+			}
+		})
+	}
+}
+
+func TestFadviseUnsupportedIsNoop(t *testing.T) {
+	if fadviseSupported {
+		t.Skip("posix_fadvise is supported on this platform")
+	}
+	if err := fadvise(t.TempDir()+"/missing", fadvWillNeed); err != nil {
+		t.Errorf("fadvise should be a no-op on unsupported platforms, got %v", err)
+	}
+}
+
+// BenchmarkSegmentReadCache compares reads right after the merged segment
+// was dropped from the page cache with reads after it was pinned.
+func BenchmarkSegmentReadCache(b *testing.B) {
+	db, err := OpenWithOptions(b.TempDir(), Options{SegmentSize: 4096})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+
+	value := strings.Repeat("x", 512)
+	for i := 0; i < 256; i++ {
+		if err := db.Put(fmt.Sprintf("key%d", i), value); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if _, err := db.CompactNow(); err != nil {
+		b.Fatal(err)
+	}
+	segmentFiles, err := db.segmentFiles()
+	if err != nil || len(segmentFiles) == 0 {
+		b.Fatal("no segments to read", err)
+	}
+
+	for _, bc := range []struct {
+		name   string
+		advice int
+	}{
+		{"cold", fadvDontNeed},
+		{"warm", fadvWillNeed},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				for _, segmentFile := range segmentFiles {
+					_ = fadvise(segmentFile, bc.advice)
+				}
+				b.StartTimer()
+				if _, err := db.Get(fmt.Sprintf("key%d", i%256)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
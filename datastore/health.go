@@ -0,0 +1,21 @@
+package datastore
+
+import "os"
+
+// Check reports whether the store can take writes. It fails with
+// ErrReadOnly for a read-only store, and with the file error once the store
+// is closed or its current-data file was removed. It only stats the file,
+// so it is cheap enough to poll.
+func (db *Db) Check() error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if _, err := db.out.Stat(); err != nil {
+		return err
+	}
+	_, err := os.Stat(db.out.Name())
+	return err
+}
@@ -0,0 +1,51 @@
+package datastore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	db, err := OpenWithOptions(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Check(); err != nil {
+		t.Errorf("Check of an open store: %v", err)
+	}
+
+	if err := os.Remove(filepath.Join(dir, outFileName)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Check(); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Check without a current-data file: got %v, want os.ErrNotExist", err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Check(); err == nil {
+		t.Error("Check of a closed store should fail")
+	}
+}
+
+func TestCheckReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	db, err := OpenWithOptions(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	ro, err := OpenWithOptions(dir, WithReadOnly(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+	if err := ro.Check(); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("got %v, want ErrReadOnly", err)
+	}
+}
@@ -0,0 +1,226 @@
+package datastore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+var errBadHint = fmt.Errorf("hint file is corrupted or stale")
+
+// hintRecord is the newest record of a key within one segment.
+type hintRecord struct {
+	key     string
+	offset  int64
+	expires int64
+	deleted bool
+}
+
+// A hint file sits next to its segment and lists the newest record of every
+// key in it, so recovery can skip reading the segment body:
+//
+//	(version) (segment size) (records) [(kl) (key) (offset) (expires) (deleted)]... (crc32)
+//	4         8              8          4    ....  8        8         1             4
+//
+// records counts every record in the segment, overwritten ones included, for
+// the merge policy. The segment size guards against a hint outliving a
+// replaced segment. Older hint files start with the segment size instead and
+// fail the version check, so they are rebuilt from their segment.
+
+const hintVersion = 2
+
+func hintPath(segmentFile string) string {
+	return strings.TrimSuffix(segmentFile, ".segment") + ".hint"
+}
+
+// partialRecordError reports a segment that ends in the middle of a record.
+type partialRecordError struct {
+	file   string
+	offset int64
+}
+
+func (e *partialRecordError) Error() string {
+	return fmt.Sprintf("corrupted segment file: %s has a partial record at offset %d", e.file, e.offset)
+}
+
+// repair truncates the partial record so only complete records remain.
+func (e *partialRecordError) repair() error {
+	info, err := os.Stat(e.file)
+	if err != nil {
+		return err
+	}
+	if err := os.Truncate(e.file, e.offset); err != nil {
+		return err
+	}
+	log.Printf("datastore: discarded %d bytes of a partial record at the end of %s", info.Size()-e.offset, e.file)
+	return nil
+}
+
+// segmentHints reads segmentFile and returns the newest record of every key
+// and the number of records in it. If the file ends in a partial record, the
+// hints of the records before it are returned along with a
+// *partialRecordError.
+func segmentHints(segmentFile string) ([]hintRecord, int, error) {
+	f, err := os.Open(segmentFile)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	in := bufio.NewReader(f)
+	var offset int64
+	positions := make(map[string]int)
+	var hints []hintRecord
+	records := 0
+
+	for {
+		var record entry
+		n, err := record.DecodeFromReader(in)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return hints, records, &partialRecordError{file: segmentFile, offset: offset}
+		}
+		if tornTail(err, in) {
+			break
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s at offset %d: %w", segmentFile, offset, err)
+		}
+
+		if record.key != walMarkerKey {
+			records++
+			hint := hintRecord{key: record.key, offset: offset, expires: record.expires, deleted: record.deleted}
+			if i, ok := positions[record.key]; ok {
+				hints[i] = hint
+			} else {
+				positions[record.key] = len(hints)
+				hints = append(hints, hint)
+			}
+		}
+		offset += int64(n)
+	}
+	return hints, records, nil
+}
+
+// writeHintFile stores hints and the record count of segmentFile, replacing
+// any previous hint file.
+func writeHintFile(segmentFile string, hints []hintRecord, records int) error {
+	info, err := os.Stat(segmentFile)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	buf.Write(binary.LittleEndian.AppendUint32(nil, hintVersion))
+	buf.Write(binary.LittleEndian.AppendUint64(nil, uint64(info.Size())))
+	buf.Write(binary.LittleEndian.AppendUint64(nil, uint64(records)))
+	for _, h := range hints {
+		b := binary.LittleEndian.AppendUint32(nil, uint32(len(h.key)))
+		b = append(b, h.key...)
+		b = binary.LittleEndian.AppendUint64(b, uint64(h.offset))
+		b = binary.LittleEndian.AppendUint64(b, uint64(h.expires))
+		if h.deleted {
+			b = append(b, 1)
+		} else {
+			b = append(b, 0)
+		}
+		buf.Write(b)
+	}
+	buf.Write(binary.LittleEndian.AppendUint32(nil, crc32.ChecksumIEEE(buf.Bytes())))
+
+	path := hintPath(segmentFile)
+	tempFile := path + ".new"
+	if err := os.WriteFile(tempFile, buf.Bytes(), 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tempFile, path); err != nil {
+		os.Remove(tempFile)
+		return err
+	}
+	return nil
+}
+
+// readHintFile loads the hints and the record count of segmentFile. It fails
+// with errBadHint if the hint file does not match the segment.
+func readHintFile(segmentFile string) ([]hintRecord, int, error) {
+	data, err := os.ReadFile(hintPath(segmentFile))
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := os.Stat(segmentFile)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(data) < 24 {
+		return nil, 0, errBadHint
+	}
+	body, sum := data[:len(data)-4], binary.LittleEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum || binary.LittleEndian.Uint32(body) != hintVersion ||
+		int64(binary.LittleEndian.Uint64(body[4:])) != info.Size() {
+		return nil, 0, errBadHint
+	}
+	records := int(binary.LittleEndian.Uint64(body[12:]))
+
+	var hints []hintRecord
+	for body = body[20:]; len(body) > 0; {
+		if len(body) < 4 {
+			return nil, 0, errBadHint
+		}
+		kl := int(binary.LittleEndian.Uint32(body))
+		if len(body) < 4+kl+17 {
+			return nil, 0, errBadHint
+		}
+		body = body[4:]
+		hints = append(hints, hintRecord{
+			key:     string(body[:kl]),
+			offset:  int64(binary.LittleEndian.Uint64(body[kl:])),
+			expires: int64(binary.LittleEndian.Uint64(body[kl+8:])),
+			deleted: body[kl+16] == 1,
+		})
+		body = body[kl+17:]
+	}
+	return hints, records, nil
+}
+
+// writeSealedHint builds the hint and bloom files of a freshly sealed segment
+// in the background, so sealing doesn't wait for a scan of the whole file.
+func (db *Db) writeSealedHint(segmentFile string) {
+	defer db.bg.Done()
+
+	sealed, err := os.Stat(segmentFile)
+	if err != nil {
+		return
+	}
+	hints, records, err := segmentHints(segmentFile)
+	if err == nil {
+		err = writeHintFile(segmentFile, hints, records)
+	}
+	var filter *bloomFilter
+	if err == nil {
+		filter, err = writeBloomFile(segmentFile, hints)
+	}
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("datastore: writing hint file for %s failed: %s", segmentFile, err)
+		}
+		return
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	// Segments are removed or replaced under the lock, so this sees whether
+	// the files still describe a live segment.
+	if current, err := os.Stat(segmentFile); err == nil && os.SameFile(sealed, current) {
+		db.hinted[segmentFile] = true
+		db.blooms[segmentFile] = filter
+	}
+}
@@ -0,0 +1,123 @@
+package datastore
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestHintFiles(t *testing.T) {
+	tmp := t.TempDir()
+	opts := Options{DisableAutoMerge: true}
+	db, err := OpenWithOptions(tmp, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"k1", "k2", "k3"} {
+		if err := db.Put(key, "old-"+key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Delete("k2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutWithTTL("k3", "new-k3", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := db.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	hints, records, err := readHintFile(sealed)
+	if err != nil {
+		t.Fatalf("Sealed segment has no valid hint file: %v", err)
+	}
+	if len(hints) != 3 {
+		t.Errorf("Expected 3 hints, got %+v", hints)
+	}
+	if records != 5 {
+		t.Errorf("Expected the hint file to count 5 records, got %d", records)
+	}
+
+	// Damage a record in the middle of the segment: a full scan would now
+	// fail, so a successful Open shows the hint file was used.
+	f, err := os.OpenFile(sealed, os.O_RDWR, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte{0xff}, 10); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	db, err = OpenWithOptions(tmp, opts)
+	if err != nil {
+		t.Fatalf("Open did not use the hint file: %v", err)
+	}
+	if _, err := db.Get("k2"); err != ErrNotFound {
+		t.Errorf("Deleted key recovered from hints, Get returned %v", err)
+	}
+	if value, err := db.Get("k3"); err != nil || value != "new-k3" {
+		t.Errorf("Get(k3) = %q, %v", value, err)
+	}
+	if _, ok := db.shard("k3").expiries["k3"]; !ok {
+		t.Error("Expiry was not recovered from hints")
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A corrupted hint file falls back to scanning the segment.
+	hintData, err := os.ReadFile(hintPath(sealed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hintData[len(hintData)-1] ^= 0xff
+	if err := os.WriteFile(hintPath(sealed), hintData, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if db, err := OpenWithOptions(tmp, opts); !errors.Is(err, ErrChecksumMismatch) {
+		if err == nil {
+			db.Close()
+		}
+		t.Errorf("Open with a bad hint file returned %v, expected a full scan to hit the damaged record", err)
+	}
+}
+
+func TestHintFilesAfterMerge(t *testing.T) {
+	tmp := t.TempDir()
+	db, err := OpenWithOptions(tmp, Options{DisableAutoMerge: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	for _, key := range []string{"k1", "k2"} {
+		if err := db.Put(key, "v"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.CompactNow(); err != nil {
+		t.Fatal(err)
+	}
+
+	segmentFiles, err := db.segmentFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segmentFiles) != 1 {
+		t.Fatalf("Expected 1 merged segment, got %v", segmentFiles)
+	}
+	if hints, records, err := readHintFile(segmentFiles[0]); err != nil || len(hints) != 2 || records != 2 {
+		t.Errorf("Merged segment hints = %+v, %d records, %v", hints, records, err)
+	}
+}
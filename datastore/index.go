@@ -0,0 +1,57 @@
+package datastore
+
+import "sync"
+
+// indexShards is the number of independently locked parts of the key index.
+const indexShards = 32
+
+// indexShard locates the keys that hash to it. Its maps are only accessed
+// with db.mu held: a holder of the write lock has every shard to itself,
+// while a holder of the read lock must also take the shard's mu. Puts hold
+// db.mu for reading, so a Put only blocks reads of keys in its own shard.
+type indexShard struct {
+	mu sync.RWMutex
+	// index holds the keys whose newest record is in the current-data file.
+	index hashIndex
+	// segments holds the keys whose newest record is in a sealed segment.
+	segments map[string]*segmentInfo
+	// expiries holds the deadlines of keys written with a TTL.
+	expiries map[string]int64
+	// versions holds the versions of the keys written since Open.
+	versions map[string]uint64
+}
+
+func newIndexShards() (shards [indexShards]*indexShard) {
+	for i := range shards {
+		shards[i] = &indexShard{
+			index:    make(hashIndex),
+			segments: make(map[string]*segmentInfo),
+			expiries: make(map[string]int64),
+			versions: make(map[string]uint64),
+		}
+	}
+	return shards
+}
+
+// shard returns the shard of key, picked by its FNV-1a hash.
+func (db *Db) shard(key string) *indexShard {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return db.shards[h%indexShards]
+}
+
+// rlockShards read-locks every shard in order, giving a holder of the db.mu
+// read lock a consistent view of the whole index until unlock is called.
+func (db *Db) rlockShards() (unlock func()) {
+	for _, s := range db.shards {
+		s.mu.RLock()
+	}
+	return func() {
+		for _, s := range db.shards {
+			s.mu.RUnlock()
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package datastore
+
+import "sort"
+
+// Exists reports whether key holds a live value, without reading it.
+func (db *Db) Exists(key string) bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.existsLocked(key)
+}
+
+// existsLocked is Exists for a holder of db.mu.
+func (db *Db) existsLocked(key string) bool {
+	s := db.shard(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, inSegments := s.segments[key]
+	_, inIndex := s.index[key]
+	return (inSegments || inIndex) && !s.expired(key)
+}
+
+// Keys returns the sorted live keys of the store.
+func (db *Db) Keys() []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	defer db.rlockShards()()
+
+	keys := []string{}
+	for _, s := range db.shards {
+		for key := range s.segments {
+			if !s.expired(key) {
+				keys = append(keys, key)
+			}
+		}
+		for key := range s.index {
+			if _, dup := s.segments[key]; !dup && !s.expired(key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,53 @@
+package datastore
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDbExistsAndKeys(t *testing.T) {
+	db, err := OpenWithOptions(t.TempDir(), Options{DisableAutoMerge: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, key := range []string{"b", "a"} {
+		if err := db.Put(key, "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"c", "a"} {
+		if err := db.Put(key, "v2"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.PutWithTTL("gone", "v", time.Nanosecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+
+	if keys := db.Keys(); !slices.Equal(keys, []string{"a", "b", "c"}) {
+		t.Errorf("Keys() = %v, expected [a b c]", keys)
+	}
+	if !db.Exists("b") || !db.Exists("c") {
+		t.Error("Exists should report keys in segments and the current file")
+	}
+	if db.Exists("missing") || db.Exists("gone") {
+		t.Error("Exists should not report missing or expired keys")
+	}
+
+	if err := db.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	if db.Exists("b") {
+		t.Error("Exists(b) after Delete")
+	}
+	if keys := db.Keys(); !slices.Equal(keys, []string{"a", "c"}) {
+		t.Errorf("Keys() after Delete = %v, expected [a c]", keys)
+	}
+}
@@ -0,0 +1,40 @@
+package datastore
+
+import (
+	"cmp"
+	"fmt"
+)
+
+var (
+	ErrKeyTooLong   = fmt.Errorf("key is too long")
+	ErrValueTooLong = fmt.Errorf("value is too long")
+)
+
+// maxValueLen is the longest value the record format can hold: its length
+// shares a 32-bit field with the expiry and compression flags.
+const maxValueLen = compressedFlag - 1
+
+// sizeLimits holds the longest key and value a store takes.
+type sizeLimits struct {
+	key, value int
+}
+
+func newSizeLimits(opts Options) sizeLimits {
+	return sizeLimits{
+		key:   min(cmp.Or(opts.MaxKeyLen, keyLenMask), keyLenMask),
+		value: min(cmp.Or(opts.MaxValueLen, maxValueLen), maxValueLen),
+	}
+}
+
+// check rejects a write of value under key that the store doesn't take.
+func (l sizeLimits) check(key, value string) error {
+	switch {
+	case key == walMarkerKey:
+		return ErrReservedKey
+	case len(key) > l.key:
+		return fmt.Errorf("%w: %d bytes, the limit is %d", ErrKeyTooLong, len(key), l.key)
+	case len(value) > l.value:
+		return fmt.Errorf("%w: %d bytes, the limit is %d", ErrValueTooLong, len(value), l.value)
+	}
+	return nil
+}
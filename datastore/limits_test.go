@@ -0,0 +1,49 @@
+package datastore
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSizeLimits(t *testing.T) {
+	db, err := OpenWithOptions(t.TempDir(), Options{MaxKeyLen: 8, MaxValueLen: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, tc := range []struct {
+		key, value string
+		err        error
+	}{
+		{strings.Repeat("k", 8), "v", nil},
+		{strings.Repeat("k", 9), "v", ErrKeyTooLong},
+		{"key", strings.Repeat("v", 16), nil},
+		{"key", strings.Repeat("v", 17), ErrValueTooLong},
+	} {
+		if err := db.Put(tc.key, tc.value); !errors.Is(err, tc.err) {
+			t.Errorf("Put of a %d byte key and %d byte value returned %v, expected %v", len(tc.key), len(tc.value), err, tc.err)
+		}
+		if err := db.PutBatch(map[string]string{"other": "v", tc.key: tc.value}); !errors.Is(err, tc.err) {
+			t.Errorf("PutBatch of a %d byte key and %d byte value returned %v, expected %v", len(tc.key), len(tc.value), err, tc.err)
+		}
+		if _, err := db.CompareAndSwap(tc.key, "", tc.value); tc.err != nil && !errors.Is(err, tc.err) {
+			t.Errorf("CompareAndSwap of a %d byte key and %d byte value returned %v, expected %v", len(tc.key), len(tc.value), err, tc.err)
+		}
+		err := db.Transaction(func(tx *Tx) error {
+			tx.Put(tc.key, tc.value)
+			return nil
+		})
+		if !errors.Is(err, tc.err) {
+			t.Errorf("Transaction of a %d byte key and %d byte value returned %v, expected %v", len(tc.key), len(tc.value), err, tc.err)
+		}
+	}
+
+	if db.Exists(strings.Repeat("k", 9)) {
+		t.Error("An oversize key was written")
+	}
+	if value, err := db.Get("other"); err != nil || value != "v" {
+		t.Errorf("Get(other) = %q, %v", value, err)
+	}
+}
@@ -0,0 +1,30 @@
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const lockFileName = ".lock"
+
+var (
+	ErrLocked   = fmt.Errorf("data directory is locked by another store")
+	ErrReadOnly = fmt.Errorf("store is opened read-only")
+)
+
+// lockRetryInterval is how often a waiting Open retries a held lock.
+var lockRetryInterval = 50 * time.Millisecond
+
+// acquireLock takes the directory lock, waiting up to wait for a current
+// holder to release it.
+func acquireLock(path string, wait time.Duration) (*dirLock, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		l, err := tryLock(path)
+		if !errors.Is(err, ErrLocked) || !time.Now().Before(deadline) {
+			return l, err
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
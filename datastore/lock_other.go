@@ -0,0 +1,16 @@
+//go:build !unix
+
+package datastore
+
+const lockSupported = false
+
+// dirLock is a no-op where flock is unavailable.
+type dirLock struct{}
+
+func tryLock(string) (*dirLock, error) {
+	return &dirLock{}, nil
+}
+
+func (l *dirLock) release() error {
+	return nil
+}
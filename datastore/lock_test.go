@@ -0,0 +1,70 @@
+package datastore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOpenLockedDirectory(t *testing.T) {
+	if !lockSupported {
+		t.Skip("directory locking is not supported on this platform")
+	}
+	tmp := t.TempDir()
+	db, err := Open(tmp, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("k", "v"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(tmp, 0); err != ErrLocked {
+		t.Fatalf("Second Open = %v, expected ErrLocked", err)
+	}
+
+	ro, err := OpenWithOptions(tmp, Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("Read-only Open of a locked directory failed: %v", err)
+	}
+	if value, err := ro.Get("k"); err != nil || value != "v" {
+		t.Errorf("Read-only Get(k) = %q, %v", value, err)
+	}
+	if err := ro.Put("k", "v2"); err != ErrReadOnly {
+		t.Errorf("Read-only Put = %v, expected ErrReadOnly", err)
+	}
+	if err := ro.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = db.Close()
+	}()
+	waited, err := OpenWithOptions(tmp, Options{LockWait: 2 * time.Second})
+	if err != nil {
+		t.Fatalf("Open with LockWait should succeed once the lock is released: %v", err)
+	}
+	if err := waited.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenStaleLockFile(t *testing.T) {
+	if !lockSupported {
+		t.Skip("directory locking is not supported on this platform")
+	}
+	tmp := t.TempDir()
+	// A crashed owner leaves the lock file behind but not the flock on it.
+	if err := os.WriteFile(filepath.Join(tmp, lockFileName), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	db, err := Open(tmp, 0)
+	if err != nil {
+		t.Fatalf("Open with a stale lock file failed: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
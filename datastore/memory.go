@@ -0,0 +1,160 @@
+package datastore
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// MemStore is a Store that keeps its keys in a map and never touches the
+// disk, for tests of code built on a store. It has no segments, merges or
+// durability; of the Options, only MaxKeyLen and MaxValueLen apply.
+type MemStore struct {
+	mu     sync.RWMutex
+	values map[string]string
+	limits sizeLimits
+}
+
+// OpenMemory returns an empty MemStore.
+func OpenMemory(options ...Option) *MemStore {
+	var opts Options
+	for _, o := range options {
+		o.apply(&opts)
+	}
+	return &MemStore{values: make(map[string]string), limits: newSizeLimits(opts)}
+}
+
+func (m *MemStore) Get(key string) (string, error) {
+	return m.GetContext(context.Background(), key)
+}
+
+func (m *MemStore) GetContext(ctx context.Context, key string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	value, ok := m.values[key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+// GetMulti returns the values of keys, with a KeyErrors listing the missing
+// ones like Db.GetMulti.
+func (m *MemStore) GetMulti(keys []string) (map[string]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	values := make(map[string]string, len(keys))
+	errs := make(KeyErrors)
+	for _, key := range keys {
+		if value, ok := m.values[key]; ok {
+			values[key] = value
+		} else {
+			errs[key] = ErrNotFound
+		}
+	}
+	if len(errs) > 0 {
+		return values, errs
+	}
+	return values, nil
+}
+
+func (m *MemStore) Exists(key string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.values[key]
+	return ok
+}
+
+func (m *MemStore) Keys() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	keys := make([]string, 0, len(m.values))
+	for key := range m.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (m *MemStore) Put(key, value string) error {
+	_, err := m.PutNContext(context.Background(), key, value)
+	return err
+}
+
+func (m *MemStore) PutContext(ctx context.Context, key, value string) error {
+	_, err := m.PutNContext(ctx, key, value)
+	return err
+}
+
+func (m *MemStore) PutN(key, value string) (int, error) {
+	return m.PutNContext(context.Background(), key, value)
+}
+
+// PutNContext stores value and returns the size its record would have on
+// disk, so byte counts match those of a Db.
+func (m *MemStore) PutNContext(ctx context.Context, key, value string) (int, error) {
+	if err := m.limits.check(key, value); err != nil {
+		return 0, err
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[key] = value
+	return recordSize(key, value), nil
+}
+
+func (m *MemStore) PutBatch(pairs map[string]string) error {
+	_, _, err := m.PutBatchN(pairs)
+	return err
+}
+
+// PutBatchN stores all pairs or, if any is rejected, none of them.
+func (m *MemStore) PutBatchN(pairs map[string]string) (int, int, error) {
+	n := 0
+	for key, value := range pairs {
+		if err := m.limits.check(key, value); err != nil {
+			return 0, 0, err
+		}
+		n += recordSize(key, value)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, value := range pairs {
+		m.values[key] = value
+	}
+	return n, len(pairs), nil
+}
+
+// Delete removes key. It returns ErrNotFound if key is absent.
+func (m *MemStore) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.values[key]; !ok {
+		return ErrNotFound
+	}
+	delete(m.values, key)
+	return nil
+}
+
+func (m *MemStore) Sync() error {
+	return nil
+}
+
+func (m *MemStore) Check() error {
+	return nil
+}
+
+func (m *MemStore) Close() error {
+	return nil
+}
+
+// recordSize is the encoded size of an uncompressed record without expiry.
+func recordSize(key, value string) int {
+	return len(key) + len(value) + entryOverhead
+}
@@ -0,0 +1,59 @@
+package datastore
+
+import "time"
+
+const (
+	defaultMergeMaxSegments = 8
+	defaultMergeStaleRatio  = 0.5
+)
+
+// shouldMerge reports whether a rollover should start a background merge:
+// when there are too many sealed segments, or when enough of their records
+// are shadowed by newer writes that merging would reclaim real space. The
+// write lock must be held.
+func (db *Db) shouldMerge() bool {
+	segments := len(db.segmentRecords)
+	if segments < 2 {
+		return false
+	}
+	if segments > db.mergeMaxSegs {
+		return true
+	}
+
+	total := 0
+	for _, records := range db.segmentRecords {
+		total += records
+	}
+	if total == 0 {
+		return false
+	}
+	stale := total
+	for _, s := range db.shards {
+		stale -= len(s.segments)
+	}
+	return float64(stale)/float64(total) >= db.mergeStale
+}
+
+// mergePeriodically runs MergeSegments every MergeInterval when the merge
+// policy calls for it. A merge already running, from a rollover or an
+// earlier tick, is left to finish rather than queued behind.
+func (db *Db) mergePeriodically() {
+	defer db.bg.Done()
+
+	ticker := time.NewTicker(db.mergeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			db.mu.Lock()
+			merge := db.shouldMerge()
+			db.mu.Unlock()
+			if merge && !db.mergeRunning.Load() {
+				db.MergeSegments()
+			}
+		case <-db.done:
+			return
+		}
+	}
+}
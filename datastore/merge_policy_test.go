@@ -0,0 +1,122 @@
+package datastore
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMergePolicy(t *testing.T) {
+	// shouldMerge reports the policy's decision for a store of four segments
+	// holding keys written by put, before and after it is reopened.
+	shouldMerge := func(put func(db *Db, segment int)) (sealed, reopened bool) {
+		tmp := t.TempDir()
+		opts := Options{DisableAutoMerge: true}
+		db, err := OpenWithOptions(tmp, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 4; i++ {
+			put(db, i)
+			if _, err := db.Rotate(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		db.mu.Lock()
+		sealed = db.shouldMerge()
+		db.mu.Unlock()
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		db, err = OpenWithOptions(tmp, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		db.mu.Lock()
+		reopened = db.shouldMerge()
+		db.mu.Unlock()
+		return sealed, reopened
+	}
+	putN := func(db *Db, key func(i int) string) {
+		for i := 0; i < 3; i++ {
+			if err := db.Put(key(i), "value"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for _, tc := range []struct {
+		name  string
+		put   func(db *Db, segment int)
+		merge bool
+	}{
+		{"unique keys", func(db *Db, segment int) {
+			putN(db, func(i int) string { return fmt.Sprintf("key%d-%d", segment, i) })
+		}, false},
+		{"overwritten across segments", func(db *Db, segment int) {
+			putN(db, func(i int) string { return fmt.Sprintf("key%d", i) })
+		}, true},
+		{"overwritten within segments", func(db *Db, segment int) {
+			putN(db, func(int) string { return fmt.Sprintf("key%d", segment) })
+		}, true},
+	} {
+		sealed, reopened := shouldMerge(tc.put)
+		if sealed != tc.merge || reopened != tc.merge {
+			t.Errorf("%s: shouldMerge = %v after sealing, %v after reopening, want %v", tc.name, sealed, reopened, tc.merge)
+		}
+	}
+}
+
+func TestPeriodicMerge(t *testing.T) {
+	merging := make(chan struct{}, 1)
+	origHook := mergeHook
+	mergeHook = func() {
+		select {
+		case merging <- struct{}{}:
+		default:
+		}
+	}
+	defer func() { mergeHook = origHook }()
+
+	tmp := t.TempDir()
+	db, err := OpenWithOptions(tmp, Options{DisableAutoMerge: true, MergeInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	// Every segment overwrites the same keys, so most sealed records are
+	// stale and the policy asks for a merge.
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 5; j++ {
+			if err := db.Put(fmt.Sprintf("key%d", j), fmt.Sprintf("value%d-%d", j, i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := db.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// No writes from here on: only the ticker can merge.
+	select {
+	case <-merging:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Segments were not merged without writes")
+	}
+	// The merge holds mergeMu until its result is swapped in.
+	db.mergeMu.Lock()
+	db.mergeMu.Unlock()
+
+	if n := countSegments(t, tmp); n != 1 {
+		t.Errorf("Expected 1 segment after the periodic merge, got %d", n)
+	}
+	for j := 0; j < 5; j++ {
+		key := fmt.Sprintf("key%d", j)
+		if value, err := db.Get(key); err != nil || value != fmt.Sprintf("value%d-3", j) {
+			t.Errorf("Get(%q) after the periodic merge = %q, %v", key, value, err)
+		}
+	}
+}
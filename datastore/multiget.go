@@ -0,0 +1,125 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// KeyErrors maps the keys GetMulti could not read to the reason.
+type KeyErrors map[string]error
+
+func (e KeyErrors) Error() string {
+	keys := make([]string, 0, len(e))
+	for key := range e {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = fmt.Sprintf("%s: %s", key, e[key])
+	}
+	return "failed to read keys: " + strings.Join(parts, "; ")
+}
+
+type multiGetLookup struct {
+	key         string
+	segmentFile string
+	offset      int64
+}
+
+// GetMulti reads keys concurrently through the read worker pool. The lock is
+// held while resolving offsets and reading the current-data file, which a
+// rollover replaces, but not during segment reads; if segments were sealed
+// or merged meanwhile, the values are read again one by one with Get.
+// It returns the values it found and, if any key failed, a KeyErrors with
+// ErrNotFound for missing keys and the read error for the rest.
+func (db *Db) GetMulti(keys []string) (map[string]string, error) {
+	errs := make(KeyErrors)
+	lookups := make([]multiGetLookup, 0, len(keys))
+
+	values := make(map[string]string, len(keys))
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	seen := make(map[string]struct{}, len(keys))
+	read := func(lookups []multiGetLookup) {
+		for _, l := range lookups {
+			if _, dup := seen[l.key]; dup {
+				continue
+			}
+			seen[l.key] = struct{}{}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				value, err := db.readerPool.read(context.Background(), l.key, l.segmentFile, l.offset)
+
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					errs[l.key] = err
+				} else {
+					values[l.key] = value
+				}
+			}()
+		}
+		wg.Wait()
+	}
+
+	db.mu.RLock()
+	generation := db.fileGen
+	var current []multiGetLookup
+	for _, key := range keys {
+		if _, dup := errs[key]; dup {
+			continue
+		}
+		s := db.shard(key)
+		s.mu.RLock()
+		if s.expired(key) {
+			errs[key] = ErrNotFound
+		} else if segInfo, ok := s.segments[key]; ok {
+			lookups = append(lookups, multiGetLookup{key: key, segmentFile: segInfo.file, offset: segInfo.offset})
+		} else if position, ok := s.index[key]; ok {
+			current = append(current, multiGetLookup{key: key, offset: position})
+		} else {
+			errs[key] = ErrNotFound
+		}
+		s.mu.RUnlock()
+	}
+	err := db.flushAppends()
+	if err == nil {
+		read(current)
+	}
+	db.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	read(lookups)
+
+	db.mu.RLock()
+	stale := db.fileGen != generation
+	db.mu.RUnlock()
+	if stale {
+		for _, l := range lookups {
+			value, err := db.Get(l.key)
+			if err != nil {
+				delete(values, l.key)
+				errs[l.key] = err
+			} else {
+				delete(errs, l.key)
+				values[l.key] = value
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return values, errs
+	}
+	return values, nil
+}
@@ -0,0 +1,79 @@
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestGetMulti(t *testing.T) {
+	db, err := OpenWithOptions(t.TempDir(), Options{DisableAutoMerge: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Put("k1", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("k2", "v2"); err != nil {
+		t.Fatal(err)
+	}
+
+	values, err := db.GetMulti([]string{"k1", "k2", "k1", "missing"})
+	if expected := map[string]string{"k1": "v1", "k2": "v2"}; !reflect.DeepEqual(values, expected) {
+		t.Errorf("GetMulti values = %v, wanted %v", values, expected)
+	}
+	var keyErrs KeyErrors
+	if !errors.As(err, &keyErrs) {
+		t.Fatalf("Expected KeyErrors, got %v", err)
+	}
+	if len(keyErrs) != 1 || keyErrs["missing"] != ErrNotFound {
+		t.Errorf("Unexpected key errors: %v", keyErrs)
+	}
+
+	if _, err := db.GetMulti([]string{"k1", "k2"}); err != nil {
+		t.Errorf("GetMulti of existing keys returned %v", err)
+	}
+}
+
+func benchmarkKeys(b *testing.B, db *Db, n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+		if err := db.Put(keys[i], fmt.Sprintf("value%d", i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return keys
+}
+
+func BenchmarkGetMulti(b *testing.B) {
+	db, err := Open(b.TempDir(), 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+	keys := benchmarkKeys(b, db, 64)
+
+	b.Run("GetMulti", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := db.GetMulti(keys); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("GetLoop", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, key := range keys {
+				if _, err := db.Get(key); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,58 @@
+package datastore
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Namespace is a view of a Db whose keys are kept apart from those of other
+// namespaces by a prefix. The prefix holds the length of the name, so no
+// namespace's keys can pass for another's whatever characters the names hold.
+// Merges and recovery treat the prefixed keys like any other.
+type Namespace struct {
+	db     *Db
+	prefix string
+}
+
+// Namespace returns the namespace called name.
+func (db *Db) Namespace(name string) *Namespace {
+	return &Namespace{db: db, prefix: fmt.Sprintf("%d:%s/", len(name), name)}
+}
+
+func (ns *Namespace) Get(key string) (string, error) {
+	return ns.db.Get(ns.prefix + key)
+}
+
+func (ns *Namespace) Put(key, value string) error {
+	return ns.db.Put(ns.prefix+key, value)
+}
+
+func (ns *Namespace) Delete(key string) error {
+	return ns.db.Delete(ns.prefix + key)
+}
+
+func (ns *Namespace) Exists(key string) bool {
+	return ns.db.Exists(ns.prefix + key)
+}
+
+// Keys returns the sorted live keys of the namespace, without its prefix.
+func (ns *Namespace) Keys() []string {
+	keys := []string{}
+	for _, key := range ns.db.Keys() {
+		if rest, ok := strings.CutPrefix(key, ns.prefix); ok {
+			keys = append(keys, rest)
+		}
+	}
+	return keys
+}
+
+// Scan is Db.Scan over the keys of the namespace. The iterator reports keys
+// without the namespace prefix.
+func (ns *Namespace) Scan(prefix string) (*Iterator, error) {
+	it, err := ns.db.Scan(ns.prefix + prefix)
+	if err != nil {
+		return nil, err
+	}
+	it.trim = len(ns.prefix)
+	return it, nil
+}
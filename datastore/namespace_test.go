@@ -0,0 +1,124 @@
+package datastore
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNamespaceIsolation(t *testing.T) {
+	tmp := t.TempDir()
+	db, err := OpenWithOptions(tmp, Options{DisableAutoMerge: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	red, blue := db.Namespace("red"), db.Namespace("blue")
+	for _, write := range []struct {
+		ns         *Namespace
+		key, value string
+	}{
+		{red, "k1", "red1"},
+		{red, "k2", "red2"},
+		{blue, "k1", "blue1"},
+	} {
+		if err := write.ns.Put(write.key, write.value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Put("k1", "root"); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(stage string) {
+		t.Helper()
+		if value, err := red.Get("k1"); err != nil || value != "red1" {
+			t.Errorf("%s: red Get(k1) = %q, %v", stage, value, err)
+		}
+		if value, err := blue.Get("k1"); err != nil || value != "blue1" {
+			t.Errorf("%s: blue Get(k1) = %q, %v", stage, value, err)
+		}
+		if value, err := db.Get("k1"); err != nil || value != "root" {
+			t.Errorf("%s: Get(k1) = %q, %v", stage, value, err)
+		}
+		if _, err := blue.Get("k2"); err != ErrNotFound {
+			t.Errorf("%s: blue Get(k2) = %v, wanted ErrNotFound", stage, err)
+		}
+		if keys := red.Keys(); !reflect.DeepEqual(keys, []string{"k1", "k2"}) {
+			t.Errorf("%s: red Keys() = %v", stage, keys)
+		}
+		if keys := blue.Keys(); !reflect.DeepEqual(keys, []string{"k1"}) {
+			t.Errorf("%s: blue Keys() = %v", stage, keys)
+		}
+
+		it, err := red.Scan("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer it.Close()
+		scanned := map[string]string{}
+		for it.Next() {
+			value, err := it.Value()
+			if err != nil {
+				t.Fatal(err)
+			}
+			scanned[it.Key()] = value
+		}
+		if want := map[string]string{"k1": "red1", "k2": "red2"}; !reflect.DeepEqual(scanned, want) {
+			t.Errorf("%s: red Scan = %v, wanted %v", stage, scanned, want)
+		}
+	}
+	check("before merge")
+
+	if _, err := db.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := blue.Put("k3", "blue3"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.merge(); err != nil {
+		t.Fatal(err)
+	}
+	if err := blue.Delete("k3"); err != nil {
+		t.Fatal(err)
+	}
+	check("after merge")
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = OpenWithOptions(tmp, Options{DisableAutoMerge: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	red, blue = db.Namespace("red"), db.Namespace("blue")
+	check("after reopen")
+}
+
+func TestNamespaceNamesDoNotOverlap(t *testing.T) {
+	db, err := OpenWithOptions(t.TempDir(), Options{DisableAutoMerge: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	// With a plain "name/" prefix both keys would be stored as "a/b/c".
+	if err := db.Namespace("a").Put("b/c", "outer"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Namespace("a/b").Put("c", "inner"); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := db.Namespace("a").Get("b/c"); err != nil || value != "outer" {
+		t.Errorf("Get(b/c) in a = %q, %v", value, err)
+	}
+	if keys := db.Namespace("a").Keys(); !reflect.DeepEqual(keys, []string{"b/c"}) {
+		t.Errorf("Keys() in a = %v", keys)
+	}
+	if db.Namespace("a/b").Exists("b/c") {
+		t.Error("a key of namespace a showed up in a/b")
+	}
+}
@@ -0,0 +1,111 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrOperationNotFound = fmt.Errorf("operation does not exist")
+
+// OperationInfo describes a long-running datastore operation in progress.
+type OperationInfo struct {
+	ID      uint64    `json:"id"`
+	Kind    string    `json:"kind"`
+	Started time.Time `json:"started"`
+	Done    int64     `json:"done"`
+	Total   int64     `json:"total"`
+}
+
+type operation struct {
+	id      uint64
+	kind    string
+	started time.Time
+	done    atomic.Int64
+	total   atomic.Int64
+	cancel  context.CancelFunc
+}
+
+func (op *operation) progress(done, total int64) {
+	op.done.Store(done)
+	op.total.Store(total)
+}
+
+type operationRegistry struct {
+	mu     sync.Mutex
+	nextID uint64
+	ops    map[uint64]*operation
+}
+
+func newOperationRegistry() *operationRegistry {
+	return &operationRegistry{ops: make(map[uint64]*operation)}
+}
+
+// start registers a cancellable operation. The returned context is cancelled
+// by CancelOperation; callers must call finish once the operation stops.
+func (r *operationRegistry) start(kind string) (*operation, context.Context) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	op := &operation{
+		id:      r.nextID,
+		kind:    kind,
+		started: timeNow(),
+		cancel:  cancel,
+	}
+	r.ops[op.id] = op
+	return op, ctx
+}
+
+func (r *operationRegistry) finish(op *operation) {
+	r.mu.Lock()
+	delete(r.ops, op.id)
+	r.mu.Unlock()
+	op.cancel()
+}
+
+func (r *operationRegistry) list() []OperationInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	res := make([]OperationInfo, 0, len(r.ops))
+	for _, op := range r.ops {
+		res = append(res, OperationInfo{
+			ID:      op.id,
+			Kind:    op.kind,
+			Started: op.started,
+			Done:    op.done.Load(),
+			Total:   op.total.Load(),
+		})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res
+}
+
+func (r *operationRegistry) cancel(id uint64) error {
+	r.mu.Lock()
+	op, ok := r.ops[id]
+	r.mu.Unlock()
+	if !ok {
+		return ErrOperationNotFound
+	}
+	op.cancel()
+	return nil
+}
+
+// Operations lists the long-running operations (such as merges) currently in progress.
+func (db *Db) Operations() []OperationInfo {
+	return db.ops.list()
+}
+
+// CancelOperation asks the operation with the given ID to stop. The operation
+// is removed from Operations once it has actually stopped.
+func (db *Db) CancelOperation(id uint64) error {
+	return db.ops.cancel(id)
+}
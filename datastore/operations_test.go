@@ -0,0 +1,50 @@
+package datastore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOperationsListAndCancel(t *testing.T) {
+	db, err := Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	stopped := make(chan struct{})
+	op, ctx := db.ops.start("export")
+	go func() {
+		defer close(stopped)
+		defer db.ops.finish(op)
+		for i := int64(0); ; i++ {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Millisecond):
+				op.progress(i, 1000)
+			}
+		}
+	}()
+
+	ops := db.Operations()
+	if len(ops) != 1 || ops[0].ID != op.id || ops[0].Kind != "export" {
+		t.Fatalf("Operations() = %+v, expected the running export", ops)
+	}
+
+	if err := db.CancelOperation(op.id); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Operation did not stop after cancellation")
+	}
+
+	if ops := db.Operations(); len(ops) != 0 {
+		t.Errorf("Cancelled operation is still listed: %+v", ops)
+	}
+	if err := db.CancelOperation(op.id); err != ErrOperationNotFound {
+		t.Errorf("CancelOperation of a finished operation = %v, expected ErrOperationNotFound", err)
+	}
+}
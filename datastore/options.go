@@ -0,0 +1,176 @@
+package datastore
+
+import "time"
+
+// Option configures a Db opened with OpenWithOptions. Options itself is an
+// Option that replaces every setting, so pass it before any With option.
+type Option interface {
+	apply(*Options)
+}
+
+func (o Options) apply(opts *Options) {
+	*opts = o
+}
+
+type optionFunc func(*Options)
+
+func (f optionFunc) apply(opts *Options) {
+	f(opts)
+}
+
+// WithSegmentSize seals the current-data file into a segment once it reaches
+// size bytes. The default of zero never seals by size.
+func WithSegmentSize(size int64) Option {
+	return optionFunc(func(o *Options) { o.SegmentSize = size })
+}
+
+// WithMaxSegmentAge seals the current-data file once it has been open for
+// age. The default of zero never seals by age.
+func WithMaxSegmentAge(age time.Duration) Option {
+	return optionFunc(func(o *Options) { o.MaxSegmentAge = age })
+}
+
+// WithShardReads gives each sealed segment its own read workers. It is off
+// by default.
+func WithShardReads(enabled bool) Option {
+	return optionFunc(func(o *Options) { o.ShardReads = enabled })
+}
+
+// WithTagFunc maintains a secondary index of values by fn. There is none by
+// default.
+func WithTagFunc(fn TagFunc) Option {
+	return optionFunc(func(o *Options) { o.TagFunc = fn })
+}
+
+// WithAutoMerge sets whether rollovers start background merges. They do by
+// default.
+func WithAutoMerge(enabled bool) Option {
+	return optionFunc(func(o *Options) { o.DisableAutoMerge = !enabled })
+}
+
+// WithWriteBuffer groups writes into batches of up to size bytes that are
+// written and synced together. The default of zero writes every Put directly.
+func WithWriteBuffer(size int) Option {
+	return optionFunc(func(o *Options) { o.WriteBuffer = size })
+}
+
+// WithAppendBuffer collects appends to the current-data file in memory, up
+// to size bytes, before writing them with one call. The default of zero
+// writes every Put directly.
+func WithAppendBuffer(size int) Option {
+	return optionFunc(func(o *Options) { o.AppendBuffer = size })
+}
+
+// WithAppendFlushInterval writes out the append buffer in the background
+// every d. The default of zero uses 100ms.
+func WithAppendFlushInterval(d time.Duration) Option {
+	return optionFunc(func(o *Options) { o.AppendFlushInterval = d })
+}
+
+// WithPinMergedSegments keeps merged segments in the page cache. It is off by
+// default.
+func WithPinMergedSegments(enabled bool) Option {
+	return optionFunc(func(o *Options) { o.PinMergedSegments = enabled })
+}
+
+// WithReadOnly opens the store without the directory lock and rejects
+// writes. Stores are writable by default.
+func WithReadOnly(enabled bool) Option {
+	return optionFunc(func(o *Options) { o.ReadOnly = enabled })
+}
+
+// WithLockWait waits up to d for another store to release the directory. The
+// default of zero fails at once with ErrLocked.
+func WithLockWait(d time.Duration) Option {
+	return optionFunc(func(o *Options) { o.LockWait = d })
+}
+
+// WithReadWorkers serves reads with n goroutines. The default of zero uses
+// twice the number of CPUs.
+func WithReadWorkers(n int) Option {
+	return optionFunc(func(o *Options) { o.ReadWorkers = n })
+}
+
+// WithSyncEvery fsyncs the current-data file after every n writes. The
+// default of zero leaves flushing to the OS.
+func WithSyncEvery(n int) Option {
+	return optionFunc(func(o *Options) { o.SyncEvery = n })
+}
+
+// WithSyncEveryWrite makes every acknowledged Put durable, as WithSyncEvery(1)
+// does. It is off by default.
+func WithSyncEveryWrite(enabled bool) Option {
+	return optionFunc(func(o *Options) {
+		if enabled {
+			o.SyncEvery = 1
+		} else {
+			o.SyncEvery = 0
+		}
+	})
+}
+
+// WithSyncInterval fsyncs unsynced writes in the background every d. The
+// default of zero disables it.
+func WithSyncInterval(d time.Duration) Option {
+	return optionFunc(func(o *Options) { o.SyncInterval = d })
+}
+
+// WithMaxKeyLen rejects writes of keys longer than n bytes. The default of
+// zero only enforces the limit of the record format.
+func WithMaxKeyLen(n int) Option {
+	return optionFunc(func(o *Options) { o.MaxKeyLen = n })
+}
+
+// WithMaxValueLen rejects writes of values longer than n bytes. The default
+// of zero only enforces the limit of the record format.
+func WithMaxValueLen(n int) Option {
+	return optionFunc(func(o *Options) { o.MaxValueLen = n })
+}
+
+// WithReadTimeout fails reads not done within d with ErrReadTimeout. The
+// default of zero waits as long as the read's context allows.
+func WithReadTimeout(d time.Duration) Option {
+	return optionFunc(func(o *Options) { o.ReadTimeout = d })
+}
+
+// WithReadCache caches up to size bytes of recently read keys and values.
+// The default of zero disables the cache.
+func WithReadCache(size int) Option {
+	return optionFunc(func(o *Options) { o.ReadCache = size })
+}
+
+// WithCompressThreshold gzips values of at least n bytes. The default of zero
+// disables compression.
+func WithCompressThreshold(n int) Option {
+	return optionFunc(func(o *Options) { o.CompressThreshold = n })
+}
+
+// WithMergeMaxSegments merges once there are more than n sealed segments.
+// The default is 8.
+func WithMergeMaxSegments(n int) Option {
+	return optionFunc(func(o *Options) { o.MergeMaxSegments = n })
+}
+
+// WithMergeStaleRatio merges once ratio of the sealed records are
+// superseded. The default is 0.5.
+func WithMergeStaleRatio(ratio float64) Option {
+	return optionFunc(func(o *Options) { o.MergeStaleRatio = ratio })
+}
+
+// WithMergeInterval checks the merge policy every interval, whether or not
+// the store is written to. It is off by default.
+func WithMergeInterval(interval time.Duration) Option {
+	return optionFunc(func(o *Options) { o.MergeInterval = interval })
+}
+
+// WithRepairOnOpen truncates a partial record at the end of the newest
+// segment instead of failing Open. It is off by default.
+func WithRepairOnOpen(enabled bool) Option {
+	return optionFunc(func(o *Options) { o.RepairOnOpen = enabled })
+}
+
+// WithOnMergeError reports failed background merges to fn. They are dropped
+// by default.
+func WithOnMergeError(fn func(error)) Option {
+	return optionFunc(func(o *Options) { o.OnMergeError = fn })
+}
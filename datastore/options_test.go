@@ -0,0 +1,92 @@
+package datastore
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOptionFuncs(t *testing.T) {
+	tagFn := PrefixTag(1)
+	onMergeError := func(error) {}
+	for _, tc := range []struct {
+		name   string
+		option Option
+		check  func(o Options) bool
+	}{
+		{"SegmentSize", WithSegmentSize(10), func(o Options) bool { return o.SegmentSize == 10 }},
+		{"MaxSegmentAge", WithMaxSegmentAge(time.Second), func(o Options) bool { return o.MaxSegmentAge == time.Second }},
+		{"ShardReads", WithShardReads(true), func(o Options) bool { return o.ShardReads }},
+		{"TagFunc", WithTagFunc(tagFn), func(o Options) bool { return o.TagFunc != nil }},
+		{"AutoMerge", WithAutoMerge(false), func(o Options) bool { return o.DisableAutoMerge }},
+		{"WriteBuffer", WithWriteBuffer(64), func(o Options) bool { return o.WriteBuffer == 64 }},
+		{"AppendBuffer", WithAppendBuffer(4096), func(o Options) bool { return o.AppendBuffer == 4096 }},
+		{"AppendFlushInterval", WithAppendFlushInterval(time.Second), func(o Options) bool { return o.AppendFlushInterval == time.Second }},
+		{"PinMergedSegments", WithPinMergedSegments(true), func(o Options) bool { return o.PinMergedSegments }},
+		{"ReadOnly", WithReadOnly(true), func(o Options) bool { return o.ReadOnly }},
+		{"LockWait", WithLockWait(time.Second), func(o Options) bool { return o.LockWait == time.Second }},
+		{"ReadWorkers", WithReadWorkers(3), func(o Options) bool { return o.ReadWorkers == 3 }},
+		{"SyncEvery", WithSyncEvery(5), func(o Options) bool { return o.SyncEvery == 5 }},
+		{"SyncEveryWrite", WithSyncEveryWrite(true), func(o Options) bool { return o.SyncEvery == 1 }},
+		{"SyncInterval", WithSyncInterval(time.Second), func(o Options) bool { return o.SyncInterval == time.Second }},
+		{"MaxKeyLen", WithMaxKeyLen(8), func(o Options) bool { return o.MaxKeyLen == 8 }},
+		{"MaxValueLen", WithMaxValueLen(16), func(o Options) bool { return o.MaxValueLen == 16 }},
+		{"ReadTimeout", WithReadTimeout(time.Second), func(o Options) bool { return o.ReadTimeout == time.Second }},
+		{"ReadCache", WithReadCache(1 << 20), func(o Options) bool { return o.ReadCache == 1<<20 }},
+		{"CompressThreshold", WithCompressThreshold(100), func(o Options) bool { return o.CompressThreshold == 100 }},
+		{"MergeMaxSegments", WithMergeMaxSegments(4), func(o Options) bool { return o.MergeMaxSegments == 4 }},
+		{"MergeStaleRatio", WithMergeStaleRatio(0.25), func(o Options) bool { return o.MergeStaleRatio == 0.25 }},
+		{"MergeInterval", WithMergeInterval(time.Minute), func(o Options) bool { return o.MergeInterval == time.Minute }},
+		{"RepairOnOpen", WithRepairOnOpen(true), func(o Options) bool { return o.RepairOnOpen }},
+		{"OnMergeError", WithOnMergeError(onMergeError), func(o Options) bool { return o.OnMergeError != nil }},
+	} {
+		var opts Options
+		tc.option.apply(&opts)
+		if !tc.check(opts) {
+			t.Errorf("With%s did not take effect: %+v", tc.name, opts)
+		}
+	}
+
+	var opts Options
+	for _, o := range []Option{Options{SegmentSize: 1, ReadWorkers: 2}, WithSegmentSize(3)} {
+		o.apply(&opts)
+	}
+	if !reflect.DeepEqual(opts, Options{SegmentSize: 3, ReadWorkers: 2}) {
+		t.Errorf("An Options value followed by WithSegmentSize gave %+v", opts)
+	}
+}
+
+func TestOpenWithOptionFuncs(t *testing.T) {
+	tmp := t.TempDir()
+	db, err := OpenWithOptions(tmp, WithSegmentSize(64), WithAutoMerge(false), WithSyncEveryWrite(true), WithReadWorkers(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"k1", "k2", "k3"} {
+		if err := db.Put(key, "some value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if db.segmentSize != 64 || db.autoMerge || db.syncEvery != 1 {
+		t.Errorf("Options not applied: segmentSize %d, autoMerge %v, syncEvery %d", db.segmentSize, db.autoMerge, db.syncEvery)
+	}
+	if db.unsynced != 0 {
+		t.Errorf("%d writes left unsynced with WithSyncEveryWrite", db.unsynced)
+	}
+	if segmentFiles, err := db.segmentFiles(); err != nil || len(segmentFiles) == 0 {
+		t.Errorf("Expected rollover into segments, got %v, %v", segmentFiles, err)
+	}
+
+	ro, err := OpenWithOptions(tmp, WithReadOnly(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+	if err := ro.Put("k", "v"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Put on a WithReadOnly store returned %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
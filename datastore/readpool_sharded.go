@@ -0,0 +1,122 @@
+package datastore
+
+import (
+	"context"
+	"sync"
+)
+
+const (
+	// shardMinSegments is the number of sealed segments below which reads
+	// are served by the shared pool only.
+	shardMinSegments = 4
+	workersPerShard  = 2
+)
+
+// shardedReadPool routes segment reads to a per-segment worker pool. Reads of
+// the current-data file, and all reads while the store has few segments, go
+// through the shared pool. All pools share its file handles, so a segment is
+// opened once whichever pool reads it.
+type shardedReadPool struct {
+	shared *readWorkerPool
+
+	mu     sync.Mutex
+	shards map[string]*readWorkerPool
+}
+
+func newShardedReadPool(shared *readWorkerPool) *shardedReadPool {
+	return &shardedReadPool{
+		shared: shared,
+		shards: make(map[string]*readWorkerPool),
+	}
+}
+
+func (p *shardedReadPool) read(ctx context.Context, key string, segmentFile string, offset int64) (string, error) {
+	if segmentFile == "" {
+		return p.shared.read(ctx, key, segmentFile, offset)
+	}
+
+	p.mu.Lock()
+	shard, ok := p.shards[segmentFile]
+	if ok && len(p.shards) < shardMinSegments {
+		ok = false
+	}
+	if ok && shard == nil {
+		shard = newReadWorkerPool(workersPerShard, segmentFile, p.shared.timeout, p.shared.handles)
+		p.shards[segmentFile] = shard
+	}
+	if ok {
+		// Taken under p.mu, so a concurrent removeSegment waits for this
+		// read before stopping the shard.
+		shard.readers.Add(1)
+	}
+	p.mu.Unlock()
+
+	if !ok {
+		return p.shared.read(ctx, key, segmentFile, offset)
+	}
+	defer shard.readers.Done()
+	return shard.read(ctx, key, segmentFile, offset)
+}
+
+// addSegment registers a segment file; its workers are started lazily on the
+// first read.
+func (p *shardedReadPool) addSegment(segmentFile string) {
+	p.shared.addSegment(segmentFile)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.shards[segmentFile]; !ok {
+		p.shards[segmentFile] = nil
+	}
+}
+
+func (p *shardedReadPool) removeSegment(segmentFile string) {
+	p.mu.Lock()
+	shard := p.shards[segmentFile]
+	delete(p.shards, segmentFile)
+	p.mu.Unlock()
+
+	if shard != nil {
+		shard.readers.Wait()
+		shard.stop()
+	}
+	// Forget the handle only once the shard's reads are done, so none of
+	// them reopens and caches it again.
+	p.shared.removeSegment(segmentFile)
+}
+
+func (p *shardedReadPool) stats() ReadPoolStats {
+	stats := p.shared.stats()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, shard := range p.shards {
+		if shard != nil {
+			s := shard.stats()
+			stats.Workers += s.Workers
+			stats.Queued += s.Queued
+			stats.InFlight += s.InFlight
+		}
+	}
+	return stats
+}
+
+func (p *shardedReadPool) reopenCurrent() {
+	p.shared.reopenCurrent()
+}
+
+func (p *shardedReadPool) close() {
+	p.mu.Lock()
+	shards := p.shards
+	p.shards = make(map[string]*readWorkerPool)
+	p.mu.Unlock()
+
+	for _, shard := range shards {
+		if shard != nil {
+			shard.readers.Wait()
+			shard.stop()
+		}
+	}
+	p.shared.close()
+}
@@ -0,0 +1,211 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const shardTestKeys = 64
+
+func openMultiSegmentDb(tb testing.TB, opts Options) *Db {
+	opts.SegmentSize = 512
+	opts.DisableAutoMerge = true
+	db, err := OpenWithOptions(tb.TempDir(), opts)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { _ = db.Close() })
+
+	value := strings.Repeat("v", 64)
+	for i := 0; i < shardTestKeys; i++ {
+		if err := db.Put(fmt.Sprintf("key%d", i), value); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return db
+}
+
+func TestShardedReads(t *testing.T) {
+	db := openMultiSegmentDb(t, Options{ShardReads: true})
+	if n := countSegments(t, db.dir); n < shardMinSegments {
+		t.Fatalf("Expected at least %d segments, got %d", shardMinSegments, n)
+	}
+
+	for i := 0; i < shardTestKeys; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if value, err := db.Get(key); err != nil || value != strings.Repeat("v", 64) {
+			t.Errorf("Get(%q) = %q, %v", key, value, err)
+		}
+	}
+
+	pool := db.readerPool.(*shardedReadPool)
+	pool.mu.Lock()
+	started := 0
+	for _, shard := range pool.shards {
+		if shard != nil {
+			started++
+		}
+	}
+	pool.mu.Unlock()
+	if started < shardMinSegments {
+		t.Errorf("Expected reads to start per-segment workers, %d shards started", started)
+	}
+}
+
+func TestShardedReadsShareMergedHandle(t *testing.T) {
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil || len(fds) == 0 {
+		t.Skip("open file descriptors can't be listed on this system")
+	}
+
+	db, err := OpenWithOptions(t.TempDir(), Options{ShardReads: true, DisableAutoMerge: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	for i := 0; i < shardTestKeys; i++ {
+		if err := db.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)); err != nil {
+			t.Fatal(err)
+		}
+		if i%16 == 15 {
+			if _, err := db.Rotate(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, err := db.merge(); err != nil {
+		t.Fatal(err)
+	}
+	merged := db.shard("key0").segments["key0"].file
+
+	readAll := func() {
+		t.Helper()
+		for i := 0; i < shardTestKeys; i++ {
+			key := fmt.Sprintf("key%d", i)
+			if value, err := db.Get(key); err != nil || value != fmt.Sprintf("value%d", i) {
+				t.Fatalf("Get(%q) = %q, %v", key, value, err)
+			}
+		}
+	}
+	// With one segment the shared pool serves the reads; once there are
+	// enough segments, the merged one gets workers of its own.
+	readAll()
+	for i := 1; i < shardMinSegments; i++ {
+		if err := db.Put(fmt.Sprintf("extra%d", i), "v"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	readAll()
+
+	open := 0
+	fds, _ = os.ReadDir("/proc/self/fd")
+	for _, fd := range fds {
+		if target, err := os.Readlink(filepath.Join("/proc/self/fd", fd.Name())); err == nil && target == merged {
+			open++
+		}
+	}
+	if open != 1 {
+		t.Errorf("The merged segment is open %d times, expected a single shared handle", open)
+	}
+}
+
+func BenchmarkConcurrentSegmentReads(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		opts Options
+	}{
+		{"shared", Options{}},
+		{"sharded", Options{ShardReads: true}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			db := openMultiSegmentDb(b, bc.opts)
+			var n atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					key := fmt.Sprintf("key%d", n.Add(1)%shardTestKeys)
+					if _, err := db.Get(key); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestShardedReadOutlivesRemoveSegment(t *testing.T) {
+	db := openMultiSegmentDb(t, Options{ShardReads: true})
+	info := *db.shard("key0").segments["key0"]
+	pool := db.readerPool.(*shardedReadPool)
+
+	// Occupy both workers of the segment's shard, fill its queue and leave
+	// one more read waiting to be queued before the segment is removed.
+	started, release := make(chan struct{}), make(chan struct{})
+	var calls atomic.Int32
+	origHook := readHook
+	readHook = func() {
+		if calls.Add(1) <= workersPerShard {
+			started <- struct{}{}
+			<-release
+		}
+	}
+	defer func() { readHook = origHook }()
+
+	const reads = workersPerShard*3 + 1
+	errs := make(chan error, reads)
+	for i := 0; i < reads; i++ {
+		go func() {
+			value, err := pool.read(context.Background(), "key0", info.file, info.offset)
+			if err == nil && value != strings.Repeat("v", 64) {
+				err = fmt.Errorf("read %q", value)
+			}
+			errs <- err
+		}()
+	}
+	for i := 0; i < workersPerShard; i++ {
+		<-started
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for pool.stats().Queued < reads-workersPerShard {
+		if time.Now().After(deadline) {
+			t.Fatal("The reads were never queued")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	removed := make(chan struct{})
+	go func() {
+		pool.removeSegment(info.file)
+		close(removed)
+	}()
+	select {
+	case err := <-errs:
+		t.Fatalf("Read returned before its worker was released: %v", err)
+	case <-removed:
+		t.Fatal("removeSegment returned with reads in flight")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+
+	for i := 0; i < reads; i++ {
+		select {
+		case err := <-errs:
+			if err != nil {
+				t.Errorf("Read in flight during removeSegment failed: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Read in flight during removeSegment never returned")
+		}
+	}
+	<-removed
+}
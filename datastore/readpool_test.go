@@ -0,0 +1,90 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadTimeoutOnSaturatedPool(t *testing.T) {
+	block := make(chan struct{})
+	origHook := readHook
+	readHook = func() { <-block }
+	defer func() { readHook = origHook }()
+
+	db, err := OpenWithOptions(t.TempDir(), WithReadWorkers(1), WithReadTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Put("k", "v"); err != nil {
+		t.Fatal(err)
+	}
+
+	// One read occupies the only worker and two fill the queue; the rest
+	// have to wait for a slot.
+	const reads = 6
+	errs := make(chan error, reads)
+	var wg sync.WaitGroup
+	for i := 0; i < reads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := db.Get("k")
+			errs <- err
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reads of a saturated pool hung instead of timing out")
+	}
+	close(errs)
+	for err := range errs {
+		if !errors.Is(err, ErrReadTimeout) {
+			t.Errorf("got %v, want ErrReadTimeout", err)
+		}
+	}
+
+	stats := db.ReadPoolStats()
+	if stats.Workers != 1 || stats.InFlight != 1 {
+		t.Errorf("got %+v, want 1 worker with 1 read in flight", stats)
+	}
+	close(block)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for stats = db.ReadPoolStats(); stats.Queued != 0 || stats.InFlight != 0; stats = db.ReadPoolStats() {
+		if time.Now().After(deadline) {
+			t.Fatalf("the pool didn't drain: %+v", stats)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if value, err := db.Get("k"); err != nil || value != "v" {
+		t.Errorf("Get after the burst: got %q, %v", value, err)
+	}
+}
+
+func TestReadTimeoutKeepsContextError(t *testing.T) {
+	db, err := OpenWithOptions(t.TempDir(), WithReadTimeout(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Put("k", "v"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := db.GetContext(ctx, "k"); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+}
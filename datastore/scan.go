@@ -0,0 +1,108 @@
+package datastore
+
+import (
+	"os"
+	"sort"
+	"strings"
+)
+
+type scanRef struct {
+	file   *os.File
+	offset int64
+}
+
+// Iterator walks the keys matched by Scan in sorted order. It reads from
+// files opened when the scan started, so rotations and merges that happen
+// meanwhile don't affect it. Close must be called to release the files.
+type Iterator struct {
+	keys  []string
+	refs  map[string]scanRef
+	files []*os.File
+	pos   int
+	// trim is the length of the namespace prefix Key leaves out.
+	trim int
+}
+
+// Scan returns an iterator over the keys that start with prefix, taken as a
+// snapshot of the store at the time of the call.
+func (db *Db) Scan(prefix string) (*Iterator, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if err := db.flushAppends(); err != nil {
+		return nil, err
+	}
+	defer db.rlockShards()()
+
+	it := &Iterator{refs: make(map[string]scanRef), pos: -1}
+	opened := make(map[string]*os.File)
+	open := func(path string) (*os.File, error) {
+		if f, ok := opened[path]; ok {
+			return f, nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		opened[path] = f
+		it.files = append(it.files, f)
+		return f, nil
+	}
+
+	for _, s := range db.shards {
+		for key, segInfo := range s.segments {
+			if !strings.HasPrefix(key, prefix) || s.expired(key) {
+				continue
+			}
+			f, err := open(segInfo.file)
+			if err != nil {
+				it.Close()
+				return nil, err
+			}
+			it.refs[key] = scanRef{file: f, offset: segInfo.offset}
+		}
+		for key, position := range s.index {
+			if !strings.HasPrefix(key, prefix) || s.expired(key) {
+				continue
+			}
+			f, err := open(db.out.Name())
+			if err != nil {
+				it.Close()
+				return nil, err
+			}
+			it.refs[key] = scanRef{file: f, offset: position}
+		}
+	}
+
+	it.keys = make([]string, 0, len(it.refs))
+	for key := range it.refs {
+		it.keys = append(it.keys, key)
+	}
+	sort.Strings(it.keys)
+	return it, nil
+}
+
+// Next advances to the next key and reports whether there is one.
+func (it *Iterator) Next() bool {
+	if it.pos < len(it.keys) {
+		it.pos++
+	}
+	return it.pos < len(it.keys)
+}
+
+func (it *Iterator) Key() string {
+	return it.keys[it.pos][it.trim:]
+}
+
+// Value reads the value of the current key as of the snapshot.
+func (it *Iterator) Value() (string, error) {
+	key := it.keys[it.pos]
+	ref := it.refs[key]
+	return readAt(ref.file, key, ref.offset)
+}
+
+func (it *Iterator) Close() {
+	for _, f := range it.files {
+		f.Close()
+	}
+	it.files = nil
+}
@@ -0,0 +1,69 @@
+package datastore
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestScan(t *testing.T) {
+	db, err := OpenWithOptions(t.TempDir(), Options{DisableAutoMerge: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, pair := range [][]string{
+		{"user:2", "old"},
+		{"user:1", "a"},
+		{"team:1", "x"},
+	} {
+		if err := db.Put(pair[0], pair[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("user:2", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("user:3", "c"); err != nil {
+		t.Fatal(err)
+	}
+
+	it, err := db.Scan("user:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+
+	// Changes made after the scan started must not show up in it.
+	if _, err := db.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("user:1", "changed"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CompactNow(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got [][]string
+	for it.Next() {
+		value, err := it.Value()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, []string{it.Key(), value})
+	}
+	expected := [][]string{{"user:1", "a"}, {"user:2", "b"}, {"user:3", "c"}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Scan yielded %v, wanted %v", got, expected)
+	}
+	if it.Next() {
+		t.Error("Next returned true after the iterator was exhausted")
+	}
+}
@@ -0,0 +1,54 @@
+package datastore
+
+import (
+	"fmt"
+	"time"
+)
+
+var ErrStaleRead = fmt.Errorf("store has not reached the requested sequence")
+
+// staleReadWait bounds how long GetAtLeast waits for a sequence to be applied.
+var staleReadWait = 100 * time.Millisecond
+
+// Seq returns the sequence number of the last applied write. Sequence numbers
+// increase by one with every write and restart from zero when the store is opened.
+func (db *Db) Seq() uint64 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	db.appendMu.Lock()
+	defer db.appendMu.Unlock()
+	return db.seq
+}
+
+// advanceSeq must be called after a write is applied, with the write lock or
+// appendMu held.
+func (db *Db) advanceSeq() {
+	db.seq++
+	close(db.seqChanged)
+	db.seqChanged = make(chan struct{})
+}
+
+// GetAtLeast reads key once the store has applied at least minSeq writes,
+// giving clients monotonic reads. It waits briefly for the sequence to be
+// reached and returns ErrStaleRead if it is not.
+func (db *Db) GetAtLeast(key string, minSeq uint64) (string, error) {
+	timer := time.NewTimer(staleReadWait)
+	defer timer.Stop()
+
+	for {
+		db.mu.RLock()
+		db.appendMu.Lock()
+		seq, changed := db.seq, db.seqChanged
+		db.appendMu.Unlock()
+		db.mu.RUnlock()
+		if seq >= minSeq {
+			return db.Get(key)
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return "", ErrStaleRead
+		}
+	}
+}
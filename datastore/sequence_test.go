@@ -0,0 +1,38 @@
+package datastore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetAtLeast(t *testing.T) {
+	db, err := Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Put("k", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	seq := db.Seq()
+	if seq != 1 {
+		t.Fatalf("Seq() = %d after one write, expected 1", seq)
+	}
+
+	if value, err := db.GetAtLeast("k", seq); err != nil || value != "v1" {
+		t.Errorf("GetAtLeast(k, %d) = %q, %v", seq, value, err)
+	}
+
+	if _, err := db.GetAtLeast("k", seq+1); err != ErrStaleRead {
+		t.Errorf("GetAtLeast for a future sequence = %v, expected ErrStaleRead", err)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_ = db.Put("k", "v2")
+	}()
+	if value, err := db.GetAtLeast("k", seq+1); err != nil || value != "v2" {
+		t.Errorf("GetAtLeast should wait for the next write, got %q, %v", value, err)
+	}
+}
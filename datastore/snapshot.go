@@ -0,0 +1,146 @@
+package datastore
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Snapshot writes a tar archive of the store to w: the sealed segments with
+// their hint and bloom files, and the current-data file. The files are opened
+// and the length of the current-data file is taken under the read lock, so
+// the archive holds the store as of the call even if writes, rollovers and
+// merges go on while it is streamed.
+func (db *Db) Snapshot(w io.Writer) error {
+	files, outSize, err := db.snapshotFiles()
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	for _, f := range files {
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		size := info.Size()
+		if filepath.Base(f.Name()) == outFileName {
+			size = outSize
+		}
+		header := &tar.Header{
+			Name:    filepath.Base(f.Name()),
+			Mode:    0o600,
+			Size:    size,
+			ModTime: info.ModTime(),
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, io.NewSectionReader(f, 0, size)); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// snapshotFiles opens the files that make up the store and returns them with
+// the length of the current-data file. Hint and bloom files are only taken
+// once they are complete, as they may still be being written otherwise. The files are returned even on error so they can be closed.
+func (db *Db) snapshotFiles() ([]*os.File, int64, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	db.appendMu.Lock()
+	defer db.appendMu.Unlock()
+
+	if err := db.flushAppendsLocked(); err != nil {
+		return nil, 0, err
+	}
+	segmentFiles, err := db.segmentFiles()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var files []*os.File
+	open := func(path string) error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+		return nil
+	}
+	for _, segmentFile := range segmentFiles {
+		if err := open(segmentFile); err != nil {
+			return files, 0, err
+		}
+		if !db.hinted[segmentFile] {
+			continue
+		}
+		for _, path := range []string{hintPath(segmentFile), bloomPath(segmentFile)} {
+			if err := open(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return files, 0, err
+			}
+		}
+	}
+	if err := open(filepath.Join(db.dir, outFileName)); err != nil {
+		return files, 0, err
+	}
+	return files, db.outOffset, nil
+}
+
+// Restore unpacks an archive written by Snapshot into dir, which must not
+// exist or be empty. The restored store is opened as usual.
+func Restore(dir string, r io.Reader) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("restore target %s is not empty", dir)
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := header.Name
+		if header.Typeflag != tar.TypeReg || name != filepath.Base(name) || name == "." || name == ".." {
+			return fmt.Errorf("unexpected entry %q in snapshot", name)
+		}
+		if err := restoreFile(filepath.Join(dir, name), tr); err != nil {
+			return err
+		}
+	}
+}
+
+func restoreFile(path string, r io.Reader) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
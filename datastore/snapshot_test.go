@@ -0,0 +1,60 @@
+package datastore
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	db, err := OpenWithOptions(t.TempDir(), Options{SegmentSize: 200, DisableAutoMerge: true, AppendBuffer: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	expected := make(map[string]string)
+	for i := 0; i < 40; i++ {
+		key, value := fmt.Sprintf("k%d", i%25), fmt.Sprintf("v%d", i)
+		if err := db.Put(key, value); err != nil {
+			t.Fatal(err)
+		}
+		expected[key] = value
+	}
+	if err := db.Delete("k3"); err != nil {
+		t.Fatal(err)
+	}
+	delete(expected, "k3")
+
+	var archive bytes.Buffer
+	if err := db.Snapshot(&archive); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("after", "snapshot"); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "restored")
+	if err := Restore(dir, bytes.NewReader(archive.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := OpenWithOptions(dir, Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+
+	if keys := restored.Keys(); len(keys) != len(expected) {
+		t.Errorf("Restored %d keys, expected %d: %v", len(keys), len(expected), keys)
+	}
+	for key, value := range expected {
+		if got, err := restored.Get(key); err != nil || got != value {
+			t.Errorf("Restored Get(%s) = %q, %v; expected %q", key, got, err, value)
+		}
+	}
+
+	if err := Restore(dir, bytes.NewReader(archive.Bytes())); err == nil {
+		t.Error("Restore into a non-empty directory should fail")
+	}
+}
@@ -0,0 +1,61 @@
+package datastore
+
+// Stats is a snapshot of the store's operational metrics.
+type Stats struct {
+	// CurrentKeys is the number of live keys whose latest record is in the
+	// current-data file.
+	CurrentKeys int `json:"currentKeys"`
+	// SegmentKeys is the number of live keys held in sealed segments.
+	SegmentKeys   int   `json:"segmentKeys"`
+	Segments      int   `json:"segments"`
+	CurrentOffset int64 `json:"currentOffset"`
+	// DiskSize is CurrentSize, the size of the current-data file, plus
+	// SegmentsSize, the size of the sealed segments.
+	DiskSize     int64 `json:"diskSize"`
+	CurrentSize  int64 `json:"currentSize"`
+	SegmentsSize int64 `json:"segmentsSize"`
+	// CacheHits and CacheMisses count the Gets served from and past the
+	// read cache. Both stay zero while the cache is disabled.
+	CacheHits   int64 `json:"cacheHits"`
+	CacheMisses int64 `json:"cacheMisses"`
+}
+
+// Stats reports metrics from the in-memory indexes without reading any
+// record. DiskSize is zero if the data files cannot be examined.
+func (db *Db) Stats() Stats {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	db.appendMu.Lock()
+	defer db.appendMu.Unlock()
+	defer db.rlockShards()()
+
+	current, segmentsSize, segments, _ := db.diskUsage()
+	stats := Stats{
+		Segments:      segments,
+		CurrentOffset: db.outOffset,
+		DiskSize:      current + segmentsSize,
+		CurrentSize:   current,
+		SegmentsSize:  segmentsSize,
+	}
+	stats.CacheHits, stats.CacheMisses = db.cache.stats()
+	for _, s := range db.shards {
+		stats.CurrentKeys += len(s.index)
+		stats.SegmentKeys += len(s.segments)
+	}
+	return stats
+}
+
+// ReadPoolStats describes the load on the read workers.
+type ReadPoolStats struct {
+	Workers int `json:"workers"`
+	// Queued is the number of reads waiting for a worker, including ones
+	// abandoned by their caller that a worker hasn't picked up yet.
+	Queued int `json:"queued"`
+	// InFlight is the number of reads being served by a worker.
+	InFlight int `json:"inFlight"`
+}
+
+// ReadPoolStats reports the current load on the read workers.
+func (db *Db) ReadPoolStats() ReadPoolStats {
+	return db.readerPool.stats()
+}
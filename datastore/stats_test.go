@@ -0,0 +1,38 @@
+package datastore
+
+import "testing"
+
+func TestStats(t *testing.T) {
+	db, err := OpenWithOptions(t.TempDir(), Options{DisableAutoMerge: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, key := range []string{"k1", "k2"} {
+		if err := db.Put(key, "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("k3", "v"); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := db.Stats()
+	recordSize := int64(len((&entry{key: "k3", value: "v"}).Encode()))
+	expected := Stats{
+		CurrentKeys:   1,
+		SegmentKeys:   2,
+		Segments:      1,
+		CurrentOffset: recordSize,
+		DiskSize:      3 * recordSize,
+		CurrentSize:   recordSize,
+		SegmentsSize:  2 * recordSize,
+	}
+	if stats != expected {
+		t.Errorf("Stats() = %+v, wanted %+v", stats, expected)
+	}
+}
@@ -0,0 +1,28 @@
+package datastore
+
+import "context"
+
+// Store is the key-value API shared by Db and the in-memory MemStore, so
+// code that only stores and reads keys can run against either.
+type Store interface {
+	Get(key string) (string, error)
+	GetContext(ctx context.Context, key string) (string, error)
+	GetMulti(keys []string) (map[string]string, error)
+	Exists(key string) bool
+	Keys() []string
+	Put(key, value string) error
+	PutContext(ctx context.Context, key, value string) error
+	PutN(key, value string) (int, error)
+	PutNContext(ctx context.Context, key, value string) (int, error)
+	PutBatch(pairs map[string]string) error
+	PutBatchN(pairs map[string]string) (int, int, error)
+	Delete(key string) error
+	Sync() error
+	Check() error
+	Close() error
+}
+
+var (
+	_ Store = (*Db)(nil)
+	_ Store = (*MemStore)(nil)
+)
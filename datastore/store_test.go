@@ -0,0 +1,136 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestStoreConformance runs the same checks against every Store backend.
+func TestStoreConformance(t *testing.T) {
+	backends := map[string]func(t *testing.T, options ...Option) Store{
+		"disk": func(t *testing.T, options ...Option) Store {
+			db, err := OpenWithOptions(t.TempDir(), options...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return db
+		},
+		"memory": func(t *testing.T, options ...Option) Store {
+			return OpenMemory(options...)
+		},
+	}
+
+	for name, open := range backends {
+		t.Run(name, func(t *testing.T) {
+			t.Run("put/get", func(t *testing.T) {
+				s := open(t)
+				defer s.Close()
+
+				if _, err := s.Get("k"); !errors.Is(err, ErrNotFound) {
+					t.Errorf("Get of a missing key: got %v, want ErrNotFound", err)
+				}
+				n, err := s.PutN("k", "v1")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := len("k") + len("v1") + entryOverhead; n != want {
+					t.Errorf("PutN wrote %d bytes, want %d", n, want)
+				}
+				if err := s.Put("k", "v2"); err != nil {
+					t.Fatal(err)
+				}
+				if value, err := s.Get("k"); err != nil || value != "v2" {
+					t.Errorf("Get: got %q, %v, want %q", value, err, "v2")
+				}
+				if !s.Exists("k") {
+					t.Error("Exists(k) = false after Put")
+				}
+			})
+
+			t.Run("delete", func(t *testing.T) {
+				s := open(t)
+				defer s.Close()
+
+				if err := s.Delete("k"); !errors.Is(err, ErrNotFound) {
+					t.Errorf("Delete of a missing key: got %v, want ErrNotFound", err)
+				}
+				if err := s.Put("k", "v"); err != nil {
+					t.Fatal(err)
+				}
+				if err := s.Delete("k"); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := s.Get("k"); !errors.Is(err, ErrNotFound) {
+					t.Errorf("Get after Delete: got %v, want ErrNotFound", err)
+				}
+				if s.Exists("k") {
+					t.Error("Exists(k) = true after Delete")
+				}
+			})
+
+			t.Run("batch", func(t *testing.T) {
+				s := open(t)
+				defer s.Close()
+
+				pairs := map[string]string{"a": "1", "b": "22", "c": "333"}
+				n, records, err := s.PutBatchN(pairs)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := 3*entryOverhead + 3 + 6; n != want || records != 3 {
+					t.Errorf("PutBatchN: got %d bytes in %d records, want %d in 3", n, records, want)
+				}
+				if keys := s.Keys(); !reflect.DeepEqual(keys, []string{"a", "b", "c"}) {
+					t.Errorf("Keys: got %v", keys)
+				}
+
+				values, err := s.GetMulti([]string{"a", "c", "missing"})
+				var keyErrs KeyErrors
+				if !errors.As(err, &keyErrs) || len(keyErrs) != 1 || !errors.Is(keyErrs["missing"], ErrNotFound) {
+					t.Errorf("GetMulti error: got %v", err)
+				}
+				if !reflect.DeepEqual(values, map[string]string{"a": "1", "c": "333"}) {
+					t.Errorf("GetMulti values: got %v", values)
+				}
+			})
+
+			t.Run("limits", func(t *testing.T) {
+				s := open(t, WithMaxKeyLen(4), WithMaxValueLen(4))
+				defer s.Close()
+
+				if err := s.Put("toolong", "v"); !errors.Is(err, ErrKeyTooLong) {
+					t.Errorf("Put of a long key: got %v, want ErrKeyTooLong", err)
+				}
+				if err := s.Put("k", strings.Repeat("v", 5)); !errors.Is(err, ErrValueTooLong) {
+					t.Errorf("Put of a long value: got %v, want ErrValueTooLong", err)
+				}
+				if err := s.PutBatch(map[string]string{"ok": "v", "k": "toolong"}); !errors.Is(err, ErrValueTooLong) {
+					t.Errorf("PutBatch with a long value: got %v, want ErrValueTooLong", err)
+				}
+				if keys := s.Keys(); len(keys) != 0 {
+					t.Errorf("rejected writes stored keys %v", keys)
+				}
+			})
+
+			t.Run("context", func(t *testing.T) {
+				s := open(t)
+				defer s.Close()
+
+				if err := s.Put("k", "v"); err != nil {
+					t.Fatal(err)
+				}
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				if err := s.PutContext(ctx, "k", "v2"); !errors.Is(err, context.Canceled) {
+					t.Errorf("PutContext: got %v, want context.Canceled", err)
+				}
+				if _, err := s.GetContext(ctx, "k"); !errors.Is(err, context.Canceled) {
+					t.Errorf("GetContext: got %v, want context.Canceled", err)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package datastore
+
+import (
+	"log"
+	"time"
+)
+
+// Sync flushes the writes made so far to disk.
+func (db *Db) Sync() error {
+	if db.readOnly {
+		return nil
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.syncLocked()
+}
+
+// syncLocked must be called with the write lock, or the read lock and
+// appendMu, held.
+func (db *Db) syncLocked() error {
+	if err := db.flushAppendsLocked(); err != nil {
+		return err
+	}
+	if err := db.out.Sync(); err != nil {
+		return err
+	}
+	db.unsynced = 0
+	return nil
+}
+
+// wrote counts a direct write to the current-data file and syncs it once
+// SyncEvery writes have accumulated. The write lock, or the read lock and
+// appendMu, must be held.
+func (db *Db) wrote() error {
+	db.unsynced++
+	if db.syncEvery > 0 && db.unsynced >= db.syncEvery {
+		return db.syncLocked()
+	}
+	return nil
+}
+
+func (db *Db) syncPeriodically() {
+	defer db.bg.Done()
+
+	ticker := time.NewTicker(db.syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			db.mu.Lock()
+			if db.unsynced > 0 {
+				if err := db.syncLocked(); err != nil {
+					log.Printf("datastore: periodic sync failed: %s", err)
+				}
+			}
+			db.mu.Unlock()
+		case <-db.done:
+			return
+		}
+	}
+}
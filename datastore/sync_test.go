@@ -0,0 +1,82 @@
+package datastore
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func unsyncedWrites(db *Db) int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.unsynced
+}
+
+func TestSyncEvery(t *testing.T) {
+	db, err := OpenWithOptions(t.TempDir(), Options{SyncEvery: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Put("k1", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if n := unsyncedWrites(db); n != 1 {
+		t.Errorf("Expected 1 unsynced write, got %d", n)
+	}
+	if err := db.Put("k2", "v2"); err != nil {
+		t.Fatal(err)
+	}
+	if n := unsyncedWrites(db); n != 0 {
+		t.Errorf("Expected writes to be synced after SyncEvery puts, got %d unsynced", n)
+	}
+
+	if err := db.Put("k3", "v3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if n := unsyncedWrites(db); n != 0 {
+		t.Errorf("Expected Sync to flush all writes, got %d unsynced", n)
+	}
+}
+
+func TestSyncInterval(t *testing.T) {
+	db, err := OpenWithOptions(t.TempDir(), Options{SyncInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Put("k1", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for unsyncedWrites(db) != 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := unsyncedWrites(db); n != 0 {
+		t.Errorf("Background sync did not run, %d writes unsynced", n)
+	}
+}
+
+func BenchmarkPutSync(b *testing.B) {
+	for _, syncEvery := range []int{0, 1, 100} {
+		b.Run(fmt.Sprintf("SyncEvery=%d", syncEvery), func(b *testing.B) {
+			db, err := OpenWithOptions(b.TempDir(), Options{SyncEvery: syncEvery})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer db.Close()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := db.Put(fmt.Sprintf("key%d", i%1000), "value"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+var ErrNoTagIndex = fmt.Errorf("secondary tag index is not configured")
+
+// TagFunc extracts the secondary index tag from a value. An empty tag leaves
+// the key out of the index.
+type TagFunc func(value string) string
+
+// PrefixTag returns a TagFunc that tags values by their first n bytes.
+func PrefixTag(n int) TagFunc {
+	return func(value string) string {
+		if len(value) < n {
+			return value
+		}
+		return value[:n]
+	}
+}
+
+type tagIndex struct {
+	fn     TagFunc
+	byTag  map[string]map[string]struct{}
+	keyTag map[string]string
+}
+
+func newTagIndex(fn TagFunc) *tagIndex {
+	return &tagIndex{
+		fn:     fn,
+		byTag:  make(map[string]map[string]struct{}),
+		keyTag: make(map[string]string),
+	}
+}
+
+func (ti *tagIndex) set(key, value string) {
+	ti.remove(key)
+
+	tag := ti.fn(value)
+	if tag == "" {
+		return
+	}
+	keys, ok := ti.byTag[tag]
+	if !ok {
+		keys = make(map[string]struct{})
+		ti.byTag[tag] = keys
+	}
+	keys[key] = struct{}{}
+	ti.keyTag[key] = tag
+}
+
+func (ti *tagIndex) remove(key string) {
+	tag, ok := ti.keyTag[key]
+	if !ok {
+		return
+	}
+	delete(ti.keyTag, key)
+	delete(ti.byTag[tag], key)
+	if len(ti.byTag[tag]) == 0 {
+		delete(ti.byTag, tag)
+	}
+}
+
+// rebuildTags reads every live value to populate the tag index after recovery.
+func (db *Db) rebuildTags() error {
+	for _, s := range db.shards {
+		for key, segInfo := range s.segments {
+			value, err := db.readerPool.read(context.Background(), key, segInfo.file, segInfo.offset)
+			if err != nil {
+				return err
+			}
+			db.tags.set(key, value)
+		}
+		for key, position := range s.index {
+			if _, ok := s.segments[key]; ok {
+				continue
+			}
+			value, err := db.readerPool.read(context.Background(), key, "", position)
+			if err != nil {
+				return err
+			}
+			db.tags.set(key, value)
+		}
+	}
+	return nil
+}
+
+// ByTag returns the sorted keys whose current value maps to tag.
+func (db *Db) ByTag(tag string) ([]string, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	db.appendMu.Lock()
+	defer db.appendMu.Unlock()
+
+	if db.tags == nil {
+		return nil, ErrNoTagIndex
+	}
+	keys := make([]string, 0, len(db.tags.byTag[tag]))
+	for key := range db.tags.byTag[tag] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
@@ -0,0 +1,68 @@
+package datastore
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestByTag(t *testing.T) {
+	tmp := t.TempDir()
+	opts := Options{TagFunc: PrefixTag(3)}
+
+	db, err := OpenWithOptions(tmp, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pairs := [][]string{
+		{"k1", "red-apple"},
+		{"k2", "red-cherry"},
+		{"k3", "grn-pear"},
+		{"k2", "grn-lime"},
+	}
+	for _, pair := range pairs {
+		if err := db.Put(pair[0], pair[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func(db *Db) {
+		t.Helper()
+		for tag, expected := range map[string][]string{
+			"red": {"k1"},
+			"grn": {"k2", "k3"},
+			"blu": {},
+		} {
+			keys, err := db.ByTag(tag)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(keys, expected) {
+				t.Errorf("ByTag(%q) = %v, wanted %v", tag, keys, expected)
+			}
+		}
+	}
+	check(db)
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = OpenWithOptions(tmp, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	check(db)
+}
+
+func TestByTagNotConfigured(t *testing.T) {
+	db, err := Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.ByTag("x"); err != ErrNoTagIndex {
+		t.Errorf("ByTag without TagFunc = %v, expected ErrNoTagIndex", err)
+	}
+}
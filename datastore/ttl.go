@@ -0,0 +1,82 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// expirySweepInterval is how often expired keys are dropped from the index.
+var expirySweepInterval = time.Minute
+
+// PutWithTTL stores value under key until ttl elapses, after which Get
+// reports the key as not found. Merges drop expired records from disk.
+func (db *Db) PutWithTTL(key, value string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("ttl must be positive, got %s", ttl)
+	}
+	_, err := db.write(context.Background(), entry{key: key, value: value, expires: timeNow().Add(ttl).UnixNano()})
+	return err
+}
+
+// trackExpiry records the deadline of the newest record for a key, if any.
+// The shard must be locked for writing.
+func (s *indexShard) trackExpiry(e entry) {
+	if e.expires != 0 && !e.deleted {
+		s.expiries[e.key] = e.expires
+	} else {
+		delete(s.expiries, e.key)
+	}
+}
+
+// expired reports whether key has outlived its TTL. The shard must be locked
+// for reading.
+func (s *indexShard) expired(key string) bool {
+	deadline, ok := s.expiries[key]
+	return ok && timeNow().UnixNano() >= deadline
+}
+
+// sweepExpired periodically drops expired keys from the in-memory index.
+// Their records stay on disk until the next merge.
+func (db *Db) sweepExpired() {
+	defer db.bg.Done()
+
+	ticker := time.NewTicker(expirySweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			db.mu.Lock()
+			db.dropExpired()
+			db.mu.Unlock()
+		case <-db.done:
+			return
+		}
+	}
+}
+
+// dropExpired must be called with the write lock held.
+func (db *Db) dropExpired() {
+	now := timeNow().UnixNano()
+	for _, s := range db.shards {
+		for key, deadline := range s.expiries {
+			if now < deadline {
+				continue
+			}
+			db.dropKey(s, key)
+		}
+	}
+}
+
+// dropKey removes key from s and from the structures derived from the index.
+func (db *Db) dropKey(s *indexShard, key string) {
+	delete(s.expiries, key)
+	delete(s.index, key)
+	delete(s.segments, key)
+	delete(s.versions, key)
+	db.cache.invalidate(key)
+	if db.tags != nil {
+		db.tags.remove(key)
+	}
+}
@@ -0,0 +1,148 @@
+package datastore
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock replaces timeNow for the duration of the test and returns a
+// function that moves the clock forward.
+func fakeClock(t *testing.T) func(d time.Duration) {
+	var (
+		mu  sync.Mutex
+		now = time.Now()
+	)
+	origNow := timeNow
+	timeNow = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	t.Cleanup(func() { timeNow = origNow })
+	return func(d time.Duration) {
+		mu.Lock()
+		now = now.Add(d)
+		mu.Unlock()
+	}
+}
+
+func TestPutWithTTL(t *testing.T) {
+	advance := fakeClock(t)
+	tmp := t.TempDir()
+	opts := Options{DisableAutoMerge: true}
+
+	db, err := OpenWithOptions(tmp, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	if err := db.PutWithTTL("k", "v", 0); err == nil {
+		t.Error("Expected an error for a non-positive TTL")
+	}
+
+	if err := db.Put("old", "forever"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutWithTTL("short", "v1", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutWithTTL("long", "v2", 48*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutWithTTL("cleared", "v3", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("cleared", "v4"); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := db.Get("short"); err != nil || value != "v1" {
+		t.Errorf("Get(short) = %q, %v before expiry", value, err)
+	}
+
+	advance(2 * time.Hour)
+
+	check := func(stage string) {
+		t.Helper()
+		if _, err := db.Get("short"); err != ErrNotFound {
+			t.Errorf("%s: Get(short) returned %v, wanted ErrNotFound", stage, err)
+		}
+		for key, expected := range map[string]string{"long": "v2", "cleared": "v4", "old": "forever"} {
+			if value, err := db.Get(key); err != nil || value != expected {
+				t.Errorf("%s: Get(%q) = %q, %v; wanted %q", stage, key, value, err, expected)
+			}
+		}
+	}
+	check("after expiry")
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = OpenWithOptions(tmp, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("after reopen")
+
+	if _, err := db.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("filler", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CompactNow(); err != nil {
+		t.Fatal(err)
+	}
+	check("after merge")
+
+	segmentFiles, err := db.segmentFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged, err := latestValues(t.Context(), segmentFiles, false, func(int) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := merged["short"]; ok {
+		t.Error("Merge kept the expired record on disk")
+	}
+	if merged["long"].expires == 0 {
+		t.Error("Merge dropped the expiry of a live record")
+	}
+}
+
+func TestSweepExpired(t *testing.T) {
+	advance := fakeClock(t)
+	origInterval := expirySweepInterval
+	expirySweepInterval = 10 * time.Millisecond
+	defer func() { expirySweepInterval = origInterval }()
+
+	db, err := Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.PutWithTTL("k", "v", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	advance(time.Hour)
+
+	indexed := func() bool {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		_, ok := db.shard("k").index["k"]
+		return ok
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for indexed() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if indexed() {
+		t.Error("Expired key was not swept from the index")
+	}
+}
@@ -0,0 +1,116 @@
+package datastore
+
+import (
+	"strconv"
+	"strings"
+)
+
+// txMarkerPrefix starts the value of the marker record written ahead of the
+// records of a transaction, followed by their number. The marker uses the
+// reserved walMarkerKey, so readers of sealed segments skip it like a commit
+// marker.
+const txMarkerPrefix = "tx "
+
+// Tx collects the writes of a transaction. It is only valid inside the
+// function passed to Transaction.
+type Tx struct {
+	ops []entry
+}
+
+// Put stores value under key when the transaction commits.
+func (tx *Tx) Put(key, value string) {
+	tx.ops = append(tx.ops, entry{key: key, value: value})
+}
+
+// Delete removes key when the transaction commits. Deleting a key that is
+// absent by then does nothing.
+func (tx *Tx) Delete(key string) {
+	tx.ops = append(tx.ops, entry{key: key, deleted: true})
+}
+
+// Transaction calls fn and applies the writes it made to tx atomically: all
+// of them are written with one call under the write lock, ahead of a marker
+// that lets recovery drop a transaction cut short by a crash. If fn returns
+// an error, nothing is written and Transaction returns it.
+func (db *Db) Transaction(fn func(tx *Tx) error) error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	var tx Tx
+	if err := fn(&tx); err != nil {
+		return err
+	}
+	if len(tx.ops) == 0 {
+		return nil
+	}
+
+	records := make([]entry, len(tx.ops))
+	for i, e := range tx.ops {
+		if err := db.limits.check(e.key, e.value); err != nil {
+			return err
+		}
+		var err error
+		if records[i], err = db.compress(e); err != nil {
+			return err
+		}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// exists tracks the keys the transaction itself has put or deleted so
+	// far, which the index doesn't reflect yet.
+	exists := make(map[string]bool)
+	live := records[:0]
+	for _, e := range records {
+		present, ok := exists[e.key]
+		if !ok {
+			present = db.existsLocked(e.key)
+		}
+		if e.deleted && !present {
+			continue
+		}
+		exists[e.key] = !e.deleted
+		live = append(live, e)
+	}
+	if len(live) == 0 {
+		return nil
+	}
+
+	marker := entry{key: walMarkerKey, value: txMarkerPrefix + strconv.Itoa(len(live))}
+	chunk := marker.Encode()
+	write, overhead := db.writeRaw, 0
+	if db.wal != nil {
+		write, overhead = db.writeChunk, walMarkerMaxSize
+	}
+	size := len(chunk) + overhead
+	for _, e := range live {
+		size += len(e.Encode())
+	}
+	if db.outOffset > 0 && db.overflows(size) {
+		if err := db.createNewSegment(); err != nil {
+			return err
+		}
+	}
+
+	written := make([]walRecord, len(live))
+	for i, e := range live {
+		written[i] = walRecord{e: e, offset: db.outOffset + int64(len(chunk))}
+		chunk = append(chunk, e.Encode()...)
+	}
+	return write(chunk, written)
+}
+
+// txSize reports whether e is the marker of a transaction and how many
+// records follow it.
+func txSize(e entry) (int, bool) {
+	if e.key != walMarkerKey {
+		return 0, false
+	}
+	count, ok := strings.CutPrefix(e.value, txMarkerPrefix)
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(count)
+	return n, err == nil
+}
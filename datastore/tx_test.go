@@ -0,0 +1,172 @@
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTransaction(t *testing.T) {
+	for _, opts := range []Options{{SegmentSize: 120, DisableAutoMerge: true}, {WriteBuffer: 1024}} {
+		t.Run(fmt.Sprintf("WriteBuffer=%d", opts.WriteBuffer), func(t *testing.T) {
+			tmp := t.TempDir()
+			db, err := OpenWithOptions(tmp, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Put("old", "value"); err != nil {
+				t.Fatal(err)
+			}
+
+			err = db.Transaction(func(tx *Tx) error {
+				tx.Put("a", "1")
+				tx.Put("b", "2")
+				tx.Delete("old")
+				tx.Delete("absent")
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			errRollback := errors.New("rollback")
+			err = db.Transaction(func(tx *Tx) error {
+				tx.Put("a", "changed")
+				tx.Put("c", "3")
+				return errRollback
+			})
+			if err != errRollback {
+				t.Errorf("Transaction returned %v, expected the error of fn", err)
+			}
+
+			check := func(stage string) {
+				t.Helper()
+				for key, expected := range map[string]string{"a": "1", "b": "2"} {
+					if value, err := db.Get(key); err != nil || value != expected {
+						t.Errorf("%s: Get(%s) = %q, %v; expected %q", stage, key, value, err, expected)
+					}
+				}
+				for _, key := range []string{"old", "c"} {
+					if _, err := db.Get(key); err != ErrNotFound {
+						t.Errorf("%s: Get(%s) returned %v, expected ErrNotFound", stage, key, err)
+					}
+				}
+			}
+			check("after commit")
+
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if db, err = OpenWithOptions(tmp, opts); err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			check("after reopen")
+		})
+	}
+}
+
+func TestTransactionPutThenDelete(t *testing.T) {
+	for _, opts := range []Options{{DisableAutoMerge: true}, {WriteBuffer: 1024}} {
+		t.Run(fmt.Sprintf("WriteBuffer=%d", opts.WriteBuffer), func(t *testing.T) {
+			tmp := t.TempDir()
+			db, err := OpenWithOptions(tmp, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Put("old", "value"); err != nil {
+				t.Fatal(err)
+			}
+
+			err = db.Transaction(func(tx *Tx) error {
+				tx.Put("new", "v")
+				tx.Delete("new")
+				tx.Delete("old")
+				tx.Put("old", "again")
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			check := func(stage string) {
+				t.Helper()
+				if value, err := db.Get("new"); err != ErrNotFound {
+					t.Errorf("%s: Get(new) = %q, %v; expected ErrNotFound", stage, value, err)
+				}
+				if value, err := db.Get("old"); err != nil || value != "again" {
+					t.Errorf("%s: Get(old) = %q, %v; expected %q", stage, value, err, "again")
+				}
+			}
+			check("after commit")
+
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if db, err = OpenWithOptions(tmp, opts); err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			check("after reopen")
+		})
+	}
+}
+
+func TestTransactionTorn(t *testing.T) {
+	for _, opts := range []Options{{}, {WriteBuffer: 1024}} {
+		t.Run(fmt.Sprintf("WriteBuffer=%d", opts.WriteBuffer), func(t *testing.T) {
+			tmp := t.TempDir()
+			db, err := OpenWithOptions(tmp, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Put("before", "value"); err != nil {
+				t.Fatal(err)
+			}
+			err = db.Transaction(func(tx *Tx) error {
+				tx.Put("a", "1")
+				tx.Put("b", "2")
+				tx.Put("c", "3")
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			db.Close()
+
+			// Cut the file inside the last record of the transaction, and
+			// then right after its second record, as a crash during the
+			// write could.
+			path := filepath.Join(tmp, outFileName)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			recordSize := len((&entry{key: "a", value: "1"}).Encode())
+			end := len(data)
+			if opts.WriteBuffer > 0 {
+				end -= len((&entry{key: walMarkerKey, value: "3"}).Encode())
+			}
+			for _, size := range []int{end - 3, end - recordSize} {
+				if err := os.WriteFile(path, data[:size], 0o600); err != nil {
+					t.Fatal(err)
+				}
+				db, err = OpenWithOptions(tmp, opts)
+				if err != nil {
+					t.Fatalf("Open after a torn transaction: %v", err)
+				}
+				if value, err := db.Get("before"); err != nil || value != "value" {
+					t.Errorf("Get(before) = %q, %v", value, err)
+				}
+				for _, key := range []string{"a", "b", "c"} {
+					if _, err := db.Get(key); err != ErrNotFound {
+						t.Errorf("Get(%s) returned %v, expected the torn transaction to be dropped", key, err)
+					}
+				}
+				db.Close()
+			}
+		})
+	}
+}
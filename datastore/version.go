@@ -0,0 +1,34 @@
+package datastore
+
+import "context"
+
+// GetWithVersion is Get that also returns the version of the value: the
+// sequence number (see Seq) of the write that stored it. A key's version
+// grows with every Put and Delete of it, so a different version means the
+// value may have changed. Like sequence numbers, versions restart when the
+// store is opened: values read from disk by Open have version 0 until they
+// are written again.
+func (db *Db) GetWithVersion(key string) (string, uint64, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	s := db.shard(key)
+	version := func() uint64 {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		return s.versions[key]
+	}
+	// A Put may be applied while the value is read; retry until the version
+	// is the same before and after.
+	for {
+		before := version()
+		value, err := db.getLocked(context.Background(), key)
+		if after := version(); after != before {
+			continue
+		}
+		if err != nil {
+			return "", 0, err
+		}
+		return value, before, nil
+	}
+}
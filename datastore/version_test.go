@@ -0,0 +1,65 @@
+package datastore
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestGetWithVersion(t *testing.T) {
+	dir := t.TempDir()
+	db, err := OpenWithOptions(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := db.GetWithVersion("k"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetWithVersion of a missing key: got %v, want ErrNotFound", err)
+	}
+
+	var last uint64
+	for _, value := range []string{"v1", "v2", "v2"} {
+		if err := db.Put("k", value); err != nil {
+			t.Fatal(err)
+		}
+		got, version, err := db.GetWithVersion("k")
+		if err != nil || got != value {
+			t.Fatalf("GetWithVersion: got %q, %v, want %q", got, err, value)
+		}
+		if version <= last {
+			t.Errorf("version %d after Put of %q should exceed %d", version, value, last)
+		}
+		last = version
+	}
+
+	if err := db.Put("fresh", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if _, version, _ := db.GetWithVersion("fresh"); version == last {
+		t.Errorf("a fresh key and an overwritten key share version %d", version)
+	}
+
+	if err := db.Delete("k"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := db.GetWithVersion("k"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetWithVersion after Delete: got %v, want ErrNotFound", err)
+	}
+	if err := db.Put("k", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, version, _ := db.GetWithVersion("k"); version <= last {
+		t.Errorf("version %d after Delete and Put should exceed %d", version, last)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = OpenWithOptions(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if value, version, err := db.GetWithVersion("k"); err != nil || value != "v1" || version != 0 {
+		t.Errorf("after reopening: got %q, %d, %v, want v1 at version 0", value, version, err)
+	}
+}
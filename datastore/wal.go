@@ -0,0 +1,164 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// walMarkerKey is the key of the commit marker record written after every
+// batch flushed from the write buffer. It is reserved and never indexed.
+const walMarkerKey = "\x00wal-commit"
+
+// walMarkerMaxSize bounds the encoded size of a commit marker.
+const walMarkerMaxSize = entryOverhead + len(walMarkerKey) + 10
+
+var ErrReservedKey = fmt.Errorf("key is reserved for internal use")
+
+type walRecord struct {
+	e      entry
+	offset int64
+}
+
+type writeBatch struct {
+	records []entry
+	done    chan struct{}
+	err     error
+	// size is the encoded size of records.
+	size int
+}
+
+// writeBuffer coalesces concurrent Puts into batches that a single flusher
+// appends to the current-data file with one write and one fsync, followed by
+// a commit marker. A Put returns only after its batch is on disk, so every
+// acknowledged write is recoverable; recovery discards a batch whose marker
+// is missing, which can only belong to Puts that never returned.
+type writeBuffer struct {
+	limit    int
+	flushReq chan struct{}
+
+	// mu guards batch, so Puts can join the next batch while the flusher
+	// writes the previous one.
+	mu    sync.Mutex
+	batch *writeBatch
+}
+
+func newWriteBuffer(limit int) *writeBuffer {
+	return &writeBuffer{
+		limit:    limit,
+		flushReq: make(chan struct{}, 1),
+	}
+}
+
+func (wb *writeBuffer) signal() {
+	select {
+	case wb.flushReq <- struct{}{}:
+	default:
+	}
+}
+
+// putBuffered adds e, whose encoding is size bytes long, to the next batch
+// and waits until the batch is on disk.
+func (db *Db) putBuffered(ctx context.Context, e entry, size int) error {
+	wal := db.wal
+	wal.mu.Lock()
+	for wal.batch != nil && wal.batch.size+size > wal.limit {
+		b := wal.batch
+		wal.mu.Unlock()
+		wal.signal()
+		select {
+		case <-b.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		wal.mu.Lock()
+	}
+	if wal.batch == nil {
+		wal.batch = &writeBatch{done: make(chan struct{})}
+	}
+	b := wal.batch
+	b.records = append(b.records, e)
+	b.size += size
+	wal.mu.Unlock()
+
+	wal.signal()
+	select {
+	case <-b.done:
+		return b.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (db *Db) runFlusher() {
+	defer db.bg.Done()
+
+	for {
+		select {
+		case <-db.wal.flushReq:
+			db.flushWriteBuffer()
+		case <-db.done:
+			db.flushWriteBuffer()
+			return
+		}
+	}
+}
+
+// flushWriteBuffer writes the pending batch. Like a direct Put, a batch that
+// fits the current-data file is written and synced holding only the read
+// lock and appendMu, so reads carry on during the fsync. A batch that needs
+// a rollover takes the write lock.
+func (db *Db) flushWriteBuffer() {
+	db.mu.RLock()
+	db.appendMu.Lock()
+	db.wal.mu.Lock()
+	b := db.wal.batch
+	db.wal.batch = nil
+	db.wal.mu.Unlock()
+	if b == nil {
+		db.appendMu.Unlock()
+		db.mu.RUnlock()
+		return
+	}
+
+	if !db.overflows(b.size + walMarkerMaxSize) {
+		b.err = db.writeCommitted(b.records)
+		db.appendMu.Unlock()
+		db.mu.RUnlock()
+	} else {
+		db.appendMu.Unlock()
+		db.mu.RUnlock()
+		db.mu.Lock()
+		b.err = db.writeCommitted(b.records)
+		db.mu.Unlock()
+	}
+	close(b.done)
+}
+
+// writeCommitted appends records as committed chunks, sealing the current
+// file between chunks when the segment size would be exceeded.
+func (db *Db) writeCommitted(records []entry) error {
+	return db.writeChunked(records, walMarkerMaxSize, db.writeChunk)
+}
+
+func (db *Db) writeChunk(chunk []byte, written []walRecord) error {
+	marker := entry{key: walMarkerKey, value: strconv.Itoa(len(written))}
+	chunk = append(chunk, marker.Encode()...)
+
+	if err := db.flushAppendsLocked(); err != nil {
+		return err
+	}
+	if _, err := db.out.Write(chunk); err != nil {
+		return err
+	}
+	if err := db.out.Sync(); err != nil {
+		return err
+	}
+
+	for _, r := range written {
+		db.applyEntry(r.e, r.offset)
+	}
+	db.outOffset += int64(len(chunk))
+	return nil
+}
@@ -0,0 +1,210 @@
+package datastore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestWriteBufferConcurrentPuts(t *testing.T) {
+	tmp := t.TempDir()
+	opts := Options{WriteBuffer: 256, SegmentSize: 512}
+	db, err := OpenWithOptions(tmp, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				key := fmt.Sprintf("w%d-k%d", w, i)
+				if err := db.Put(key, key); err != nil {
+					t.Errorf("Put(%q): %v", key, err)
+					return
+				}
+				if value, err := db.Get(key); err != nil || value != key {
+					t.Errorf("Get(%q) right after Put = %q, %v", key, value, err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = OpenWithOptions(tmp, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for w := 0; w < 8; w++ {
+		for i := 0; i < 20; i++ {
+			key := fmt.Sprintf("w%d-k%d", w, i)
+			if value, err := db.Get(key); err != nil || value != key {
+				t.Errorf("Get(%q) after reopen = %q, %v", key, value, err)
+			}
+		}
+	}
+}
+
+func TestWriteBufferCrashConsistency(t *testing.T) {
+	tmp := t.TempDir()
+	opts := Options{WriteBuffer: 1024}
+	db, err := OpenWithOptions(tmp, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if err := db.Put(key, "acked-"+key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a crash in the middle of flushing a batch: one complete record
+	// without its commit marker, followed by a torn one.
+	path := filepath.Join(tmp, outFileName)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	torn := (&entry{key: "lost2", value: "never acknowledged"}).Encode()
+	f.Write((&entry{key: "lost1", value: "never acknowledged"}).Encode())
+	f.Write(torn[:len(torn)/2])
+	f.Close()
+
+	db, err = OpenWithOptions(tmp, opts)
+	if err != nil {
+		t.Fatalf("Open after torn batch failed: %v", err)
+	}
+	defer db.Close()
+
+	for _, key := range []string{"a", "b", "c"} {
+		if value, err := db.Get(key); err != nil || value != "acked-"+key {
+			t.Errorf("Acknowledged write %q lost: %q, %v", key, value, err)
+		}
+	}
+	for _, key := range []string{"lost1", "lost2"} {
+		if _, err := db.Get(key); err != ErrNotFound {
+			t.Errorf("Unacknowledged write %q recovered: %v", key, err)
+		}
+	}
+	if after, err := os.Stat(path); err != nil || after.Size() != info.Size() {
+		t.Errorf("Uncommitted tail was not truncated (err: %v)", err)
+	}
+
+	if err := db.Put("d", "after-crash"); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := db.Get("d"); err != nil || value != "after-crash" {
+		t.Errorf("Get(d) = %q, %v", value, err)
+	}
+}
+
+func TestWriteBufferOpensUnbufferedStore(t *testing.T) {
+	tmp := t.TempDir()
+	db, err := OpenWithOptions(tmp, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b"} {
+		if err := db.Put(key, "unbuffered-"+key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(tmp, outFileName)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The file has no commit markers, which must not make its records
+	// look uncommitted.
+	opts := Options{WriteBuffer: 1024}
+	db, err = OpenWithOptions(tmp, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after, err := os.Stat(path); err != nil || after.Size() != info.Size() {
+		t.Fatalf("Opening with the write buffer truncated the current-data file (err: %v)", err)
+	}
+	if err := db.Put("c", "buffered-c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = OpenWithOptions(tmp, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for key, expected := range map[string]string{"a": "unbuffered-a", "b": "unbuffered-b", "c": "buffered-c"} {
+		if value, err := db.Get(key); err != nil || value != expected {
+			t.Errorf("Get(%q) = %q, %v; wanted %q", key, value, err, expected)
+		}
+	}
+}
+
+// BenchmarkSmallWrites compares group commit against writing and fsyncing
+// every Put on its own, which is what the buffer's durability guarantee
+// would otherwise cost.
+func BenchmarkSmallWrites(b *testing.B) {
+	syncEach := func(db *Db) error {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		return db.out.Sync()
+	}
+	for _, bc := range []struct {
+		name  string
+		opts  Options
+		after func(db *Db) error
+	}{
+		{"direct", Options{}, nil},
+		{"direct-fsync", Options{}, syncEach},
+		{"buffered-fsync", Options{WriteBuffer: 64 << 10}, nil},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			db, err := OpenWithOptions(b.TempDir(), bc.opts)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer db.Close()
+
+			var n atomic.Int64
+			b.SetParallelism(16)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					key := fmt.Sprintf("key%d", n.Add(1)%1024)
+					if err := db.Put(key, "value"); err != nil {
+						b.Fatal(err)
+					}
+					if bc.after != nil {
+						if err := bc.after(db); err != nil {
+							b.Fatal(err)
+						}
+					}
+				}
+			})
+		})
+	}
+}
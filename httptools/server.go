@@ -1,36 +1,88 @@
 package httptools
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"time"
 )
 
 type Server interface {
 	Start()
+	// Shutdown stops accepting connections and waits for active requests to
+	// finish or ctx to be done.
+	Shutdown(ctx context.Context) error
+}
+
+// Config tunes the listener and timeouts of a server created with
+// CreateServerWithConfig. Zero values fall back to the defaults used by CreateServer.
+type Config struct {
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	// MaxConns caps the number of simultaneously open connections; zero means unlimited.
+	MaxConns int
+}
+
+var defaultConfig = Config{
+	ReadTimeout:  10 * time.Second,
+	WriteTimeout: 10 * time.Second,
 }
 
 type server struct {
 	httpServer *http.Server
+	maxConns   int
 }
 
 func (s server) Start() {
 	go func() {
 		log.Println("Starting the HTTP server...")
-		err := s.httpServer.ListenAndServe()
+		err := s.listenAndServe()
+		if errors.Is(err, http.ErrServerClosed) {
+			return
+		}
 		log.Fatalf("HTTP server finished: %s. Finishing the process.", err)
 	}()
 }
 
+func (s server) Shutdown(ctx context.Context) error {
+	return s.httpServer.Shutdown(ctx)
+}
+
+func (s server) listenAndServe() error {
+	if s.maxConns <= 0 {
+		return s.httpServer.ListenAndServe()
+	}
+	l, err := net.Listen("tcp", s.httpServer.Addr)
+	if err != nil {
+		return err
+	}
+	return s.httpServer.Serve(LimitListener(l, s.maxConns))
+}
+
 func CreateServer(port int, handler http.Handler) Server {
+	return CreateServerWithConfig(port, handler, defaultConfig)
+}
+
+func CreateServerWithConfig(port int, handler http.Handler, cfg Config) Server {
+	if cfg.ReadTimeout <= 0 {
+		cfg.ReadTimeout = defaultConfig.ReadTimeout
+	}
+	if cfg.WriteTimeout <= 0 {
+		cfg.WriteTimeout = defaultConfig.WriteTimeout
+	}
 	return server{
 		httpServer: &http.Server{
 			Addr:           fmt.Sprintf(":%d", port),
 			Handler:        handler,
-			ReadTimeout:    10 * time.Second,
-			WriteTimeout:   10 * time.Second,
+			ReadTimeout:    cfg.ReadTimeout,
+			WriteTimeout:   cfg.WriteTimeout,
+			IdleTimeout:    cfg.IdleTimeout,
 			MaxHeaderBytes: 1 << 20,
 		},
+		maxConns: cfg.MaxConns,
 	}
 }
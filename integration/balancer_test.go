@@ -13,7 +13,11 @@ import (
 	"time"
 )
 
-const requests = 30
+const (
+	requests         = 30
+	expectedBackends = 3
+	balancerAddr     = "http://balancer:8090"
+)
 
 func waitForBalancer(t *testing.T, url string, timeout time.Duration) {
 	deadline := time.Now().Add(timeout)
@@ -28,15 +32,58 @@ func waitForBalancer(t *testing.T, url string, timeout time.Duration) {
 	t.Fatalf("Balancer at %s did not accept connections within %v", url, timeout)
 }
 
+func fetchHealthy(url string) (int, []string, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Healthy []string `json:"healthy"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return resp.StatusCode, nil, err
+	}
+	return resp.StatusCode, body.Healthy, nil
+}
+
+func waitForReady(t *testing.T, url string, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if status, _, err := fetchHealthy(url); err == nil && status == http.StatusOK {
+			return
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	t.Fatalf("Balancer at %s did not report a healthy backend within %v", url, timeout)
+}
+
+func waitForHealthyBackends(t *testing.T, url string, expected int, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	var healthy []string
+	for time.Now().Before(deadline) {
+		var err error
+		if _, healthy, err = fetchHealthy(url); err == nil && len(healthy) >= expected {
+			return
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	t.Fatalf("Expected %d healthy backends within %v, got %d: %v", expected, timeout, len(healthy), healthy)
+}
+
 func TestBalancerDistribution(t *testing.T) {
 	teamName := os.Getenv("TEAM_NAME")
 	if teamName == "" {
 		t.Fatal("Environment variable TEAM_NAME is required")
 	}
 
-	url := fmt.Sprintf("http://balancer:8090/api/v1/some-data?key=%s", teamName)
+	url := fmt.Sprintf("%s/api/v1/some-data?key=%s", balancerAddr, teamName)
+	readyURL := balancerAddr + "/ready"
 
-	waitForBalancer(t, url, 10*time.Second)
+	waitForBalancer(t, readyURL, 10*time.Second)
+	waitForReady(t, readyURL, 30*time.Second)
+	waitForHealthyBackends(t, readyURL, expectedBackends, 30*time.Second)
 
 	seen := make(map[string]struct{})
 	var mu sync.Mutex
@@ -99,4 +146,19 @@ func TestBalancerDistribution(t *testing.T) {
 	} else {
 		t.Logf("Distributed across %d servers: %v", len(servers), servers)
 	}
+
+	// /report is forwarded like any other path, to a server that has
+	// served some of the requests above.
+	resp, err := http.Get(balancerAddr + "/report")
+	if err != nil {
+		t.Fatalf("Fetching /report failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var report map[string][]string
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatalf("Invalid /report JSON: %v", err)
+	}
+	if len(report) == 0 {
+		t.Errorf("Expected /report to list the balancer's requests, got %v", report)
+	}
 }
@@ -0,0 +1,64 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func restoreDefault(t *testing.T) {
+	orig := slog.Default()
+	flags, out := log.Flags(), log.Writer()
+	t.Cleanup(func() {
+		slog.SetDefault(orig)
+		log.SetFlags(flags)
+		log.SetOutput(out)
+	})
+}
+
+func TestSetup_JSON(t *testing.T) {
+	restoreDefault(t)
+	var buf bytes.Buffer
+	require.NoError(t, Setup(&buf, FormatJSON, "info"))
+
+	slog.Info("forwarded", "backend", "server1:8080", "status", 200)
+	slog.Debug("dropped below the level")
+	log.Printf("from the log package")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	var records []map[string]any
+	for _, line := range lines {
+		var record map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &record), "every line should be a JSON object: %s", line)
+		records = append(records, record)
+	}
+	assert.Equal(t, "forwarded", records[0]["msg"])
+	assert.Equal(t, "INFO", records[0]["level"])
+	assert.Equal(t, "server1:8080", records[0]["backend"])
+	assert.EqualValues(t, 200, records[0]["status"])
+	assert.Equal(t, "from the log package", records[1]["msg"])
+}
+
+func TestSetup_Text(t *testing.T) {
+	restoreDefault(t)
+	var buf bytes.Buffer
+	require.NoError(t, Setup(&buf, FormatText, "warn"))
+
+	slog.Info("dropped")
+	slog.Warn("kept", "key", "k")
+	assert.Contains(t, buf.String(), "level=WARN msg=kept key=k")
+	assert.NotContains(t, buf.String(), "dropped")
+}
+
+func TestSetup_Invalid(t *testing.T) {
+	restoreDefault(t)
+	assert.Error(t, Setup(&bytes.Buffer{}, "xml", "info"))
+	assert.Error(t, Setup(&bytes.Buffer{}, FormatJSON, "loud"))
+}
@@ -0,0 +1,64 @@
+package tracing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+func TestMiddleware(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	origProvider, origPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer func() {
+		otel.SetTracerProvider(origProvider)
+		otel.SetTextMapPropagator(origPropagator)
+	}()
+
+	var outgoing http.Header
+	handler := Middleware("test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Key(r.Context(), "k")
+		outgoing = make(http.Header)
+		Inject(r.Context(), outgoing)
+		w.WriteHeader(http.StatusBadGateway)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest("GET", "/path", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	require.Len(t, spans.Ended(), 1)
+	span := spans.Ended()[0]
+	assert.Equal(t, "00f067aa0ba902b7", span.Parent().SpanID().String())
+	assert.Contains(t, span.Attributes(), semconv.HTTPResponseStatusCode(http.StatusBadGateway), "the first status written should be recorded")
+	assert.Contains(t, span.Attributes(), semconv.URLPath("/path"))
+	assert.Equal(t, codes.Error, span.Status().Code)
+	assert.Contains(t, outgoing.Get("traceparent"), span.SpanContext().SpanID().String(),
+		"requests sent by the handler should continue its span")
+}
+
+func TestSetupWithoutEndpoint(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	origProvider, origPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	defer func() {
+		otel.SetTracerProvider(origProvider)
+		otel.SetTextMapPropagator(origPropagator)
+	}()
+
+	shutdown, err := Setup(t.Context(), "test")
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(t.Context()))
+	assert.Equal(t, origProvider, otel.GetTracerProvider(), "no tracer provider should be installed without an endpoint")
+}